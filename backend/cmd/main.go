@@ -2,13 +2,20 @@ package main
 
 import (
 	"backend/database"
+	"backend/middleware"
+	"backend/migrations"
+	"backend/models"
+	"backend/realtime"
 	"backend/routers"
-	"fmt"
+	"backend/webhooks"
+	"context"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
@@ -21,18 +28,8 @@ func main() {
 		log.Printf("Error loading .env file:, assuming variables are set in the environment.... %v", err)
 
 	}
-	dbUser := os.Getenv("DB_USER")
-
-	dbPass := os.Getenv("DB_PASS")
-
 	jwtkey := []byte(os.Getenv("JWT_KEY"))
 
-	dbHost := os.Getenv("DB_HOST")
-	dbPort, err := strconv.Atoi(os.Getenv("DB_PORT"))
-	if err != nil {
-		log.Fatalf("Invalid DB_PORT: %v", err)
-	}
-	dbName := os.Getenv("DB_NAME")
 	frontendURLs := os.Getenv("FRONTEND_URL")
 
 	allowedOrigins := []string{"http://localhost:5173"}
@@ -43,8 +40,48 @@ func main() {
 			allowedOrigins = append(allowedOrigins, strings.TrimSpace(url))
 		}
 	}
-	db := database.InitDB(dbUser, dbPass, dbHost, dbPort, dbName)
-	router := routers.SetupRouter(db, jwtkey)
+	//Comma separated list of the reaction kinds, like is always included
+	if kinds := os.Getenv("REACTION_KINDS"); kinds != "" {
+		if err := models.SetReactionKinds(strings.Split(kinds, ",")); err != nil {
+			log.Fatalf("Invalid REACTION_KINDS: %v", err)
+		}
+	}
+	//Deadline of the database queries of a request, QUERY_TIMEOUTS overrides it per route, e.g. /api/search=2s,/api/feed=3s
+	timeouts, err := middleware.ParseQueryTimeouts(os.Getenv("QUERY_TIMEOUT"), os.Getenv("QUERY_TIMEOUTS"))
+	if err != nil {
+		log.Fatalf("Invalid QUERY_TIMEOUT or QUERY_TIMEOUTS: %v", err)
+	}
+	//Comma separated IPs and CIDR ranges of the reverse proxies, X-Forwarded-For is ignored on requests from anywhere else
+	proxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	//MySQL by default, DB_DRIVER=sqlite runs without any database server
+	db := database.FromEnv()
+	//SQLite databases belong to this process alone, a file one as much as :memory:, so they are always migrated.
+	//Other databases are migrated with go run ./cmd/migrate up, or here when MIGRATE_ON_START=true
+	if os.Getenv("MIGRATE_ON_START") == "true" || database.DialectOf(db) == database.SQLite {
+		applied, err := migrations.Up(db)
+		if err != nil {
+			log.Fatalf("Error migrating the database: %v", err)
+		}
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+	}
+	//Cancelled on Ctrl+C or SIGTERM, which stops the webhook worker and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	//Sends queued webhook deliveries in the background, WEBHOOK_ALLOW_PRIVATE=true lets webhooks reach local receivers during development.
+	//The queue lives in the database, so without one there are no webhooks.
+	var dispatcher *webhooks.Dispatcher
+	if db != nil {
+		dispatcher = &webhooks.Dispatcher{DB: db, Client: webhooks.NewClient(os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true")}
+		go dispatcher.Run(ctx, 5*time.Second)
+	}
+	//Pushes new posts, comments, likes and notifications to the live streams
+	hub := realtime.NewHub()
+	router := routers.SetupRouter(models.NewStores(db, routers.NotificationListener(hub)), hub, jwtkey, allowedOrigins, timeouts, proxies, dispatcher)
 	c := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -56,8 +93,14 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	handler := c.Handler(router)
-	http.ListenAndServe(":"+port, handler)
-
-	fmt.Println("Database connected!", db)
+	server := &http.Server{Addr: ":" + port, Handler: c.Handler(router)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Error starting the server: %v", err)
+	}
 }
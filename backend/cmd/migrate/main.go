@@ -0,0 +1,101 @@
+// Command migrate applies the embedded schema migrations to the database configured by the environment
+// (DB_DRIVER and the DB_* settings, like the server).
+//
+//	go run ./cmd/migrate up              apply every pending migration
+//	go run ./cmd/migrate down [n]        roll back the last n migrations, 1 by default
+//	go run ./cmd/migrate status          list the migrations and when they were applied
+//	go run ./cmd/migrate create <name>   add empty up and down files for every database under -dir
+package main
+
+import (
+	"backend/database"
+	"backend/migrations"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	dir := flag.String("dir", "migrations", "directory that create writes the new files to")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: migrate [-dir migrations] up | down [n] | status | create <name>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	//create only writes files, it doesn't need a database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		paths, err := migrations.Create(*dir, args[1])
+		if err != nil {
+			log.Fatalf("Error creating migration: %v", err)
+		}
+		for _, p := range paths {
+			fmt.Println("created", p)
+		}
+		return
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Error loading .env file:, assuming variables are set in the environment.... %v", err)
+	}
+	db := database.FromEnv()
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(db)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Error migrating: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Already up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid number of migrations %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := migrations.Down(db, steps)
+		for _, m := range reverted {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Error rolling back: %v", err)
+		}
+	case "status":
+		statuses, err := migrations.List(db)
+		if err != nil {
+			log.Fatalf("Error reading migrations: %v", err)
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,44 @@
+// Command reconcile-likes recomputes the like counters of posts and comments from the like tables.
+// The counters are updated together with the like tables, so this is only needed to repair drift
+// left behind by older versions or by manual changes to the database.
+//
+//	go run ./cmd/reconcile-likes [-dry-run]
+package main
+
+import (
+	"backend/database"
+	"backend/models"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report the counters that are off")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Error loading .env file:, assuming variables are set in the environment.... %v", err)
+	}
+	db := database.FromEnv()
+	defer db.Close()
+
+	posts, comments, err := models.ReconcileLikes(context.Background(), db, *dryRun)
+	if err != nil {
+		log.Fatalf("Error reconciling likes: %v", err)
+	}
+	verb := "Fixed"
+	if *dryRun {
+		verb = "Found"
+	}
+	for _, d := range posts {
+		fmt.Printf("post %d: likes %d -> %d\n", d.ID, d.Likes, d.Actual)
+	}
+	for _, d := range comments {
+		fmt.Printf("comment %d: likes %d -> %d\n", d.ID, d.Likes, d.Actual)
+	}
+	fmt.Printf("%s %d post and %d comment like counters that were off\n", verb, len(posts), len(comments))
+}
@@ -4,16 +4,64 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
 )
 
 func InitDB(username string, password string, host string, port int, dbName string) *sql.DB {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", username, password, host, port, dbName)
+	return InitMySQL(fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", username, password, host, port, dbName))
+}
 
+// Opens a MySQL database from a go-sql-driver DSN, which needs parseTime=true
+func InitMySQL(dsn string) *sql.DB {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		log.Fatal("Error validating sql.Open arguments: ", err)
 	}
 	return db
 }
+
+// Opens a SQLite database, dsn is a file path or :memory: for a database that only lives as long as the process
+func InitSQLite(dsn string) *sql.DB {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	//Foreign keys are off by default in SQLite and the cascades depend on them.
+	//Times are stored in one fixed format so that they compare correctly as text.
+	db, err := sql.Open("sqlite", dsn+sep+"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite")
+	if err != nil {
+		log.Fatal("Error validating sql.Open arguments: ", err)
+	}
+	//SQLite has a single writer anyway, and every connection to :memory: would get its own empty database
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+// Opens the database configured in the environment. DB_DRIVER picks the database: mysql (the default) connects
+// with DB_USER, DB_PASS, DB_HOST, DB_PORT and DB_NAME, sqlite opens DB_DSN (:memory: if unset) and postgres connects to DB_DSN.
+func FromEnv() *sql.DB {
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "mysql":
+		port, err := strconv.Atoi(os.Getenv("DB_PORT"))
+		if err != nil {
+			log.Fatalf("Invalid DB_PORT: %v", err)
+		}
+		return InitDB(os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_HOST"), port, os.Getenv("DB_NAME"))
+	case "sqlite":
+		dsn := os.Getenv("DB_DSN")
+		if dsn == "" {
+			dsn = ":memory:"
+		}
+		return InitSQLite(dsn)
+	case "postgres":
+		return InitPostgres(os.Getenv("DB_DSN"))
+	default:
+		log.Fatalf("Invalid DB_DRIVER %q, expected mysql, sqlite or postgres", driver)
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+)
+
+// The SQL flavour of a database. The models write their queries for MySQL and check the dialect
+// for the few things the other databases spell differently.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// The dialect of an open database, told apart by its driver. Databases of any other driver are taken for MySQL.
+func DialectOf(db *sql.DB) Dialect {
+	if db == nil {
+		return MySQL
+	}
+	switch db.Driver().(type) {
+	case *sqlite.Driver:
+		return SQLite
+	case *stdlib.Driver:
+		return Postgres
+	default:
+		return MySQL
+	}
+}
+
+// Anything that can run queries, i.e. *sql.DB or *sql.Tx
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Runs an INSERT into a table with an id column and returns the id of the new row.
+// Postgres doesn't support LastInsertId, the id is returned by the INSERT itself instead.
+func InsertID(ctx context.Context, d Dialect, q Querier, query string, args ...any) (int64, error) {
+	if d == Postgres {
+		var id int64
+		err := q.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	result, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
@@ -0,0 +1,200 @@
+// Package diff computes line and word level diffs between two texts using the Myers algorithm.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	OpEqual  = "equal"
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+// Op is one chunk of a diff, Text is the joined tokens of the chunk
+type Op struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines diffs a and b line by line, every line keeps its trailing newline
+func Lines(a, b string) []Op {
+	return merge(myers(splitLines(a), splitLines(b)))
+}
+
+// Words diffs a and b word by word, whitespace is kept as separate tokens so the chunks join back into the text
+func Words(a, b string) []Op {
+	return merge(myers(splitWords(a), splitWords(b)))
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" { //The text ended with a newline
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func splitWords(s string) []string {
+	var tokens []string
+	start := 0
+	inSpace := false
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if i > start && space != inSpace {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+type edit struct {
+	op    string
+	token string
+}
+
+// Texts with more differing tokens than this, after the common prefix and suffix, are diffed as a whole replacement.
+// Finding the shortest edit takes time proportional to the tokens times the edits, which is too slow for huge rewrites.
+const maxTokens = 10000
+
+// Myers' O(ND) algorithm in linear space, returns the shortest edit script turning a into b
+func myers(a, b []string) []edit {
+	var edits []edit
+	diffRange(a, b, &edits)
+	return edits
+}
+
+// Appends the edits turning a into b. The common prefix and suffix are stripped first since edits are usually small,
+// the rest is split at the middle of its shortest edit path and both halves are diffed on their own.
+func diffRange(a, b []string, edits *[]edit) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, t := range a[:prefix] {
+		*edits = append(*edits, edit{OpEqual, t})
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	x, y := -1, -1
+	if len(middleA) > 0 && len(middleB) > 0 && len(middleA)+len(middleB) <= maxTokens {
+		x, y = middleSnake(middleA, middleB)
+	}
+	if x < 0 {
+		//Nothing in common, or too much to compare
+		for _, t := range middleA {
+			*edits = append(*edits, edit{OpDelete, t})
+		}
+		for _, t := range middleB {
+			*edits = append(*edits, edit{OpInsert, t})
+		}
+	} else {
+		diffRange(middleA[:x], middleB[:y], edits)
+		diffRange(middleA[x:], middleB[y:], edits)
+	}
+	for _, t := range a[len(a)-suffix:] {
+		*edits = append(*edits, edit{OpEqual, t})
+	}
+}
+
+// Runs the search from both ends at once until the paths meet, and returns the point where they do.
+// Only the furthest reaching x of every diagonal is kept, so memory stays linear in the input.
+// Returns -1, -1 if a and b have nothing in common.
+func middleSnake(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	size := 2*maxD + 2
+	//forward[offset+k] is the furthest x reached on diagonal k from the start, backward the same from the end
+	forward := make([]int, size)
+	backward := make([]int, size)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+	delta := n - m
+	//With an odd delta the paths meet while going forward, with an even one while going backward
+	odd := delta%2 != 0
+	//Diagonals that ran off the edge of the grid are not extended again
+	forwardStart, forwardEnd, backwardStart, backwardEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || (k != d && forward[i-1] < forward[i+1]) {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[i] = x
+			if x > n {
+				forwardEnd += 2
+			} else if y > m {
+				forwardStart += 2
+			} else if odd {
+				j := offset + delta - k
+				if j >= 0 && j < size && backward[j] != -1 && x >= n-backward[j] {
+					return x, y
+				}
+			}
+		}
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || (k != d && backward[i-1] < backward[i+1]) {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[i] = x
+			if x > n {
+				backwardEnd += 2
+			} else if y > m {
+				backwardStart += 2
+			} else if !odd {
+				j := offset + delta - k
+				if j >= 0 && j < size && forward[j] != -1 {
+					forwardX := forward[j]
+					if forwardX >= n-x {
+						return forwardX, offset + forwardX - j
+					}
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+// Joins consecutive tokens with the same op into a single chunk
+func merge(edits []edit) []Op {
+	ops := []Op{}
+	for _, e := range edits {
+		if len(ops) > 0 && ops[len(ops)-1].Op == e.op {
+			ops[len(ops)-1].Text += e.token
+			continue
+		}
+		ops = append(ops, Op{Op: e.op, Text: e.token})
+	}
+	return ops
+}
@@ -0,0 +1,133 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"both empty", "", "", []Op{}},
+		{"same", "a\nb\n", "a\nb\n", []Op{{OpEqual, "a\nb\n"}}},
+		{"from empty", "", "a\nb\n", []Op{{OpInsert, "a\nb\n"}}},
+		{"to empty", "a\nb\n", "", []Op{{OpDelete, "a\nb\n"}}},
+		{"line added", "a\nc\n", "a\nb\nc\n", []Op{{OpEqual, "a\n"}, {OpInsert, "b\n"}, {OpEqual, "c\n"}}},
+		{"line removed", "a\nb\nc\n", "a\nc\n", []Op{{OpEqual, "a\n"}, {OpDelete, "b\n"}, {OpEqual, "c\n"}}},
+		{"line changed", "a\nb\nc\n", "a\nx\nc\n", []Op{{OpEqual, "a\n"}, {OpDelete, "b\n"}, {OpInsert, "x\n"}, {OpEqual, "c\n"}}},
+		{"no trailing newline", "a\nb", "a\nb\n", []Op{{OpEqual, "a\n"}, {OpDelete, "b"}, {OpInsert, "b\n"}}},
+		{"nothing in common", "a\nb\n", "c\nd\n", []Op{{OpDelete, "a\nb\n"}, {OpInsert, "c\nd\n"}}},
+		{"moved line", "a\nb\nc\nd\n", "b\nc\nd\na\n", []Op{{OpDelete, "a\n"}, {OpEqual, "b\nc\nd\n"}, {OpInsert, "a\n"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Lines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"same", "hello world", "hello world", []Op{{OpEqual, "hello world"}}},
+		{"word changed", "the quick fox", "the slow fox", []Op{{OpEqual, "the "}, {OpDelete, "quick"}, {OpInsert, "slow"}, {OpEqual, " fox"}}},
+		{"word added", "hello world", "hello big world", []Op{{OpEqual, "hello "}, {OpInsert, "big "}, {OpEqual, "world"}}},
+		{"whitespace changed", "a b", "a  b", []Op{{OpEqual, "a"}, {OpDelete, " "}, {OpInsert, "  "}, {OpEqual, "b"}}},
+		{"leading space", " a", "a", []Op{{OpDelete, " "}, {OpEqual, "a"}}},
+		{"unicode", "café crème", "café brûlée", []Op{{OpEqual, "café "}, {OpDelete, "crème"}, {OpInsert, "brûlée"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Words(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// Rebuilds both texts from a diff
+func apply(ops []Op) (a, b string) {
+	var from, to strings.Builder
+	for _, op := range ops {
+		if op.Op != OpInsert {
+			from.WriteString(op.Text)
+		}
+		if op.Op != OpDelete {
+			to.WriteString(op.Text)
+		}
+	}
+	return from.String(), to.String()
+}
+
+// Length of the longest common subsequence, the shortest edit script has len(a)+len(b)-2*lcs edits
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// Compares the edit scripts of random texts with the shortest possible one
+func TestShortestEdit(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		tokens := make([]string, r.Intn(16))
+		for i := range tokens {
+			tokens[i] = string(rune('a' + r.Intn(4)))
+		}
+		return tokens
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := random(), random()
+		edits := myers(a, b)
+		changes := 0
+		for _, e := range edits {
+			if e.op != OpEqual {
+				changes++
+			}
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("myers(%q, %q) made %d changes, want %d", a, b, changes, want)
+		}
+		gotA, gotB := apply(merge(edits))
+		if gotA != strings.Join(a, "") || gotB != strings.Join(b, "") {
+			t.Fatalf("myers(%q, %q) rebuilds %q and %q", a, b, gotA, gotB)
+		}
+	}
+}
+
+// Huge rewrites are shown as a whole replacement instead of searching for the shortest edit
+func TestTooManyTokens(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < maxTokens; i++ {
+		a.WriteString("a\n")
+		b.WriteString("b\n")
+		if i%2 == 0 {
+			b.WriteString("a\n")
+		}
+	}
+	ops := Lines("start\n"+a.String(), "start\n"+b.String())
+	want := []Op{{OpEqual, "start\n"}, {OpDelete, a.String()}, {OpInsert, b.String()}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("got %d ops, want the whole text replaced", len(ops))
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/policy"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Admin only endpoints for granting and revoking roles and for legal takedowns, the routes are guarded by middleware.RequireRole
+type AdminHandler struct {
+	models.Stores
+}
+
+// Sets the global role of a user, revoking a role is done by setting it back to "user"
+func (m *AdminHandler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var reqBody struct { //Request body we expect to receive
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !models.IsValidRole(reqBody.Role) {
+		http.Error(w, "Role must be one of user, moderator or admin", http.StatusBadRequest)
+		return
+	}
+	actor, ok := getActorFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	Policy := policy.Policy{Stores: m.Stores}
+	if !Policy.CanManageRoles(actor) {
+		http.Error(w, "Only admins can grant and revoke roles", http.StatusForbidden)
+		return
+	}
+	//Stops the last admin from accidentally locking everyone out
+	if actor.UserID == userID && reqBody.Role != models.RoleAdmin {
+		http.Error(w, "You cannot remove your own admin role", http.StatusBadRequest)
+		return
+	}
+	found, err := m.Users.SetRole(r.Context(), userID, reqBody.Role)
+	if err != nil {
+		serverError(w, r, "Error updating role", err)
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Issues a one-time token the owner of an account without a password uses to choose one, see UserHandler.SetupPassword.
+// The admin hands the token over outside the forum, issuing a new one replaces the old one.
+func (m *AdminHandler) CreatePasswordSetupToken(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	user, err := m.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		serverError(w, r, "Error fetching user", err)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(passwordSetupTokenTTL).UTC()
+	created, err := m.Users.CreatePasswordSetupToken(r.Context(), userID, hashToken(token), expiresAt)
+	if err != nil {
+		serverError(w, r, "Error creating setup token", err)
+		return
+	}
+	if !created {
+		http.Error(w, "This account already has a password", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{token, expiresAt})
+}
+
+// Makes a user a moderator of a single topic
+func (m *AdminHandler) AddTopicModerator(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	topicID, err := strconv.ParseInt(vars["topic_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+	var reqBody struct { //Request body we expect to receive
+		UserID int64 `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	topic, err := m.Topics.GetByID(r.Context(), topicID, 0)
+	if err != nil {
+		serverError(w, r, "Error fetching topic", err)
+		return
+	}
+	if topic == nil {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	user, err := m.Users.GetByID(r.Context(), reqBody.UserID)
+	if err != nil {
+		serverError(w, r, "Error fetching user", err)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err := m.Topics.AddModerator(r.Context(), topicID, reqBody.UserID); err != nil {
+		serverError(w, r, "Error adding moderator", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Removes a user from the moderators of a topic
+func (m *AdminHandler) RemoveTopicModerator(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	topicID, err := strconv.ParseInt(vars["topic_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	removed, err := m.Topics.RemoveModerator(r.Context(), topicID, userID)
+	if err != nil {
+		serverError(w, r, "Error removing moderator", err)
+		return
+	}
+	if !removed {
+		http.Error(w, "User is not a moderator of this topic", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Permanently purges the text of a comment and of its whole edit history, for legal takedowns.
+// This can't be undone, the comment stays behind as a deleted comment.
+func (m *AdminHandler) RedactComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID, err := strconv.ParseInt(vars["comment_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+	var reqBody struct { //Request body we expect to receive, the reason is kept with the redaction
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(reqBody.Reason) == "" {
+		http.Error(w, "A reason is required to redact a comment", http.StatusBadRequest)
+		return
+	}
+	actor, ok := getActorFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	Policy := policy.Policy{Stores: m.Stores}
+	if !Policy.CanRedactComment(actor) {
+		http.Error(w, "Only admins can redact comments", http.StatusForbidden)
+		return
+	}
+	comment, err := m.Comments.GetByID(r.Context(), commentID)
+	if err != nil {
+		serverError(w, r, "Error fetching comment", err)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err := m.Comments.Redact(r.Context(), commentID, actor.UserID, reqBody.Reason); err != nil {
+		serverError(w, r, "Error redacting comment", err)
+		return
+	}
+	notifyModeration(r.Context(), m.Notifications, actor, comment.UserID, sql.NullInt64{Int64: comment.PostID, Valid: true}, sql.NullInt64{Int64: comment.ID, Valid: true},
+		"Your comment was redacted by an admin: "+reqBody.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	//bcrypt only looks at the first 72 bytes of the password, anything longer would be silently truncated
+	maxPasswordLength = 72
+
+	//Access tokens are short lived, the client uses its refresh token to get a new one when it expires
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	//How long the owner of an account without a password has to use the setup token an admin gave them
+	passwordSetupTokenTTL = 7 * 24 * time.Hour
+
+	refreshTokenCookie = "refresh_token"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// Hash compared against when the username does not exist, so that a failed login takes the same time
+// whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return errors.New("Password must be between 8 and 72 characters")
+	}
+	return nil
+}
+
+// Returns a salted bcrypt hash of the password, the salt is stored as part of the hash string.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Compares the password against the stored hash, bcrypt does the comparison in constant time.
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Generates a random session ID, this is used as the jti claim of the JWT
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Generates an opaque refresh or password setup token, only its hash is ever stored in the database
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Refresh and password setup tokens are long random strings, so a fast hash is enough (unlike passwords)
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// The refresh token cookie is only sent to the user endpoints that need it (refresh and logout)
+func setRefreshTokenCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    token,
+		Expires:  expires,
+		Path:     "/api/users",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+// Tells the browser to delete both auth cookies
+func clearAuthCookies(w http.ResponseWriter) {
+	//MaxAge and Epires both "tell" the browser to delete the cookie but just added both for completeness.
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    "",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		Path:     "/api/users",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
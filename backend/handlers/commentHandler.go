@@ -2,17 +2,22 @@ package handlers
 
 import (
 	"backend/models"
+	"backend/policy"
+	"backend/realtime"
+	"backend/webhooks"
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type CommentHandler struct {
-	DB *sql.DB
+	models.Stores
+	Hub        *realtime.Hub        // Live streams are told about changes, can be nil
+	Dispatcher *webhooks.Dispatcher // Webhooks are queued for new content, can be nil
 }
 
 func (m *CommentHandler) GetAllPostComments(w http.ResponseWriter, r *http.Request) {
@@ -26,47 +31,45 @@ func (m *CommentHandler) GetAllPostComments(w http.ResponseWriter, r *http.Reque
 	if !ok {
 		currentUserID = 0
 	}
-	CommentDB := models.CommentDB{DB: m.DB}
 	//Convert string to integer
 	postIDInt, err := strconv.ParseInt(postID, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid post_id parameter", http.StatusBadRequest)
 		return
 	}
+	//The nested format is opt in, the flat list stays the default for existing clients
+	if r.URL.Query().Get("format") == "tree" {
+		m.writeCommentTree(w, r, postIDInt, sql.NullInt64{}, currentUserID)
+		return
+	}
 	//Get all comments under a post, this also returns a liked_by column that is boolean using the currentUserID.
 	//Note: No user will have the user ID 0, so if it is 0 all comments returned should be false in the liked_by column.
 	//Thus the reason for setting the userID to 0 is to handle unregistered users who are simply browsing the website.
 	//Versus throwing and error preventing ALL unregistered users from using the application.
-	comments, err := CommentDB.AllByPostID(postIDInt, currentUserID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching comments: %v", err), http.StatusInternalServerError)
-		return
-	}
-	var comments_cleaned []models.Comment
-	//Loop through all the returned comments, if the deleted column is True, set the content and Username to "deleted" and "redacted"
-	//This ensures privacy and provides BACKEND censoring versus just censoring it in the frontend where
-	// Malicious users might still be able to  Look at deleted content.
-	for _, c := range comments {
-		if c.Deleted {
-			comments_cleaned = append(comments_cleaned,
-				models.Comment{ID: c.ID, Content: "[Deleted]", Likes: 0, CreatedAt: c.CreatedAt,
-					UpdatedAt: c.UpdatedAt, PostID: c.PostID,
-					UserID: c.UserID, CreatedByUsername: "[Redacted]",
-					ParentCommentID: c.ParentCommentID, LikedByUser: c.LikedByUser, Deleted: c.Deleted})
-			continue
-		}
-		comments_cleaned = append(comments_cleaned, c)
+	page, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	comments, err := m.Comments.AllByPostID(r.Context(), postIDInt, currentUserID, page)
+	if err != nil {
+		serverError(w, r, "Error fetching comments", err)
+		return
+	}
+	for i, c := range comments.Items {
+		comments.Items[i] = redactDeleted(c)
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments_cleaned)
+	json.NewEncoder(w).Encode(comments)
 }
 func (m *CommentHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct { //The response body we expect to receive
-		PostID    int64  `json:"post_id"`
-		UserID    int64  `json:"user_id"`
-		Content   string `json:"content"`
-		CreatedBy int64  `json:"created_by"`
-		ParentID  *int64 `json:"parent_id,omitempty"`
+		PostID   int64  `json:"post_id"`
+		Content  string `json:"content"`
+		ParentID *int64 `json:"parent_id,omitempty"`
+		//Only used to reject clients that try to pick the author, the author is always the logged in user
+		UserID    *int64 `json:"user_id"`
+		CreatedBy *int64 `json:"created_by"`
 	}
 	//Decode the JSON body using the structure defined above, if a parameter is missing or doesn't match the type specified,
 	//an error will be thrown.
@@ -74,19 +77,56 @@ func (m *CommentHandler) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	CommentDB := models.CommentDB{DB: m.DB}
+	if reqBody.UserID != nil || reqBody.CreatedBy != nil {
+		http.Error(w, "user_id cannot be set, the author is taken from your login", http.StatusUnprocessableEntity)
+		return
+	}
+	if reqBody.Content == "" {
+		http.Error(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	//Make sure the post exists before inserting
+	post, err := m.Posts.GetByID(r.Context(), reqBody.PostID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching post", err)
+		return
+	}
+	if post == nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
 	//Check if the response body contains a parent ID, i.e. the user created a sub-reply.
 	var parentCommentID sql.NullInt64
 	if reqBody.ParentID != nil {
+		//The parent has to exist and be under the same post, otherwise the reply would end up in another thread
+		parent, err := m.Comments.GetByID(r.Context(), *reqBody.ParentID)
+		if err != nil {
+			serverError(w, r, "Error fetching parent comment", err)
+			return
+		}
+		if parent == nil {
+			http.Error(w, "Parent comment not found", http.StatusNotFound)
+			return
+		}
+		if parent.PostID != reqBody.PostID {
+			http.Error(w, "Parent comment belongs to a different post", http.StatusUnprocessableEntity)
+			return
+		}
 		parentCommentID = sql.NullInt64{Int64: *reqBody.ParentID, Valid: true}
 	} else {
 		parentCommentID = sql.NullInt64{Valid: false}
 	}
-	commentID, err := CommentDB.Create(reqBody.PostID, reqBody.UserID, reqBody.Content, parentCommentID)
+	commentID, err := m.Comments.Create(r.Context(), reqBody.PostID, currentUserID, reqBody.Content, parentCommentID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating comment: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error creating comment", err)
 		return
 	}
+	m.publishComment(r.Context(), realtime.EventCommentCreated, commentID)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	//Return the created commentID
@@ -101,7 +141,7 @@ func (m *CommentHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//Get the current user id from the context, if unable throw an authentication error.
-	currentUserID, ok := getUserIDFromContext(r.Context())
+	actor, ok := getActorFromContext(r.Context())
 	if !ok {
 		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
 		return
@@ -112,23 +152,38 @@ func (m *CommentHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid comment_id parameter", http.StatusBadRequest)
 		return
 	}
-	CommentDB := models.CommentDB{DB: m.DB}
 	//Fetch the commentID requested to be deleted, this will be used to compare the comment.userID to the current userID in the JWT.
-	comment, err := CommentDB.GetByID(commentIDInt)
+	comment, err := m.Comments.GetByID(r.Context(), commentIDInt)
+	if err != nil {
+		serverError(w, r, "Error fetching comment in delete", err)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	//Check if the user requesting to delete the comment is authorized to do so (the author or a moderator), if not throw a forbidden error.
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanDeleteComment(r.Context(), actor, comment)
 	if err != nil {
-		http.Error(w, "Error fetching comment in delete", http.StatusInternalServerError)
+		serverError(w, r, "Error checking permissions", err)
 		return
 	}
-	//Check if the user requesting to delete the comment is authorized to do so, if not throw a forbidden error.
-	if comment.UserID != currentUserID {
+	if !allowed {
 		http.Error(w, "Not allowed to delete comments other than your own!", http.StatusForbidden)
 		return
 	}
 
-	if err := CommentDB.Delete(commentIDInt); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting comment: %v", err), http.StatusInternalServerError)
+	if err := m.Comments.Delete(r.Context(), commentIDInt, actor.UserID); err == models.ErrCommentDeleted {
+		http.Error(w, "Comment has already been deleted", http.StatusConflict)
+		return
+	} else if err != nil {
+		serverError(w, r, "Error deleting comment", err)
 		return
 	}
+	notifyModeration(r.Context(), m.Notifications, actor, comment.UserID, sql.NullInt64{Int64: comment.PostID, Valid: true}, sql.NullInt64{Int64: comment.ID, Valid: true},
+		"Your comment was removed by a moderator")
+	m.publishComment(r.Context(), realtime.EventCommentDeleted, commentIDInt)
 	w.WriteHeader(http.StatusNoContent)
 }
 func (m *CommentHandler) Update(w http.ResponseWriter, r *http.Request) {
@@ -152,27 +207,41 @@ func (m *CommentHandler) Update(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	CommentDB := models.CommentDB{DB: m.DB}
 	//Get the current user id from the context. IF unable to do so or is empty, throw an authentication error.
-	currentUserID, ok := getUserIDFromContext(r.Context())
+	actor, ok := getActorFromContext(r.Context())
 	if !ok {
 		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
 		return
 	}
-	c, err := CommentDB.GetByID(commentIDInt) //Get the comment ID so that we can check if the creator of the comment is the requester.
+	c, err := m.Comments.GetByID(r.Context(), commentIDInt) //Get the comment ID so that we can check if the creator of the comment is the requester.
 
 	if err != nil {
-		http.Error(w, "Error validating comment ownership", http.StatusInternalServerError)
+		serverError(w, r, "Error validating comment ownership", err)
 		return
 	}
-	if c.UserID != currentUserID { // IF user is not authorized throw an error.
-		http.Error(w, "You can only edit your own comments.", http.StatusUnauthorized)
+	if c == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanUpdateComment(r.Context(), actor, c)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return
+	}
+	if !allowed { // IF user is not authorized throw an error.
+		http.Error(w, "You can only edit your own comments.", http.StatusForbidden)
+		return
 	}
 
-	if err := CommentDB.Update(commentIDInt, reqBody.Content); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating comment: %v", err), http.StatusInternalServerError)
+	if err := m.Comments.Update(r.Context(), commentIDInt, actor.UserID, reqBody.Content); err == models.ErrCommentDeleted {
+		http.Error(w, "Deleted comments can't be edited", http.StatusConflict)
+		return
+	} else if err != nil {
+		serverError(w, r, "Error updating comment", err)
 		return
 	}
+	m.publishComment(r.Context(), realtime.EventCommentUpdated, commentIDInt)
 	w.WriteHeader(http.StatusNoContent)
 }
 func (m *CommentHandler) GetCommentByID(w http.ResponseWriter, r *http.Request) {
@@ -188,18 +257,108 @@ func (m *CommentHandler) GetCommentByID(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid comment_id parameter", http.StatusBadRequest)
 		return
 	}
-	CommentDB := models.CommentDB{DB: m.DB}
-	comment, err := CommentDB.GetByID(commentIDInt)
+	comment, err := m.Comments.GetByID(r.Context(), commentIDInt)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching comment: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching comment", err)
 		return
 	}
 	if comment == nil {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	}
+	//Permalinks can ask for the thread around the comment with ?context=N
+	if contextStr := r.URL.Query().Get("context"); contextStr != "" {
+		levels, err := strconv.Atoi(contextStr)
+		if err != nil || levels < 0 {
+			http.Error(w, "Invalid context parameter", http.StatusBadRequest)
+			return
+		}
+		if levels > maxTreeDepth {
+			levels = maxTreeDepth
+		}
+		m.writeCommentContext(w, r, comment, levels)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comment)
+	json.NewEncoder(w).Encode(redactDeleted(*comment))
+}
+
+// Short version of a post, enough for a permalink page to link back to the full post
+type PostSummary struct {
+	ID                int64     `json:"id"`
+	Title             string    `json:"title"`
+	TopicID           int64     `json:"topic_id"`
+	TopicTitle        string    `json:"topic_title"`
+	CreatedByUsername string    `json:"username"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Response for a comment permalink with ?context=N
+type CommentContextResponse struct {
+	Post PostSummary `json:"post"`
+	//From the furthest ancestor down to the direct parent of the comment
+	Ancestors []models.Comment `json:"ancestors"`
+	//True if the comment has more ancestors than were requested
+	HasMoreAncestors bool                  `json:"has_more_ancestors"`
+	Comment          models.Comment        `json:"comment"`
+	Replies          []*models.CommentNode `json:"replies"`
+	//Cursor for the next page of direct replies, see GET /api/comments/{id}/replies
+	RepliesNextCursor string `json:"replies_next_cursor,omitempty"`
+}
+
+// Writes the comment together with its post, up to levels ancestors and its direct replies.
+// Deleted comments are redacted the same way as in GetAllPostComments.
+func (m *CommentHandler) writeCommentContext(w http.ResponseWriter, r *http.Request, comment *models.Comment, levels int) {
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		currentUserID = 0
+	}
+	limit, err := parseIntQuery(r, "limit", defaultTreeLimit, maxTreeLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	post, err := m.Posts.GetByID(r.Context(), comment.PostID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching post", err)
+		return
+	}
+	if post == nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	ancestors, err := m.Comments.Ancestors(r.Context(), comment.ID, currentUserID, levels)
+	if err != nil {
+		serverError(w, r, "Error fetching comments", err)
+		return
+	}
+	replies, next, err := m.Comments.Tree(r.Context(), comment.PostID, sql.NullInt64{Int64: comment.ID, Valid: true}, currentUserID, 1, limit, nil)
+	if err != nil {
+		serverError(w, r, "Error fetching comments", err)
+		return
+	}
+	response := CommentContextResponse{
+		Post: PostSummary{ID: post.ID, Title: post.Title, TopicID: post.TopicID, TopicTitle: post.TopicTitle,
+			CreatedByUsername: post.CreatedByUsername, CreatedAt: post.CreatedAt},
+		Ancestors: []models.Comment{},
+		Comment:   redactDeleted(*comment),
+		Replies:   replies,
+	}
+	//The chain goes on if the furthest comment we loaded still has a parent
+	if len(ancestors) > 0 {
+		response.HasMoreAncestors = ancestors[0].ParentCommentID.Valid
+	} else {
+		response.HasMoreAncestors = comment.ParentCommentID.Valid
+	}
+	for _, a := range ancestors {
+		response.Ancestors = append(response.Ancestors, redactDeleted(a))
+	}
+	redactTree(response.Replies)
+	if next != nil {
+		response.RepliesNextCursor = next.Encode()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 func (m *CommentHandler) LikeComment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -219,10 +378,273 @@ func (m *CommentHandler) LikeComment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid comment_id parameter", http.StatusBadRequest)
 		return
 	}
-	CommentDB := models.CommentDB{DB: m.DB}
-	if err := CommentDB.LikeComment(commentIDInt, currentUserID); err != nil {
-		http.Error(w, fmt.Sprintf("Error liking comment: %v", err), http.StatusInternalServerError)
+	if err := m.Comments.LikeComment(r.Context(), commentIDInt, currentUserID); err != nil {
+		serverError(w, r, "Error liking comment", err)
 		return
 	}
+	m.publishComment(r.Context(), realtime.EventCommentLiked, commentIDInt)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Likes (PUT) or unlikes (DELETE) a comment. Unlike the POST toggle both are safe to retry,
+// the response has the new like count and whether the user likes the comment.
+func (m *CommentHandler) SetLike(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID, err := strconv.ParseInt(vars["comment_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	comment, err := m.Comments.GetByID(r.Context(), commentID)
+	if err != nil {
+		serverError(w, r, "Error fetching comment", err)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	changed, err := m.Comments.SetLike(r.Context(), commentID, currentUserID, r.Method == http.MethodPut)
+	if err != nil {
+		serverError(w, r, "Error liking comment", err)
+		return
+	}
+	if changed {
+		m.publishComment(r.Context(), realtime.EventCommentLiked, commentID)
+		m.publishReactions(r.Context(), comment)
+	}
+	state, err := m.Comments.LikeState(r.Context(), commentID, currentUserID)
+	if err != nil || state == nil {
+		serverError(w, r, "Error fetching likes", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// Adds (PUT) or removes (DELETE) one of the current user's reactions to a comment, both can be repeated safely.
+// Responds with the comment's reactions as the user sees them.
+func (m *CommentHandler) React(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID, err := strconv.ParseInt(vars["comment_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+	kind := vars["kind"]
+	if !parseReactionKind(w, kind) {
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	comment, err := m.Comments.GetByID(r.Context(), commentID)
+	if err != nil {
+		serverError(w, r, "Error fetching comment", err)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	changed, err := m.Comments.SetReaction(r.Context(), commentID, currentUserID, kind, r.Method == http.MethodPut)
+	if err != nil {
+		serverError(w, r, "Error updating reaction", err)
+		return
+	}
+	if changed {
+		if kind == models.ReactionLike {
+			m.publishComment(r.Context(), realtime.EventCommentLiked, commentID)
+		}
+		m.publishReactions(r.Context(), comment)
+	}
+	reactions, err := m.Comments.Reactions(r.Context(), commentID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching reactions", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reactions)
+}
+
+// Bookmarks (POST) or removes the bookmark of (DELETE) a comment for the current user
+func (m *CommentHandler) Bookmark(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID, err := strconv.ParseInt(vars["comment_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	comment, err := m.Comments.GetByID(r.Context(), commentID)
+	if err != nil {
+		serverError(w, r, "Error fetching comment", err)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		err = m.Bookmarks.RemoveComment(r.Context(), commentID, currentUserID)
+	} else {
+		err = m.Bookmarks.AddComment(r.Context(), commentID, currentUserID)
+	}
+	if err != nil {
+		serverError(w, r, "Error updating bookmark", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Loop through all the returned comments, if the deleted column is True, set the content and Username to "deleted" and "redacted"
+// This ensures privacy and provides BACKEND censoring versus just censoring it in the frontend where
+// Malicious users might still be able to  Look at deleted content.
+func redactDeleted(c models.Comment) models.Comment {
+	if !c.Deleted {
+		return c
+	}
+	return models.Comment{ID: c.ID, Content: "[Deleted]", ContentHTML: "<p>[Deleted]</p>", Likes: 0, CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt, PostID: c.PostID,
+		UserID: c.UserID, CreatedByUsername: "[Redacted]",
+		ParentCommentID: c.ParentCommentID, LikedByUser: c.LikedByUser, BookmarkedByUser: c.BookmarkedByUser, Deleted: c.Deleted,
+		Reactions: models.Reactions{Mine: c.Reactions.Mine}}
+}
+
+// Same as redactDeleted, but for every comment of a tree
+func redactTree(nodes []*models.CommentNode) {
+	for _, n := range nodes {
+		n.Comment = redactDeleted(n.Comment)
+		redactTree(n.Replies)
+	}
+}
+
+const (
+	defaultTreeDepth = 3
+	maxTreeDepth     = 10
+	defaultTreeLimit = 20
+	maxTreeLimit     = 100
+)
+
+// Writes a comment tree starting at the top level comments of a post, or at the replies of parentID if it is set.
+// The depth, page size and cursor are read from the max_depth, limit and cursor query parameters.
+func (m *CommentHandler) writeCommentTree(w http.ResponseWriter, r *http.Request, postID int64, parentID sql.NullInt64, currentUserID int64) {
+	maxDepth, err := parseIntQuery(r, "max_depth", defaultTreeDepth, maxTreeDepth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseIntQuery(r, "limit", defaultTreeLimit, maxTreeLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, err := models.DecodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, "Invalid cursor parameter", http.StatusBadRequest)
+		return
+	}
+	nodes, next, err := m.Comments.Tree(r.Context(), postID, parentID, currentUserID, maxDepth, limit, after)
+	if err != nil {
+		serverError(w, r, "Error fetching comments", err)
+		return
+	}
+	redactTree(nodes)
+	response := models.Page[*models.CommentNode]{Items: nodes}
+	if next != nil {
+		response.NextCursor = next.Encode()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Returns the replies of a comment as a tree, used to lazily load the branches that were cut off in the post's comment tree
+func (m *CommentHandler) GetReplies(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID, err := strconv.ParseInt(vars["comment_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment_id parameter", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		currentUserID = 0
+	}
+	comment, err := m.Comments.GetByID(r.Context(), commentID)
+	if err != nil {
+		serverError(w, r, "Error fetching comment", err)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	m.writeCommentTree(w, r, comment.PostID, sql.NullInt64{Int64: commentID, Valid: true}, currentUserID)
+}
+
+// Edit history of a comment, including the text of the comment itself even if it was deleted
+type CommentHistoryResponse struct {
+	CommentID int64                    `json:"comment_id"`
+	Content   string                   `json:"content"`
+	Deleted   bool                     `json:"deleted"`
+	Redacted  bool                     `json:"redacted"`
+	Revisions []models.CommentRevision `json:"revisions"`
+}
+
+// Returns the edit history of a comment, only the author and moderators of the topic can see it
+func (m *CommentHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	commentID, err := strconv.ParseInt(vars["comment_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment_id parameter", http.StatusBadRequest)
+		return
+	}
+	actor, ok := getActorFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	comment, err := m.Comments.GetByID(r.Context(), commentID)
+	if err != nil {
+		serverError(w, r, "Error fetching comment", err)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanViewCommentHistory(r.Context(), actor, comment)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Only the author and moderators can see the history of a comment", http.StatusForbidden)
+		return
+	}
+	revisions, err := m.Comments.Revisions(r.Context(), commentID)
+	if err != nil {
+		serverError(w, r, "Error fetching revisions", err)
+		return
+	}
+	response := CommentHistoryResponse{CommentID: comment.ID, Content: comment.Content, Deleted: comment.Deleted, Revisions: revisions}
+	for _, rev := range revisions {
+		if rev.Action == models.CommentRedacted {
+			response.Redacted = true
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
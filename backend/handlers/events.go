@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/realtime"
+	"backend/webhooks"
+	"context"
+	"log"
+)
+
+// Payload of the like events, the new like count of the post or comment
+type LikeEvent struct {
+	PostID    int64 `json:"post_id"`
+	CommentID int64 `json:"comment_id,omitempty"`
+	Likes     int   `json:"likes"`
+}
+
+// Payload of the delete events
+type DeleteEvent struct {
+	ID      int64 `json:"id"`
+	PostID  int64 `json:"post_id,omitempty"`
+	TopicID int64 `json:"topic_id,omitempty"`
+}
+
+// Payload of the post.liked webhook, unlike the stream event it says who liked the post
+type PostLikedWebhook struct {
+	PostID  int64 `json:"post_id"`
+	TopicID int64 `json:"topic_id"`
+	UserID  int64 `json:"user_id"`
+	Likes   int   `json:"likes"`
+}
+
+// Queues a webhook event, like the stream events this happens after the change succeeded so errors are only logged.
+// commentID is the comment data carries, 0 if none.
+func enqueueWebhook(ctx context.Context, d *webhooks.Dispatcher, event string, topicID, commentID int64, data any) {
+	if err := d.Enqueue(context.WithoutCancel(ctx), event, topicID, commentID, data); err != nil {
+		log.Printf("Error queueing %s webhooks: %v", event, err)
+	}
+}
+
+// Publishes the current state of a post to the streams following it or its topic and to the webhooks.
+// Events are sent after the change succeeded, so failing to load the post is only logged,
+// and they are still sent when the client that made the change has already gone away.
+// actorID is the user who made the change.
+func (m *PostHandler) publishPost(ctx context.Context, eventType string, postID, actorID int64) {
+	if m.Hub == nil && m.Dispatcher == nil {
+		return
+	}
+	post, err := m.Posts.GetByID(context.WithoutCancel(ctx), postID, actorID)
+	if err != nil || post == nil {
+		log.Printf("Error loading post %d for %s event: %v", postID, eventType, err)
+		return
+	}
+	channels := []string{realtime.PostChannel(post.ID), realtime.TopicChannel(post.TopicID)}
+	if eventType == realtime.EventPostLiked {
+		m.Hub.Publish(eventType, LikeEvent{PostID: post.ID, Likes: post.Likes}, channels...)
+		//Liking toggles, only an actual like is sent to the webhooks
+		if post.LikedByUser {
+			enqueueWebhook(ctx, m.Dispatcher, webhooks.EventPostLiked, post.TopicID, 0, PostLikedWebhook{PostID: post.ID, TopicID: post.TopicID, UserID: actorID, Likes: post.Likes})
+		}
+		return
+	}
+	//The stream is shared by everyone, so it doesn't carry the actor's own like or bookmark
+	post.LikedByUser = false
+	post.BookmarkedByUser = false
+	m.Hub.Publish(eventType, post, channels...)
+	if eventType == realtime.EventPostCreated {
+		enqueueWebhook(ctx, m.Dispatcher, webhooks.EventPostCreated, post.TopicID, 0, post)
+	}
+}
+
+// Publishes the current state of a comment to the streams following its post and to the webhooks, deleted comments are redacted
+func (m *CommentHandler) publishComment(ctx context.Context, eventType string, commentID int64) {
+	if m.Hub == nil && m.Dispatcher == nil {
+		return
+	}
+	comment, err := m.Comments.GetByID(context.WithoutCancel(ctx), commentID)
+	if err != nil || comment == nil {
+		log.Printf("Error loading comment %d for %s event: %v", commentID, eventType, err)
+		return
+	}
+	switch eventType {
+	case realtime.EventCommentLiked:
+		m.Hub.Publish(eventType, LikeEvent{PostID: comment.PostID, CommentID: comment.ID, Likes: comment.Likes}, realtime.PostChannel(comment.PostID))
+	case realtime.EventCommentDeleted:
+		m.Hub.Publish(eventType, DeleteEvent{ID: comment.ID, PostID: comment.PostID}, realtime.PostChannel(comment.PostID))
+	default:
+		m.Hub.Publish(eventType, redactDeleted(*comment), realtime.PostChannel(comment.PostID))
+	}
+	if eventType == realtime.EventCommentCreated && m.Dispatcher != nil {
+		//Webhooks are picked by topic, which the comment only knows through its post
+		post, err := m.Posts.GetByID(context.WithoutCancel(ctx), comment.PostID, 0)
+		if err != nil || post == nil {
+			log.Printf("Error loading post %d for %s webhooks: %v", comment.PostID, webhooks.EventCommentCreated, err)
+			return
+		}
+		enqueueWebhook(ctx, m.Dispatcher, webhooks.EventCommentCreated, post.TopicID, comment.ID, redactDeleted(*comment))
+	}
+}
+
+// Payload of the reaction events, the new counts of every kind
+type ReactionEvent struct {
+	PostID    int64          `json:"post_id"`
+	CommentID int64          `json:"comment_id,omitempty"`
+	Counts    map[string]int `json:"counts"`
+}
+
+// Publishes the reaction counts of a post after one of them changed
+func (m *PostHandler) publishReactions(ctx context.Context, post *models.Post) {
+	if m.Hub == nil {
+		return
+	}
+	reactions, err := m.Posts.Reactions(context.WithoutCancel(ctx), post.ID, 0)
+	if err != nil {
+		log.Printf("Error loading reactions of post %d: %v", post.ID, err)
+		return
+	}
+	m.Hub.Publish(realtime.EventPostReacted, ReactionEvent{PostID: post.ID, Counts: reactions.Counts},
+		realtime.PostChannel(post.ID), realtime.TopicChannel(post.TopicID))
+}
+
+// Publishes the reaction counts of a comment after one of them changed
+func (m *CommentHandler) publishReactions(ctx context.Context, comment *models.Comment) {
+	if m.Hub == nil {
+		return
+	}
+	reactions, err := m.Comments.Reactions(context.WithoutCancel(ctx), comment.ID, 0)
+	if err != nil {
+		log.Printf("Error loading reactions of comment %d: %v", comment.ID, err)
+		return
+	}
+	m.Hub.Publish(realtime.EventCommentReacted, ReactionEvent{PostID: comment.PostID, CommentID: comment.ID, Counts: reactions.Counts},
+		realtime.PostChannel(comment.PostID))
+}
@@ -0,0 +1,287 @@
+package handlers_test
+
+import (
+	"backend/handlers"
+	"backend/middleware"
+	"backend/models"
+	"backend/realtime"
+	"backend/routers"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+)
+
+// The whole API on the memory stores, served over TLS because the auth cookies are Secure
+func newServer(t *testing.T) (*httptest.Server, models.Stores) {
+	t.Helper()
+	hub := realtime.NewHub()
+	stores := models.NewMemoryStores(routers.NotificationListener(hub))
+	timeouts, err := middleware.ParseQueryTimeouts("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(routers.SetupRouter(stores, hub, []byte("test key"), nil, timeouts, nil, nil))
+	t.Cleanup(server.Close)
+	return server, stores
+}
+
+// A browser with its own cookies
+type client struct {
+	t      *testing.T
+	server *httptest.Server
+	http   *http.Client
+}
+
+func newClient(t *testing.T, server *httptest.Server) *client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//server.Client() is shared, copy it so every client has its own jar
+	c := *server.Client()
+	c.Jar = jar
+	return &client{t: t, server: server, http: &c}
+}
+
+// Sends body as JSON and decodes the response into out if it is not nil, returns the status code
+func (c *client) do(method, path string, body, out any) int {
+	c.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.server.URL+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(b, out); err != nil {
+			c.t.Fatalf("%s %s: decoding %q: %v", method, path, b, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// Like do but fails the test unless the response has the wanted status
+func (c *client) expect(want int, method, path string, body, out any) {
+	c.t.Helper()
+	if got := c.do(method, path, body, out); got != want {
+		c.t.Fatalf("%s %s: got status %d, want %d", method, path, got, want)
+	}
+}
+
+// Registers a user and logs them in, returns their client and ID
+func register(t *testing.T, server *httptest.Server, username string) (*client, int64) {
+	t.Helper()
+	c := newClient(t, server)
+	var created struct{ ID int64 }
+	credentials := map[string]string{"username": username, "password": "password123"}
+	c.expect(http.StatusCreated, "POST", "/api/users/register", credentials, &created)
+	c.expect(http.StatusOK, "POST", "/api/users/login", credentials, nil)
+	return c, created.ID
+}
+
+// Creates a topic and a post in it, returns their IDs
+func newPost(c *client) (topicID, postID int64) {
+	c.t.Helper()
+	var created struct{ ID int64 }
+	c.expect(http.StatusOK, "POST", "/api/topics", map[string]string{"title": "A topic", "description": "About things"}, &created)
+	topicID = created.ID
+	c.expect(http.StatusCreated, "POST", "/api/posts", map[string]any{"topic_id": topicID, "title": "A post", "content": "Hello"}, &created)
+	return topicID, created.ID
+}
+
+func TestSessions(t *testing.T) {
+	server, _ := newServer(t)
+	c, userID := register(t, server, "alicesmith")
+
+	var me handlers.MeResponse
+	c.expect(http.StatusOK, "GET", "/api/users/me", nil, &me)
+	if me.ID != userID || me.Username != "alicesmith" {
+		t.Fatalf("me = %+v", me)
+	}
+	var sessions []models.Session
+	c.expect(http.StatusOK, "GET", "/api/users/me/sessions", nil, &sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("sessions = %+v, want the current one", sessions)
+	}
+
+	//A second login is a second session, revoking it leaves the first one working
+	other := newClient(t, server)
+	other.expect(http.StatusOK, "POST", "/api/users/login", map[string]string{"username": "alicesmith", "password": "password123"}, nil)
+	c.expect(http.StatusOK, "GET", "/api/users/me/sessions", nil, &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	for _, s := range sessions {
+		if !s.Current {
+			c.expect(http.StatusNoContent, "DELETE", "/api/users/me/sessions/"+s.ID, nil, nil)
+		}
+	}
+	other.expect(http.StatusUnauthorized, "GET", "/api/users/me", nil, nil)
+	other.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", nil, nil)
+
+	c.expect(http.StatusNoContent, "POST", "/api/users/refresh", nil, nil)
+	c.expect(http.StatusOK, "GET", "/api/users/me", nil, nil)
+	c.expect(http.StatusNoContent, "POST", "/api/users/logout", nil, nil)
+	c.expect(http.StatusUnauthorized, "GET", "/api/users/me", nil, nil)
+}
+
+func TestBookmarks(t *testing.T) {
+	server, _ := newServer(t)
+	c, _ := register(t, server, "alicesmith")
+	_, postID := newPost(c)
+	var created struct{ ID int64 }
+	c.expect(http.StatusCreated, "POST", "/api/comments", map[string]any{"post_id": postID, "content": "A comment"}, &created)
+	commentID := created.ID
+
+	c.expect(http.StatusNoContent, "POST", fmt.Sprintf("/api/posts/%d/bookmark", postID), nil, nil)
+	c.expect(http.StatusNoContent, "POST", fmt.Sprintf("/api/comments/%d/bookmark", commentID), nil, nil)
+	c.expect(http.StatusNotFound, "POST", "/api/posts/999/bookmark", nil, nil)
+	var post models.Post
+	c.expect(http.StatusOK, "GET", fmt.Sprintf("/api/posts/%d", postID), nil, &post)
+	if !post.BookmarkedByUser {
+		t.Fatal("bookmarked post is not marked as bookmarked")
+	}
+
+	var bookmarks models.Page[models.Bookmark]
+	c.expect(http.StatusOK, "GET", "/api/users/me/bookmarks", nil, &bookmarks)
+	if len(bookmarks.Items) != 2 || bookmarks.Items[0].Type != models.BookmarkComment || bookmarks.Items[1].Type != models.BookmarkPost {
+		t.Fatalf("bookmarks = %+v", bookmarks.Items)
+	}
+	c.expect(http.StatusOK, "GET", "/api/users/me/bookmarks?type=post", nil, &bookmarks)
+	if len(bookmarks.Items) != 1 || bookmarks.Items[0].Post.ID != postID {
+		t.Fatalf("post bookmarks = %+v", bookmarks.Items)
+	}
+
+	//Deleted comments stay in the list but are redacted
+	c.expect(http.StatusNoContent, "DELETE", fmt.Sprintf("/api/comments/%d", commentID), nil, nil)
+	c.expect(http.StatusNoContent, "DELETE", fmt.Sprintf("/api/posts/%d/bookmark", postID), nil, nil)
+	c.expect(http.StatusOK, "GET", "/api/users/me/bookmarks", nil, &bookmarks)
+	if len(bookmarks.Items) != 1 || bookmarks.Items[0].Comment == nil || bookmarks.Items[0].Comment.Content == "A comment" {
+		t.Fatalf("bookmarks after deleting = %+v", bookmarks.Items)
+	}
+
+	other, _ := register(t, server, "bobjones")
+	other.expect(http.StatusOK, "GET", "/api/users/me/bookmarks", nil, &bookmarks)
+	if len(bookmarks.Items) != 0 {
+		t.Fatalf("another user sees %+v", bookmarks.Items)
+	}
+}
+
+// Registers an admin and logs them in, returns their client and ID
+func registerAdmin(t *testing.T, server *httptest.Server, stores models.Stores, username string) (*client, int64) {
+	t.Helper()
+	c := newClient(t, server)
+	var created struct{ ID int64 }
+	credentials := map[string]string{"username": username, "password": "password123"}
+	c.expect(http.StatusCreated, "POST", "/api/users/register", credentials, &created)
+	//The role is read when logging in, so the user is made an admin before
+	if ok, err := stores.Users.SetRole(t.Context(), created.ID, models.RoleAdmin); err != nil || !ok {
+		t.Fatalf("SetRole = %v, %v", ok, err)
+	}
+	c.expect(http.StatusOK, "POST", "/api/users/login", credentials, nil)
+	return c, created.ID
+}
+
+func TestModerationNotifications(t *testing.T) {
+	server, stores := newServer(t)
+	author, _ := register(t, server, "alicesmith")
+	_, postID := newPost(author)
+	admin, _ := registerAdmin(t, server, stores, "adminuser")
+
+	admin.expect(http.StatusNoContent, "DELETE", fmt.Sprintf("/api/posts/%d", postID), nil, nil)
+	var me handlers.MeResponse
+	author.expect(http.StatusOK, "GET", "/api/users/me", nil, &me)
+	if me.UnreadNotifications != 1 {
+		t.Fatalf("unread notifications = %d, want 1", me.UnreadNotifications)
+	}
+	var page struct{ Items []map[string]any }
+	author.expect(http.StatusOK, "GET", "/api/notifications", nil, &page)
+	if len(page.Items) != 1 || page.Items[0]["kind"] != "moderation" || page.Items[0]["actor_username"] != "adminuser" {
+		t.Fatalf("notifications = %+v", page.Items)
+	}
+	//Admins get nothing for their own actions
+	admin.expect(http.StatusOK, "GET", "/api/notifications", nil, &page)
+	if len(page.Items) != 0 {
+		t.Fatalf("admin notifications = %+v", page.Items)
+	}
+
+	var read struct{ Marked, Unread int64 }
+	author.expect(http.StatusBadRequest, "POST", "/api/notifications/read", map[string]any{}, nil)
+	author.expect(http.StatusOK, "POST", "/api/notifications/read", map[string]any{"all": true}, &read)
+	if read.Marked != 1 || read.Unread != 0 {
+		t.Fatalf("marking all as read = %+v", read)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	server, _ := newServer(t)
+	owner, _ := register(t, server, "alicesmith")
+	topicID, _ := newPost(owner)
+	stranger, _ := register(t, server, "bobjones")
+
+	body := map[string]any{"url": "https://example.com/hook", "events": []string{"post.created"}, "topic_id": topicID}
+	stranger.expect(http.StatusForbidden, "POST", "/api/webhooks", body, nil)
+	owner.expect(http.StatusBadRequest, "POST", "/api/webhooks", map[string]any{"url": "ftp://example.com", "events": []string{"post.created"}, "topic_id": topicID}, nil)
+	//Forum wide webhooks are for admins
+	owner.expect(http.StatusForbidden, "POST", "/api/webhooks", map[string]any{"url": "https://example.com/hook", "events": []string{"post.created"}}, nil)
+
+	var webhook models.Webhook
+	owner.expect(http.StatusCreated, "POST", "/api/webhooks", body, &webhook)
+	if webhook.Secret == "" || webhook.URL != "https://example.com/hook" {
+		t.Fatalf("created webhook = %+v", webhook)
+	}
+	path := fmt.Sprintf("/api/webhooks/%d", webhook.ID)
+	var got models.Webhook
+	owner.expect(http.StatusOK, "GET", path, nil, &got)
+	if got.ID != webhook.ID || got.Secret != "" {
+		t.Fatalf("webhook = %+v, want it without its secret", got)
+	}
+	stranger.expect(http.StatusForbidden, "GET", path, nil, nil)
+	var list models.Page[models.Webhook]
+	owner.expect(http.StatusOK, "GET", "/api/webhooks", nil, &list)
+	if len(list.Items) != 1 || list.Items[0].ID != webhook.ID {
+		t.Fatalf("webhooks = %+v", list.Items)
+	}
+	var deliveries models.Page[models.WebhookDelivery]
+	owner.expect(http.StatusOK, "GET", path+"/deliveries", nil, &deliveries)
+	if len(deliveries.Items) != 0 {
+		t.Fatalf("deliveries = %+v", deliveries.Items)
+	}
+
+	owner.expect(http.StatusNoContent, "DELETE", path, nil, nil)
+	owner.expect(http.StatusNotFound, "GET", path, nil, nil)
+}
+
+func TestSetUserRole(t *testing.T) {
+	server, stores := newServer(t)
+	admin, adminID := registerAdmin(t, server, stores, "adminuser")
+	user, userID := register(t, server, "alicesmith")
+	path := fmt.Sprintf("/api/admin/users/%d/role", userID)
+
+	user.expect(http.StatusForbidden, "PUT", path, map[string]string{"role": models.RoleAdmin}, nil)
+	admin.expect(http.StatusBadRequest, "PUT", path, map[string]string{"role": "owner"}, nil)
+	admin.expect(http.StatusBadRequest, "PUT", fmt.Sprintf("/api/admin/users/%d/role", adminID), map[string]string{"role": models.RoleUser}, nil)
+	admin.expect(http.StatusNotFound, "PUT", "/api/admin/users/999/role", map[string]string{"role": models.RoleModerator}, nil)
+	admin.expect(http.StatusNoContent, "PUT", path, map[string]string{"role": models.RoleModerator}, nil)
+	if got, err := stores.Users.GetByID(t.Context(), userID); err != nil || got.Role != models.RoleModerator {
+		t.Fatalf("user after the role change = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/notifications"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Most notifications that can be marked as read by id in one request
+const maxMarkReadIDs = 100
+
+type NotificationHandler struct {
+	models.Stores
+}
+
+// Returns the notifications of the current user, newest first. ?unread=true only returns the unread ones.
+func (m *NotificationHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	limit, err := parseIntQuery(r, "limit", defaultPageSize, maxPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursor, err := notifications.DecodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, "Invalid cursor parameter", http.StatusBadRequest)
+		return
+	}
+	unreadOnly := false
+	switch r.URL.Query().Get("unread") {
+	case "", "false":
+	case "true":
+		unreadOnly = true
+	default:
+		http.Error(w, "Invalid unread parameter, must be true or false", http.StatusBadRequest)
+		return
+	}
+	page, err := m.Notifications.List(r.Context(), userID, unreadOnly, limit, cursor)
+	if err != nil {
+		serverError(w, r, "Error fetching notifications", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// Marks notifications of the current user as read, either the given ids or all of them with {"all": true}
+func (m *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	var reqBody struct { //Request body we expect to receive
+		IDs []int64 `json:"ids"`
+		All bool    `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if reqBody.All == (len(reqBody.IDs) > 0) {
+		http.Error(w, "Send either a list of ids or all: true", http.StatusBadRequest)
+		return
+	}
+	if len(reqBody.IDs) > maxMarkReadIDs {
+		http.Error(w, fmt.Sprintf("At most %d ids can be marked as read at once", maxMarkReadIDs), http.StatusBadRequest)
+		return
+	}
+	//Ids of other users' notifications are ignored by MarkRead
+	marked, err := m.Notifications.MarkRead(r.Context(), userID, reqBody.IDs)
+	if err != nil {
+		serverError(w, r, "Error marking notifications as read", err)
+		return
+	}
+	unread, err := m.Notifications.UnreadCount(r.Context(), userID)
+	if err != nil {
+		serverError(w, r, "Error counting notifications", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"marked": marked, "unread": int64(unread)})
+}
@@ -1,18 +1,26 @@
 package handlers
 
 import (
+	"backend/diff"
 	"backend/models"
+	"backend/policy"
+	"backend/realtime"
+	"backend/webhooks"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type PostHandler struct {
-	DB *sql.DB
+	models.Stores
+	Hub        *realtime.Hub        // Live streams are told about changes, can be nil
+	Dispatcher *webhooks.Dispatcher // Webhooks are queued for new content, can be nil
 }
 
 func (m *PostHandler) GetAllTopicPosts(w http.ResponseWriter, r *http.Request) {
@@ -22,16 +30,29 @@ func (m *PostHandler) GetAllTopicPosts(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing topic_id parameter", http.StatusBadRequest)
 		return
 	}
-	PostDB := models.PostDB{DB: m.DB}
 	//Convert topic ID into integer
 	topicIDInt, err := strconv.ParseInt(topicID, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid topic_id parameter", http.StatusBadRequest)
 		return
 	}
-	posts, err := PostDB.AllByTopicID(topicIDInt) // Returns all the posts within a topic
+	opts, err := parsePostListOptions(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching posts: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		currentUserID = 0
+	}
+	page, err := parsePostPageRequest(r, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := m.Posts.AllByTopicID(r.Context(), topicIDInt, currentUserID, opts, page) // Returns a page of the posts within a topic
+	if err != nil {
+		serverError(w, r, "Error fetching posts", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -41,28 +62,47 @@ func (m *PostHandler) GetAllTopicPosts(w http.ResponseWriter, r *http.Request) {
 func (m *PostHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct { // The request body we expect to receive
 		TopicID int64  `json:"topic_id"`
-		UserID  int64  `json:"user_id"`
 		Title   string `json:"title"`
 		Content string `json:"content"`
+		//Only used to reject clients that try to pick the author, the author is always the logged in user
+		UserID *int64 `json:"user_id"`
 	}
 	//Decode the request body that is in JSON,, if the request body is not what we expected throw an error
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if reqBody.UserID != nil {
+		http.Error(w, "user_id cannot be set, the author is taken from your login", http.StatusUnprocessableEntity)
+		return
+	}
 	//Second check to ensure that the request body has all the necessary required fields.
 	if reqBody.Title == "" || reqBody.Content == "" {
 		http.Error(w, "Title, Content are required", http.StatusBadRequest)
 		return
 	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	//Make sure the topic exists before inserting, instead of relying on the foreign key error
+	topic, err := m.Topics.GetByID(r.Context(), reqBody.TopicID, 0)
+	if err != nil {
+		serverError(w, r, "Error fetching topic", err)
+		return
+	}
+	if topic == nil {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
 
-	PostDB := models.PostDB{DB: m.DB}
-
-	postID, err := PostDB.Create(reqBody.Title, reqBody.Content, reqBody.TopicID, reqBody.UserID)
+	postID, err := m.Posts.Create(r.Context(), reqBody.Title, reqBody.Content, reqBody.TopicID, currentUserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating post: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error creating post", err)
 		return
 	}
+	m.publishPost(r.Context(), realtime.EventPostCreated, postID, currentUserID)
 	w.WriteHeader(http.StatusCreated)
 	//Return the created postID
 	json.NewEncoder(w).Encode(map[string]int64{"id": postID})
@@ -79,7 +119,6 @@ func (m *PostHandler) GetPostByID(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		currentUserID = 0
 	}
-	PostDB := models.PostDB{DB: m.DB}
 	//converts PostID to integer
 	postIDInt, err := strconv.ParseInt(postID, 10, 64)
 	if err != nil {
@@ -89,9 +128,9 @@ func (m *PostHandler) GetPostByID(w http.ResponseWriter, r *http.Request) {
 	//Get the specified post by ID together with a boolean column "liked_by_user" this column will help to determine if the post is
 	// liked by the user. It is important to note that no user will ever have the user id of 0, so if the requester is a visitor
 	//without an account, this function willl still work even without a user ID.
-	post, err := PostDB.GetByID(postIDInt, currentUserID)
+	post, err := m.Posts.GetByID(r.Context(), postIDInt, currentUserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching post: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching post", err)
 		return
 	}
 	if post == nil {
@@ -109,13 +148,12 @@ func (m *PostHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing post_id parameter", http.StatusBadRequest)
 		return
 	}
-	//Get current user id, if not throw an error.
-	currentUserID, ok := getUserIDFromContext(r.Context())
+	//Get current user id and role, if not throw an error.
+	actor, ok := getActorFromContext(r.Context())
 	if !ok {
 		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
 		return
 	}
-	PostDB := models.PostDB{DB: m.DB}
 
 	postIDInt, err := strconv.ParseInt(postID, 10, 64)
 
@@ -124,26 +162,35 @@ func (m *PostHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//Get the Post by ID.
-	post, err := PostDB.GetByID(postIDInt, currentUserID)
+	post, err := m.Posts.GetByID(r.Context(), postIDInt, actor.UserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching post: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching post", err)
 		return
 	}
 	if post == nil {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
-	//Verify that the requester is authorized to delete the post.
-	if post.UserID != currentUserID {
+	//Verify that the requester is authorized to delete the post, i.e. the author or a moderator of the topic.
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanDeletePost(r.Context(), actor, post)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return
+	}
+	if !allowed {
 		http.Error(w, "Forbidden: You can only delete your own posts", http.StatusForbidden)
 		return
 	}
-	res := PostDB.Delete(postIDInt)
+	res := m.Posts.Delete(r.Context(), postIDInt)
 
 	if res != nil {
-		http.Error(w, "Error deleting comment", http.StatusInternalServerError)
+		serverError(w, r, "Error deleting post", res)
 		return
 	}
+	//The post is gone, so the notification can only carry its title
+	notifyModeration(r.Context(), m.Notifications, actor, post.UserID, sql.NullInt64{}, sql.NullInt64{}, fmt.Sprintf("Your post %q was removed by a moderator", post.Title))
+	m.Hub.Publish(realtime.EventPostDeleted, DeleteEvent{ID: post.ID, TopicID: post.TopicID}, realtime.PostChannel(post.ID), realtime.TopicChannel(post.TopicID))
 	w.WriteHeader(http.StatusNoContent)
 }
 func (m *PostHandler) Update(w http.ResponseWriter, r *http.Request) {
@@ -167,12 +214,11 @@ func (m *PostHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//Get user ID and throw authentication error if unable to get user id.
-	currentUserID, ok := getUserIDFromContext(r.Context())
+	actor, ok := getActorFromContext(r.Context())
 	if !ok {
 		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
 		return
 	}
-	PostDB := models.PostDB{DB: m.DB}
 
 	postIDInt, err := strconv.ParseInt(postID, 10, 64)
 
@@ -181,25 +227,32 @@ func (m *PostHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post, err := PostDB.GetByID(postIDInt, currentUserID)
+	post, err := m.Posts.GetByID(r.Context(), postIDInt, actor.UserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching post: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching post", err)
 		return
 	}
 	if post == nil {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
-	//Verify that the creator of the post is the same as the current user
-	if post.UserID != currentUserID {
+	//Verify that the current user is allowed to edit the post
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanUpdatePost(r.Context(), actor, post)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return
+	}
+	if !allowed {
 		http.Error(w, "Forbidden: You can only update your own posts", http.StatusForbidden)
 		return
 	}
-	res := PostDB.Update(postIDInt, reqBody.Title, reqBody.Content)
+	res := m.Posts.Update(r.Context(), postIDInt, actor.UserID, reqBody.Title, reqBody.Content)
 	if res != nil {
-		http.Error(w, "Error updating post", http.StatusInternalServerError)
+		serverError(w, r, "Error updating post", res)
 		return
 	}
+	m.publishPost(r.Context(), realtime.EventPostUpdated, postIDInt, actor.UserID)
 	w.WriteHeader(http.StatusNoContent)
 }
 func (m *PostHandler) LikePost(w http.ResponseWriter, r *http.Request) {
@@ -220,41 +273,340 @@ func (m *PostHandler) LikePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid comment_id parameter", http.StatusBadRequest)
 		return
 	}
-	PostDB := models.PostDB{DB: m.DB}
-	if err := PostDB.LikePost(postIDInt, currentUserID); err != nil {
-		http.Error(w, fmt.Sprintf("Error liking comment: %v", err), http.StatusInternalServerError)
+	if err := m.Posts.LikePost(r.Context(), postIDInt, currentUserID); err != nil {
+		serverError(w, r, "Error liking comment", err)
 		return
 	}
+	m.publishPost(r.Context(), realtime.EventPostLiked, postIDInt, currentUserID)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Adds (PUT) or removes (DELETE) one of the current user's reactions to a post, both can be repeated safely.
+// Responds with the post's reactions as the user sees them.
+func (m *PostHandler) React(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postID, err := strconv.ParseInt(vars["post_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Post ID", http.StatusBadRequest)
+		return
+	}
+	kind := vars["kind"]
+	if !parseReactionKind(w, kind) {
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	post, err := m.Posts.GetByID(r.Context(), postID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching post", err)
+		return
+	}
+	if post == nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	changed, err := m.Posts.SetReaction(r.Context(), postID, currentUserID, kind, r.Method == http.MethodPut)
+	if err != nil {
+		serverError(w, r, "Error updating reaction", err)
+		return
+	}
+	if changed {
+		if kind == models.ReactionLike {
+			m.publishPost(r.Context(), realtime.EventPostLiked, postID, currentUserID)
+		}
+		m.publishReactions(r.Context(), post)
+	}
+	reactions, err := m.Posts.Reactions(r.Context(), postID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching reactions", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reactions)
+}
+
+// Bookmarks (POST) or removes the bookmark of (DELETE) a post for the current user
+func (m *PostHandler) Bookmark(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postID, err := strconv.ParseInt(vars["post_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Post ID", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	post, err := m.Posts.GetByID(r.Context(), postID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching post", err)
+		return
+	}
+	if post == nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		err = m.Bookmarks.RemovePost(r.Context(), postID, currentUserID)
+	} else {
+		err = m.Bookmarks.AddPost(r.Context(), postID, currentUserID)
+	}
+	if err != nil {
+		serverError(w, r, "Error updating bookmark", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Likes (PUT) or unlikes (DELETE) a post. Unlike the POST toggle both are safe to retry,
+// the response has the new like count and whether the user likes the post.
+func (m *PostHandler) SetLike(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postID, err := strconv.ParseInt(vars["post_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Post ID", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	post, err := m.Posts.GetByID(r.Context(), postID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching post", err)
+		return
+	}
+	if post == nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	changed, err := m.Posts.SetLike(r.Context(), postID, currentUserID, r.Method == http.MethodPut)
+	if err != nil {
+		serverError(w, r, "Error liking post", err)
+		return
+	}
+	if changed {
+		m.publishPost(r.Context(), realtime.EventPostLiked, postID, currentUserID)
+		m.publishReactions(r.Context(), post)
+	}
+	state, err := m.Posts.LikeState(r.Context(), postID, currentUserID)
+	if err != nil || state == nil {
+		serverError(w, r, "Error fetching likes", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
 func (m *PostHandler) GetAllPosts(w http.ResponseWriter, r *http.Request) {
-	PostDB := models.PostDB{DB: m.DB}
 
-	size_str := r.URL.Query().Get("size")
-	offset_str := r.URL.Query().Get("offset")
 	currentUserID, ok := getUserIDFromContext(r.Context())
 	if !ok {
 		currentUserID = 0
 	}
 
-	var posts []models.Post
-
-	var err error
+	opts, err := parsePostListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if size_str != "" && offset_str != "" {
-		size, _ := strconv.ParseInt(size_str, 10, 64)
-		offset, _ := strconv.ParseInt(offset_str, 10, 64)
-		posts, err = PostDB.GetAll(currentUserID, size, offset)
-	} else {
-		// If no size or offset is specified just return the first 10
-		posts, err = PostDB.GetAll(currentUserID, 10, 0)
+	page, err := parsePostPageRequest(r, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	posts, err := m.Posts.GetAll(r.Context(), currentUserID, opts, page)
 	if err != nil {
 		fmt.Print(err)
-		http.Error(w, "Error fetching posts", http.StatusInternalServerError)
+		serverError(w, r, "Error fetching posts", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(posts)
 
 }
+
+// The home feed, posts from the topics the current user is subscribed to. Takes the same sort, t, limit and cursor parameters as /api/posts.
+func (m *PostHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	opts, err := parsePostListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := parsePostPageRequest(r, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := m.Posts.Feed(r.Context(), currentUserID, opts, page)
+	if err != nil {
+		serverError(w, r, "Error fetching feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// Time windows accepted by the t query parameter
+var postListWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// Reads the sort (new, hot, top or rising) and t (day, week, month or all) query parameters of the post listings.
+// Rising only makes sense for recent posts, so it defaults to the last day.
+func parsePostListOptions(r *http.Request) (models.PostListOptions, error) {
+	opts := models.PostListOptions{Sort: r.URL.Query().Get("sort")}
+	switch opts.Sort {
+	case "":
+		opts.Sort = models.SortNew
+	case models.SortNew, models.SortHot, models.SortTop, models.SortRising:
+	default:
+		return opts, errors.New("Invalid sort parameter, must be one of new, hot, top or rising")
+	}
+	window := r.URL.Query().Get("t")
+	if window == "" && opts.Sort == models.SortRising {
+		window = "day"
+	}
+	if window != "" && window != "all" {
+		d, ok := postListWindows[window]
+		if !ok {
+			return opts, errors.New("Invalid t parameter, must be one of day, week, month or all")
+		}
+		opts.Since = time.Now().UTC().Add(-d)
+	}
+	return opts, nil
+}
+
+// Same as parsePageRequest, but also makes sure the cursor was created for the same sort order
+func parsePostPageRequest(r *http.Request, opts models.PostListOptions) (models.PageRequest, error) {
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return page, err
+	}
+	if page.Cursor != nil && page.Cursor.Sort != opts.Sort {
+		return page, errors.New("Cursor does not match the sort parameter")
+	}
+	return page, nil
+}
+
+// Returns the edit history of a post, oldest revision first
+func (m *PostHandler) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postID, err := strconv.ParseInt(vars["post_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid post_id parameter", http.StatusBadRequest)
+		return
+	}
+	post, err := m.Posts.GetByID(r.Context(), postID, 0)
+	if err != nil {
+		serverError(w, r, "Error fetching post", err)
+		return
+	}
+	if post == nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	revisions, err := m.Posts.Revisions(r.Context(), postID)
+	if err != nil {
+		serverError(w, r, "Error fetching revisions", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// Response of the revision diff endpoint, From and To are revision numbers
+type RevisionDiffResponse struct {
+	PostID  int64     `json:"post_id"`
+	From    int       `json:"from"`
+	To      int       `json:"to"`
+	Mode    string    `json:"mode"`
+	Title   []diff.Op `json:"title"`
+	Content []diff.Op `json:"content"`
+}
+
+// Diffs a revision against the one after it, i.e. shows what edit {rev} changed.
+// ?against=N compares with another revision instead, the current post is revision edit_count + 1.
+// ?mode=word (default) or ?mode=line picks the granularity of the content diff, titles are always diffed by word.
+func (m *PostHandler) GetRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	postID, err := strconv.ParseInt(vars["post_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid post_id parameter", http.StatusBadRequest)
+		return
+	}
+	from, err := strconv.Atoi(vars["rev"])
+	if err != nil || from < 1 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+	to := from + 1
+	if against := r.URL.Query().Get("against"); against != "" {
+		to, err = strconv.Atoi(against)
+		if err != nil || to < 1 {
+			http.Error(w, "Invalid against parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "word"
+	}
+	if mode != "word" && mode != "line" {
+		http.Error(w, "Invalid mode parameter, must be word or line", http.StatusBadRequest)
+		return
+	}
+	post, err := m.Posts.GetByID(r.Context(), postID, 0)
+	if err != nil {
+		serverError(w, r, "Error fetching post", err)
+		return
+	}
+	if post == nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	//Loads the title and content of a revision, the latest revision is the post itself
+	load := func(rev int) (string, string, bool, error) {
+		if rev == post.EditCount+1 {
+			return post.Title, post.Content, true, nil
+		}
+		revision, err := m.Posts.GetRevision(r.Context(), postID, rev)
+		if err != nil || revision == nil {
+			return "", "", false, err
+		}
+		return revision.Title, revision.Content, true, nil
+	}
+	fromTitle, fromContent, found, err := load(from)
+	if err == nil && found {
+		var toTitle, toContent string
+		toTitle, toContent, found, err = load(to)
+		if err == nil && found {
+			response := RevisionDiffResponse{PostID: postID, From: from, To: to, Mode: mode, Title: diff.Words(fromTitle, toTitle)}
+			if mode == "line" {
+				response.Content = diff.Lines(fromContent, toContent)
+			} else {
+				response.Content = diff.Words(fromContent, toContent)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+	}
+	if err != nil {
+		serverError(w, r, "Error fetching revision", err)
+		return
+	}
+	http.Error(w, "Revision not found", http.StatusNotFound)
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"backend/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type ReactionHandler struct{}
+
+// Returns the reaction kinds users can pick from, in display order
+func (m *ReactionHandler) Kinds(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ReactionKinds())
+}
+
+// Reads the reaction kind in the URL, writing the error response if it isn't one of the configured kinds
+func parseReactionKind(w http.ResponseWriter, kind string) bool {
+	if !models.ValidReactionKind(kind) {
+		http.Error(w, fmt.Sprintf("Unknown reaction %q, pick from %s", kind, strings.Join(models.ReactionKinds(), ", ")), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
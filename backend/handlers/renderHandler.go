@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"backend/markdown"
+	"encoding/json"
+	"net/http"
+)
+
+// Largest preview request we accept, posts and comments are stored in TEXT columns which hold 64KiB
+const maxPreviewBodySize = 128 << 10
+
+// Renders Markdown the same way posts and comments are rendered when they are saved
+type RenderHandler struct{}
+
+type PreviewResponse struct {
+	Content     string `json:"content"`
+	ContentHTML string `json:"content_html"`
+}
+
+// Renders a draft so the editor can show exactly what will be published
+func (m *RenderHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPreviewBodySize)
+	var reqBody struct { //Request body we expect to receive
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PreviewResponse{Content: reqBody.Content, ContentHTML: markdown.Render(reqBody.Content)})
+}
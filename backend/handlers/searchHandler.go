@@ -2,43 +2,64 @@ package handlers
 
 import (
 	"backend/models"
-	"database/sql"
 	"encoding/json"
 	"net/http"
 )
 
 type SearchResponse struct {
-	Posts  []models.Post  `json:"posts"`
-	Topics []models.Topic `json:"topics"`
+	Posts  models.Page[models.Post]  `json:"posts"`
+	Topics models.Page[models.Topic] `json:"topics"`
 }
 
 type SearchHandler struct {
-	DB *sql.DB
+	models.Stores
 }
 
+// Searches both posts and topics and returns the first page of each.
+// With ?type=posts or ?type=topics only that kind is searched, and the limit and cursor parameters page through it.
 func (m *SearchHandler) SearchPostAndTopics(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
 		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
 		return
 	}
-	PostDB := models.PostDB{DB: m.DB}
-	posts, err := PostDB.SearchPost(query)
-	if err != nil {
-		http.Error(w, "Error searching for posts", http.StatusInternalServerError)
+	searchType := r.URL.Query().Get("type")
+	if searchType != "" && searchType != "posts" && searchType != "topics" {
+		http.Error(w, "Invalid type parameter, must be posts or topics", http.StatusBadRequest)
 		return
 	}
-	TopicDB := models.TopicDB{DB: m.DB}
-	topics, err := TopicDB.SearchTopic(query)
+	page, err := parsePageRequest(r)
 	if err != nil {
-		http.Error(w, "Error searching for topics", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	response := SearchResponse{
-		Posts:  posts,
-		Topics: topics,
+	if searchType == "" && page.Cursor != nil {
+		http.Error(w, "The type parameter is required when using a cursor", http.StatusBadRequest)
+		return
+	}
+	var response SearchResponse
+	if searchType != "topics" {
+		response.Posts, err = m.Posts.SearchPost(r.Context(), query, page)
+		if err != nil {
+			serverError(w, r, "Error searching for posts", err)
+			return
+		}
+		if searchType == "posts" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response.Posts)
+			return
+		}
+	}
+	currentUserID, _ := getUserIDFromContext(r.Context())
+	response.Topics, err = m.Topics.SearchTopic(r.Context(), query, currentUserID, page)
+	if err != nil {
+		serverError(w, r, "Error searching for topics", err)
+		return
 	}
-
 	w.Header().Set("Content-Type", "application/json")
+	if searchType == "topics" {
+		json.NewEncoder(w).Encode(response.Topics)
+		return
+	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/realtime"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	//Comments are sent every heartbeatInterval so proxies don't close idle streams, the session is rechecked at the same time
+	heartbeatInterval = 25 * time.Second
+	//A client that can't take a write within this time is disconnected
+	streamWriteTimeout = 10 * time.Second
+	//Most posts plus topics one stream can follow
+	maxStreamChannels = 50
+)
+
+// Streams live events to the clients, over Server-Sent Events or a WebSocket.
+// Both take the channels to follow as query parameters: ?post=1&post=2&topic=3&notifications=true
+type StreamHandler struct {
+	models.Stores
+	Hub *realtime.Hub
+	//Origins allowed to open a WebSocket, the same list as the CORS configuration
+	AllowedOrigins []string
+}
+
+// Reads the channels to subscribe to, notifications are only available to logged in users
+func parseStreamChannels(r *http.Request, userID int64) ([]string, int, error) {
+	query := r.URL.Query()
+	var channels []string
+	for _, param := range []string{"post", "topic"} {
+		for _, v := range query[param] {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id < 1 {
+				return nil, http.StatusBadRequest, fmt.Errorf("Invalid %s parameter", param)
+			}
+			if param == "post" {
+				channels = append(channels, realtime.PostChannel(id))
+			} else {
+				channels = append(channels, realtime.TopicChannel(id))
+			}
+		}
+	}
+	if len(channels) > maxStreamChannels {
+		return nil, http.StatusBadRequest, fmt.Errorf("At most %d posts and topics can be followed at once", maxStreamChannels)
+	}
+	if query.Get("notifications") == "true" {
+		if userID <= 0 {
+			return nil, http.StatusUnauthorized, fmt.Errorf("Log in to receive notifications")
+		}
+		channels = append(channels, realtime.UserChannel(userID))
+	}
+	if len(channels) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("Nothing to subscribe to, pass post, topic or notifications=true")
+	}
+	return channels, 0, nil
+}
+
+// Checks that the session of a logged in stream has not been revoked since it was opened
+func (m *StreamHandler) sessionActive(r *http.Request, userID int64) bool {
+	sessionID, ok := getSessionIDFromContext(r.Context())
+	if !ok {
+		return true // Anonymous streams have no session
+	}
+	active, err := m.Sessions.IsActive(r.Context(), sessionID, userID)
+	return err != nil || active // A database hiccup shouldn't drop every stream
+}
+
+// GET /api/stream, Server-Sent Events. Reconnecting clients resume from the Last-Event-ID header
+// (or ?last_event_id=), a "reset" event means the missed events are gone and the client should reload.
+func (m *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r.Context())
+	channels, status, err := parseStreamChannels(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	sub, replay, resumed := m.Hub.Subscribe(channels, lastEventID)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Stops nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	//Writes a chunk and flushes it, any error means the client is gone or too slow
+	write := func(chunk string) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprint(w, chunk); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	writeEvent := func(ev realtime.Event) bool {
+		return write(fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Data))
+	}
+
+	if !write(fmt.Sprintf("retry: %d\n\n", 3000)) {
+		return
+	}
+	if !resumed && !write("event: reset\ndata: {}\n\n") {
+		return
+	}
+	for _, ev := range replay {
+		if !writeEvent(ev) {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Done():
+			//Dropped for being too slow, closing the stream makes the client reconnect and resume
+			return
+		case ev := <-sub.Events():
+			if !writeEvent(ev) {
+				return
+			}
+		case <-heartbeat.C:
+			if !m.sessionActive(r, userID) || !write(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// Only lets browsers on the frontend origins open a WebSocket, the cookie would otherwise let any site use the user's session
+func (m *StreamHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // Not a browser
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host || slices.Contains(m.AllowedOrigins, origin)
+}
+
+// The first message on a WebSocket stream, tells the client if it resumed from last_event_id
+type streamHello struct {
+	Type    string `json:"type"`
+	Resumed bool   `json:"resumed"`
+}
+
+// GET /api/stream/ws, the same stream over a WebSocket. Every event is sent as a JSON text message,
+// resuming works with ?last_event_id= and heartbeats are ping frames.
+func (m *StreamHandler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r.Context())
+	channels, status, err := parseStreamChannels(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: m.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an error
+	}
+	defer conn.Close()
+
+	sub, replay, resumed := m.Hub.Subscribe(channels, r.URL.Query().Get("last_event_id"))
+	defer sub.Close()
+
+	//Clients don't send anything, but reading is needed to process pongs and notice when the connection closes
+	closed := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	writeJSON := func(v any) bool {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(v) == nil
+	}
+	if !writeJSON(streamHello{Type: "hello", Resumed: resumed}) {
+		return
+	}
+	for _, ev := range replay {
+		if !writeJSON(ev) {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-sub.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow, reconnect"), time.Now().Add(time.Second))
+			return
+		case ev := <-sub.Events():
+			if !writeJSON(ev) {
+				return
+			}
+		case <-heartbeat.C:
+			if !m.sessionActive(r, userID) {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session revoked"), time.Now().Add(time.Second))
+				return
+			}
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)) != nil {
+				return
+			}
+		}
+	}
+}
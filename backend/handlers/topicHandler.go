@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"backend/models"
+	"backend/policy"
+	"backend/webhooks"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -12,30 +15,21 @@ import (
 )
 
 type TopicHandler struct {
-	DB *sql.DB
+	models.Stores
+	Dispatcher *webhooks.Dispatcher // Webhooks are told about topic changes, can be nil
 }
 
 func (m *TopicHandler) GetAllTopics(w http.ResponseWriter, r *http.Request) {
-	TopicDB := models.TopicDB{DB: m.DB}
-	//Size specifies the number of search results to give
-	size_str := r.URL.Query().Get("size")
-	//Offset specifies the offset for the results, eg. we already served 1 to 10, we want 11 to 20. So the offset should be 10.
-	offset_str := r.URL.Query().Get("offset")
-	var topics []models.Topic
-
-	var err error
-
-	if size_str != "" && offset_str != "" {
-		size, _ := strconv.Atoi(size_str)
-		offset, _ := strconv.Atoi(offset_str)
-		topics, err = TopicDB.GetByBatch(size, offset)
-	} else {
-		// If no size or offset is specified just return all the topics, THIS CAN BE VERY SLOW IT MIGHT BE BETTER TO SET A DEFAULT LIMIT
-		//FOR FUTURE REFERENCE.
-		topics, err = TopicDB.All()
+	//Limit specifies the number of topics to give, the cursor is the next_cursor (or prev_cursor) of the previous page
+	page, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	currentUserID, _ := getUserIDFromContext(r.Context())
+	topics, err := m.Topics.All(r.Context(), currentUserID, page)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching topics: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching topics", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -50,10 +44,10 @@ func (m *TopicHandler) Get(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
 		return
 	}
-	TopicDB := models.TopicDB{DB: m.DB}
-	topic, err := TopicDB.GetByID(topicID)
+	currentUserID, _ := getUserIDFromContext(r.Context())
+	topic, err := m.Topics.GetByID(r.Context(), topicID, currentUserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching topic: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching topic", err)
 		return
 	}
 	if topic == nil {
@@ -68,21 +62,31 @@ func (m *TopicHandler) CreateTopic(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct { //Request body that we expect to receive
 		Title       string `json:"title"`
 		Description string `json:"description"`
-		CreatedBy   int64  `json:"created_by"`
+		//Only used to reject clients that try to pick the creator, the creator is always the logged in user
+		CreatedBy *int64 `json:"created_by"`
+		UserID    *int64 `json:"user_id"`
 	}
 	//Validate the request inputs
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if reqBody.CreatedBy != nil || reqBody.UserID != nil {
+		http.Error(w, "created_by cannot be set, the creator is taken from your login", http.StatusUnprocessableEntity)
+		return
+	}
 	if reqBody.Title == "" || reqBody.Description == "" {
-		http.Error(w, "Title, Description, and CreatedBy are required", http.StatusBadRequest)
+		http.Error(w, "Title and Description are required", http.StatusBadRequest)
 		return
 	}
-	TopicDB := models.TopicDB{DB: m.DB}
-	topicID, err := TopicDB.Create(reqBody.Title, reqBody.Description, reqBody.CreatedBy)
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	topicID, err := m.Topics.Create(r.Context(), reqBody.Title, reqBody.Description, currentUserID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating topic: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error creating topic", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -93,7 +97,7 @@ func (m *TopicHandler) DeleteTopic(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	topicIDParam := vars["topic_id"]
 	//Get the user ID from the context, if not throw an auth error.
-	currentUserID, ok := getUserIDFromContext(r.Context())
+	actor, ok := getActorFromContext(r.Context())
 	if !ok {
 		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
 		return
@@ -105,10 +109,9 @@ func (m *TopicHandler) DeleteTopic(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
 		return
 	}
-	TopicDB := models.TopicDB{DB: m.DB}
-	topic, err := TopicDB.GetByID(topicID)
+	topic, err := m.Topics.GetByID(r.Context(), topicID, 0)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching topic: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching topic", err)
 		return
 	}
 	if topic == nil {
@@ -116,22 +119,29 @@ func (m *TopicHandler) DeleteTopic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//Verify that the requester is authorized to delete the topic
-	if topic.UserID != currentUserID {
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanDeleteTopic(r.Context(), actor, topic)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return
+	}
+	if !allowed {
 		http.Error(w, "Forbidden: You can only delete your own topics", http.StatusForbidden)
 		return
 	}
-	err = TopicDB.Delete(topicID)
+	err = m.Topics.Delete(r.Context(), topicID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting topic: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error deleting topic", err)
 		return
 	}
+	notifyModeration(r.Context(), m.Notifications, actor, topic.UserID, sql.NullInt64{}, sql.NullInt64{}, fmt.Sprintf("Your topic %q was deleted by an admin", topic.Title))
 	w.WriteHeader(http.StatusNoContent)
 }
 func (m *TopicHandler) UpdateTopic(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	topicIDParam := vars["topic_id"]
 	//get the user ID from the context, if unable throw an auth error
-	currentUserID, ok := getUserIDFromContext(r.Context())
+	actor, ok := getActorFromContext(r.Context())
 	if !ok {
 		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
 		return
@@ -142,18 +152,23 @@ func (m *TopicHandler) UpdateTopic(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
 		return
 	}
-	TopicDB := models.TopicDB{DB: m.DB}
-	topic, err := TopicDB.GetByID(topicID)
+	topic, err := m.Topics.GetByID(r.Context(), topicID, 0)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching topic: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching topic", err)
 		return
 	}
 	if topic == nil {
 		http.Error(w, "Topic not found", http.StatusNotFound)
 		return
 	}
-	//Verify that the user is authorized to make the changes
-	if topic.UserID != currentUserID {
+	//Verify that the user is authorized to make the changes, i.e. the creator or a moderator of the topic
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanUpdateTopic(r.Context(), actor, topic)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return
+	}
+	if !allowed {
 		http.Error(w, "Forbidden: You can only update your own topics", http.StatusForbidden)
 		return
 	}
@@ -170,10 +185,76 @@ func (m *TopicHandler) UpdateTopic(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Title and Description are required", http.StatusBadRequest)
 		return
 	}
-	err = TopicDB.Update(topicID, reqBody.Title, reqBody.Description)
+	err = m.Topics.Update(r.Context(), topicID, reqBody.Title, reqBody.Description)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error updating topic: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error updating topic", err)
 		return
 	}
+	notifyModeration(r.Context(), m.Notifications, actor, topic.UserID, sql.NullInt64{}, sql.NullInt64{}, fmt.Sprintf("A moderator edited your topic %q", topic.Title))
+	if m.Dispatcher != nil {
+		if updated, err := m.Topics.GetByID(r.Context(), topicID, 0); err != nil || updated == nil {
+			log.Printf("Error loading topic %d for %s webhooks: %v", topicID, webhooks.EventTopicUpdated, err)
+		} else {
+			enqueueWebhook(r.Context(), m.Dispatcher, webhooks.EventTopicUpdated, topicID, 0, updated)
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
+
+// Returns the moderators of a topic
+func (m *TopicHandler) GetModerators(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	topicID, err := strconv.ParseInt(vars["topic_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+	moderators, err := m.Topics.Moderators(r.Context(), topicID)
+	if err != nil {
+		serverError(w, r, "Error fetching moderators", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(moderators)
+}
+
+// Subscribes (POST) or unsubscribes (DELETE) the current user to a topic, the posts of subscribed topics make up the home feed.
+// Responds with the topic so the client gets the new subscriber count.
+func (m *TopicHandler) Subscription(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	topicID, err := strconv.ParseInt(vars["topic_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	topic, err := m.Topics.GetByID(r.Context(), topicID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching topic", err)
+		return
+	}
+	if topic == nil {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		err = m.Topics.Unsubscribe(r.Context(), topicID, currentUserID)
+	} else {
+		err = m.Topics.Subscribe(r.Context(), topicID, currentUserID)
+	}
+	if err != nil {
+		serverError(w, r, "Error updating subscription", err)
+		return
+	}
+	topic, err = m.Topics.GetByID(r.Context(), topicID, currentUserID)
+	if err != nil || topic == nil {
+		serverError(w, r, "Error fetching topic", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topic)
+}
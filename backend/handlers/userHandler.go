@@ -1,10 +1,9 @@
 package handlers
 
 import (
+	"backend/middleware"
 	"backend/models"
-	"database/sql"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -18,14 +17,16 @@ import (
 // Our user handler class is a little different and takes in the JWT key, this is the secret key used to sign and verify the
 // legitimacy of the tokens.
 type UserHandler struct {
-	DB     *sql.DB
-	JWTKey []byte
+	models.Stores
+	JWTKey  []byte
+	Proxies middleware.TrustedProxies // Where X-Forwarded-For is believed, for the IP address shown with sessions
 }
 
 // This stores the information that we want to keep in our JWT
 type Claims struct {
-	UserID               int64 `json:"user_id"`
-	jwt.RegisteredClaims       // Standard JWT fields like Expiry
+	UserID               int64  `json:"user_id"`
+	Role                 string `json:"role"`
+	jwt.RegisteredClaims        // Standard JWT fields like Expiry
 }
 
 func containsWhitespace(s string) bool {
@@ -34,14 +35,15 @@ func containsWhitespace(s string) bool {
 func (m *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct { //Request body we expect to receive
 		Username string `json:"username"`
+		Password string `json:"password"`
 	}
 	//Validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	if reqBody.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+	if reqBody.Username == "" || reqBody.Password == "" {
+		http.Error(w, "Username and password are required", http.StatusBadRequest)
 		return
 	}
 	//check username for white spaces and length
@@ -53,19 +55,27 @@ func (m *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Username cannot contain whitespace", http.StatusBadRequest)
 		return
 	}
-	UserDB := models.UserDB{DB: m.DB}
-	row, err := UserDB.DB.Query("SELECT id FROM users WHERE username = ?", reqBody.Username)
+	if err := validatePassword(reqBody.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	existing, err := m.Users.GetByUsername(r.Context(), reqBody.Username)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error checking existing user: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error checking existing user", err)
 		return
 	}
-	if row.Next() { // Check if user already exists
+	if existing != nil { // Check if user already exists
 		http.Error(w, "User already exists", http.StatusConflict)
 		return
 	}
-	userID, err := UserDB.Create(reqBody.Username)
+	passwordHash, err := hashPassword(reqBody.Password)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+	userID, err := m.Users.Create(r.Context(), reqBody.Username, passwordHash)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating user: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error creating user", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -85,9 +95,8 @@ func (m *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//TODO: Add authorization if this feature is intended
-	UserDB := models.UserDB{DB: m.DB}
-	if err := UserDB.Delete(reqBody.UserID); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting user: %v", err), http.StatusInternalServerError)
+	if err := m.Users.Delete(r.Context(), reqBody.UserID); err != nil {
+		serverError(w, r, "Error deleting user", err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -95,6 +104,7 @@ func (m *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 func (m *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct { //Request body we expect to receive
 		UserName string `json:"username"`
+		Password string `json:"password"`
 	}
 	//validate request body
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
@@ -102,42 +112,166 @@ func (m *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if reqBody.UserName == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+	if reqBody.UserName == "" || reqBody.Password == "" {
+		http.Error(w, "Username and password are required", http.StatusBadRequest)
 		return
 	}
 
-	UserDB := models.UserDB{DB: m.DB}
-	user, err := UserDB.GetByUsername(reqBody.UserName)
+	user, err := m.Users.GetByUsername(r.Context(), reqBody.UserName)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching user: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching user", err)
 		return
 	}
 
-	var userID int64
-	//Check if user exists in the database
+	//Check if user exists in the database, we still compare against a dummy hash so that the response time
+	//does not reveal which usernames are registered.
 	if user == nil {
-		http.Error(w, "User not found, please register", http.StatusNotFound)
+		checkPassword(string(dummyPasswordHash), reqBody.Password)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	//Accounts created before passwords were introduced have no hash yet. Their owners log in once with the setup token
+	//an admin gave them, which only tells the client to go through the set password flow (POST /api/users/password/setup).
+	//Anything else gets the same answer as a wrong password, so the response doesn't reveal which accounts have no password.
+	if !user.PasswordHash.Valid {
+		checkPassword(string(dummyPasswordHash), reqBody.Password)
+		valid, err := m.Users.CheckPasswordSetupToken(r.Context(), user.ID, hashToken(reqBody.Password))
+		if err != nil {
+			serverError(w, r, "Error fetching user", err)
+			return
+		}
+		if !valid {
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Password setup required, please choose a password for your account", http.StatusPreconditionRequired)
+		return
+	}
+	if !checkPassword(user.PasswordHash.String, reqBody.Password) {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if err := m.issueToken(w, r, user.ID); err != nil {
+		serverError(w, r, "Error logging in", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
-	} else {
-		userID = user.ID
 	}
-	//Create a DateTime object for 24 hours from the login time.
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
+}
 
-	// Create the Claims to be stored inside the cookie, in this case its just the
-	// user id and the expiration time
+// Lets an account created before passwords were introduced set its first password, then logs the user in.
+// The owner proves the account is theirs with the one-time setup token an admin issued for it
+// (POST /api/admin/users/{user_id}/password-setup). Unknown users, wrong tokens and accounts that already have
+// a password all get the same answer, so this can't be used to find out which accounts exist.
+func (m *UserHandler) SetupPassword(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct { //Request body we expect to receive
+		UserName string `json:"username"`
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if reqBody.UserName == "" || reqBody.Token == "" {
+		http.Error(w, "Username and setup token are required", http.StatusBadRequest)
+		return
+	}
+	if err := validatePassword(reqBody.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := m.Users.GetByUsername(r.Context(), reqBody.UserName)
+	if err != nil {
+		serverError(w, r, "Error fetching user", err)
+		return
+	}
+	//Hashing the password takes the same time whether or not the user exists
+	passwordHash, err := hashPassword(reqBody.Password)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Invalid username or setup token", http.StatusUnauthorized)
+		return
+	}
+	//Uses up the token and only succeeds if the account still has no password, so two concurrent requests can't both get through
+	updated, err := m.Users.SetInitialPassword(r.Context(), user.ID, hashToken(reqBody.Token), passwordHash)
+	if err != nil {
+		serverError(w, r, "Error setting password", err)
+		return
+	}
+	if !updated {
+		http.Error(w, "Invalid username or setup token", http.StatusUnauthorized)
+		return
+	}
+	if err := m.issueToken(w, r, user.ID); err != nil {
+		serverError(w, r, "Error logging in", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// Starts a new session for the user and sends back both an access token and a refresh token as cookies
+func (m *UserHandler) issueToken(w http.ResponseWriter, r *http.Request, userID int64) error {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	if err := m.Sessions.Create(r.Context(), sessionID, userID, userAgent, m.Proxies.ClientIP(r)); err != nil {
+		return err
+	}
+	refreshToken, err := newToken()
+	if err != nil {
+		return err
+	}
+	refreshExpiry := time.Now().Add(refreshTokenTTL)
+	if err := m.RefreshTokens.Create(r.Context(), sessionID, hashToken(refreshToken), refreshExpiry); err != nil {
+		return err
+	}
+	if err := m.setAccessToken(w, r, sessionID, userID); err != nil {
+		return err
+	}
+	setRefreshTokenCookie(w, refreshToken, refreshExpiry)
+	return nil
+}
+
+// Signs a short lived JWT for the session and sends it back to the client as a cookie
+func (m *UserHandler) setAccessToken(w http.ResponseWriter, r *http.Request, sessionID string, userID int64) error {
+	expirationTime := time.Now().Add(accessTokenTTL)
+
+	//The role is read again on every refresh, so role changes reach the user within one access token lifetime
+	user, err := m.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errUserNotFound
+	}
+
+	// Create the Claims to be stored inside the cookie, this is the user id, their role, the session id (jti)
+	// and the expiration time
 	claims := &Claims{
 		UserID: userID,
+		Role:   user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims) //Creates the token with the specified claims above
 	tokenString, err := token.SignedString(m.JWTKey)           //Signs the token with the secret passphrase stored in the .env
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return err
 	}
 	//sends the cookie back to the client
 	http.SetCookie(w, &http.Cookie{
@@ -149,12 +283,47 @@ func (m *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 		Secure:   true,
 		SameSite: http.SameSiteNoneMode,
 	})
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+	return nil
+}
+
+// Exchanges the refresh token cookie for a new access token and a new refresh token.
+// Every refresh token can only be used once, replaying an old one logs out the whole session.
+func (m *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshTokenCookie)
+	if err != nil || cookie.Value == "" {
+		http.Error(w, "Missing refresh token", http.StatusUnauthorized)
+		return
+	}
+	newToken, err := newToken()
+	if err != nil {
+		http.Error(w, "Error generating refresh token", http.StatusInternalServerError)
 		return
 	}
+	refreshExpiry := time.Now().Add(refreshTokenTTL)
+	sessionID, userID, err := m.RefreshTokens.Rotate(r.Context(), hashToken(cookie.Value), hashToken(newToken), refreshExpiry)
+	if err == models.ErrRefreshTokenInvalid || err == models.ErrRefreshTokenReused {
+		clearAuthCookies(w)
+		http.Error(w, "Session expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		serverError(w, r, "Error refreshing session", err)
+		return
+	}
+	if err := m.setAccessToken(w, r, sessionID, userID); err != nil {
+		serverError(w, r, "Error refreshing session", err)
+		return
+	}
+	setRefreshTokenCookie(w, newToken, refreshExpiry)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The current user together with their number of unread notifications
+type MeResponse struct {
+	*models.User
+	UnreadNotifications int `json:"unread_notifications"`
 }
+
 func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) { // Returns the user object from the userid in the context
 	userID, ok := getUserIDFromContext(r.Context())
 	if !ok {
@@ -162,28 +331,42 @@ func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) { // Returns
 		return
 	}
 
-	userDB := models.UserDB{DB: h.DB}
-	user, err := userDB.GetByID(userID)
-	if err != nil {
+	user, err := h.Users.GetByID(r.Context(), userID)
+	if err != nil || user == nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	unread, err := h.Notifications.UnreadCount(r.Context(), userID)
+	if err != nil {
+		serverError(w, r, "Error counting notifications", err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(MeResponse{User: user, UnreadNotifications: unread})
 }
 func (m *UserHandler) Logout(w http.ResponseWriter, r *http.Request) { //Logout function
-	//MaxAge and Epires both "tell" the browser to delete the cookie but just added both for completeness.
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		MaxAge:   -1,
-		Expires:  time.Unix(0, 0),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-	})
+	//Revoke the session on the server so that the tokens can't be reused even if they were copied before logging out
+	userID, _ := getUserIDFromContext(r.Context())
+	sessionID, ok := getSessionIDFromContext(r.Context())
+	if !ok {
+		//The access token may have already expired, so fall back to the session of the refresh token
+		if cookie, err := r.Cookie(refreshTokenCookie); err == nil && cookie.Value != "" {
+			var err error
+			sessionID, userID, err = m.RefreshTokens.GetSession(r.Context(), hashToken(cookie.Value))
+			if err != nil {
+				serverError(w, r, "Error revoking session", err)
+				return
+			}
+		}
+	}
+	if sessionID != "" {
+		if _, err := m.Sessions.Revoke(r.Context(), sessionID, userID); err != nil {
+			serverError(w, r, "Error revoking session", err)
+			return
+		}
+	}
+	clearAuthCookies(w)
 	w.WriteHeader(http.StatusNoContent)
 	w.Write([]byte("Logged out successfully"))
 }
@@ -196,10 +379,9 @@ func (m *UserHandler) GetByID(w http.ResponseWriter, r *http.Request) { // Retur
 		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
-	UserDB := models.UserDB{DB: m.DB}
-	user, err := UserDB.GetByID(uid)
+	user, err := m.Users.GetByID(r.Context(), uid)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching user: %v", err), http.StatusInternalServerError)
+		serverError(w, r, "Error fetching user", err)
 		return
 	}
 	if user == nil {
@@ -209,3 +391,112 @@ func (m *UserHandler) GetByID(w http.ResponseWriter, r *http.Request) { // Retur
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
+
+// Suggests users for the mention picker of the editor, GET /api/users/autocomplete?prefix=
+func (m *UserHandler) Autocomplete(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimPrefix(r.URL.Query().Get("prefix"), "@")
+	if prefix == "" {
+		http.Error(w, "Missing prefix parameter", http.StatusBadRequest)
+		return
+	}
+	limit, err := parseIntQuery(r, "limit", defaultAutocompleteLimit, maxAutocompleteLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	users, err := m.Users.Autocomplete(r.Context(), prefix, limit)
+	if err != nil {
+		serverError(w, r, "Error fetching users", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// Returns the saved posts and comments of the current user, most recently saved first.
+// ?type=post|comment and ?topic_id= narrow the list down.
+func (m *UserHandler) GetBookmarks(w http.ResponseWriter, r *http.Request) {
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	filter := models.BookmarkFilter{Type: r.URL.Query().Get("type")}
+	if filter.Type != "" && filter.Type != models.BookmarkPost && filter.Type != models.BookmarkComment {
+		http.Error(w, "Invalid type parameter, must be post or comment", http.StatusBadRequest)
+		return
+	}
+	if v := r.URL.Query().Get("topic_id"); v != "" {
+		topicID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || topicID < 1 {
+			http.Error(w, "Invalid topic_id parameter", http.StatusBadRequest)
+			return
+		}
+		filter.TopicID = topicID
+	}
+	page, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bookmarks, err := m.Bookmarks.All(r.Context(), currentUserID, filter, page)
+	if err != nil {
+		serverError(w, r, "Error fetching bookmarks", err)
+		return
+	}
+	//A saved comment may have been deleted since, it is kept in the list but redacted like everywhere else
+	for i, b := range bookmarks.Items {
+		if b.Comment != nil {
+			redacted := redactDeleted(*b.Comment)
+			bookmarks.Items[i].Comment = &redacted
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bookmarks)
+}
+
+// Returns all the active sessions of the current user, the session making the request is flagged as current
+func (m *UserHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	currentSessionID, _ := getSessionIDFromContext(r.Context())
+	sessions, err := m.Sessions.AllByUserID(r.Context(), currentUserID)
+	if err != nil {
+		serverError(w, r, "Error fetching sessions", err)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// Revokes one of the current user's sessions, e.g. to log out a lost device
+func (m *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID := vars["session_id"]
+	if sessionID == "" {
+		http.Error(w, "Missing session_id parameter", http.StatusBadRequest)
+		return
+	}
+	currentUserID, ok := getUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	//The user ID is part of the query, so users can only ever revoke their own sessions
+	revoked, err := m.Sessions.Revoke(r.Context(), sessionID, currentUserID)
+	if err != nil {
+		serverError(w, r, "Error revoking session", err)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,10 +2,105 @@ package handlers
 
 import (
 	"backend/middleware"
+	"backend/models"
+	"backend/notifications"
+	"backend/policy"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
 )
 
 func getUserIDFromContext(ctx context.Context) (int64, bool) { // Retrieves the user ID from the context
 	userID, ok := ctx.Value(middleware.UserIDKey).(int64)
 	return userID, ok
 }
+
+// Returns the user making the request together with their role, used for the policy checks
+func getActorFromContext(ctx context.Context) (policy.Actor, bool) {
+	userID, ok := getUserIDFromContext(ctx)
+	if !ok {
+		return policy.Actor{}, false
+	}
+	role, _ := ctx.Value(middleware.RoleKey).(string)
+	return policy.Actor{UserID: userID, Role: role}, true
+}
+
+func getSessionIDFromContext(ctx context.Context) (string, bool) { // Retrieves the session ID (jti) from the context
+	sessionID, ok := ctx.Value(middleware.SessionIDKey).(string)
+	return sessionID, ok && sessionID != ""
+}
+
+// Reads an optional positive integer query parameter, returns def if it is missing and caps it at max
+func parseIntQuery(r *http.Request, name string, def, max int) (int, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("Invalid %s parameter", name)
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	defaultAutocompleteLimit = 10
+	maxAutocompleteLimit     = 25
+)
+
+// Reads the limit and cursor query parameters of a list endpoint, the limit is capped at maxPageSize
+func parsePageRequest(r *http.Request) (models.PageRequest, error) {
+	limit, err := parseIntQuery(r, "limit", defaultPageSize, maxPageSize)
+	if err != nil {
+		return models.PageRequest{}, err
+	}
+	cursor, err := models.DecodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		return models.PageRequest{}, fmt.Errorf("Invalid cursor parameter")
+	}
+	return models.PageRequest{Limit: limit, Cursor: cursor}, nil
+}
+
+// Status of the responses to clients that went away before the response was ready, the code nginx logs for them
+const StatusClientClosedRequest = 499
+
+// Answers a request that failed on the server with a 500 carrying msg and the error. The queries of a request are
+// cancelled together with its context, so a client that disconnected gets 499 (nobody reads it, but it keeps them apart
+// from real failures) and a request that ran past its route's query deadline gets 503 so clients know to try again.
+func serverError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		http.Error(w, "The request took too long, please try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled) || errors.Is(r.Context().Err(), context.Canceled):
+		http.Error(w, "Request cancelled", StatusClientClosedRequest)
+	default:
+		http.Error(w, fmt.Sprintf("%s: %v", msg, err), http.StatusInternalServerError)
+	}
+}
+
+// Tells the owner of some content that a moderator acted on it, nothing is sent when owners act on their own content.
+// The action itself already happened, so a failure here is only logged instead of failing the request,
+// and the notification is sent even if the client has gone away in the meantime.
+func notifyModeration(ctx context.Context, store models.NotificationStore, actor policy.Actor, ownerID int64, postID, commentID sql.NullInt64, message string) {
+	err := store.Create(context.WithoutCancel(ctx), notifications.Notification{
+		UserID:    ownerID,
+		Kind:      notifications.KindModeration,
+		ActorID:   sql.NullInt64{Int64: actor.UserID, Valid: true},
+		PostID:    postID,
+		CommentID: commentID,
+		Message:   message,
+	})
+	if err != nil {
+		log.Printf("Error sending moderation notification to user %d: %v", ownerID, err)
+	}
+}
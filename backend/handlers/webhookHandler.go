@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/policy"
+	"backend/webhooks"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const maxWebhookURLLength = 2048
+
+type WebhookHandler struct {
+	models.Stores
+}
+
+// A delivery together with its attempts, the delivery log of one event
+type WebhookDeliveryResponse struct {
+	*models.WebhookDelivery
+	AttemptLog []models.WebhookAttempt `json:"attempt_log"`
+}
+
+// Loads the webhook in the URL and checks the actor may manage it, writing the error response if not
+func (m *WebhookHandler) loadWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	actor, ok := getActorFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return nil, false
+	}
+	webhookID, err := strconv.ParseInt(mux.Vars(r)["webhook_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return nil, false
+	}
+	webhook, err := m.Webhooks.GetByID(r.Context(), webhookID)
+	if err != nil {
+		serverError(w, r, "Error fetching webhook", err)
+		return nil, false
+	}
+	if webhook == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanManageWebhook(r.Context(), actor, webhook)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return nil, false
+	}
+	if !allowed {
+		http.Error(w, "Forbidden: Only admins and the topic owner can manage this webhook", http.StatusForbidden)
+		return nil, false
+	}
+	//The secret is only shown once, when the webhook is created
+	webhook.Secret = ""
+	return webhook, true
+}
+
+// Creates a webhook for a topic (topic owners and admins) or for the whole forum without topic_id (admins only).
+// The response contains the signing secret, it can't be read again later.
+func (m *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	actor, ok := getActorFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	var reqBody struct {
+		URL     string   `json:"url"`
+		Events  []string `json:"events"`
+		TopicID *int64   `json:"topic_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(reqBody.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(reqBody.URL) > maxWebhookURLLength {
+		http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	if len(reqBody.Events) == 0 {
+		http.Error(w, fmt.Sprintf("events is required, pick from %v", webhooks.Events), http.StatusBadRequest)
+		return
+	}
+	var events []string
+	seen := map[string]bool{}
+	for _, e := range reqBody.Events {
+		if !webhooks.ValidEvent(e) {
+			http.Error(w, fmt.Sprintf("Unknown event %q, pick from %v", e, webhooks.Events), http.StatusBadRequest)
+			return
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	webhook := models.Webhook{URL: reqBody.URL, Events: events, CreatedBy: actor.UserID}
+	if reqBody.TopicID != nil {
+		topic, err := m.Topics.GetByID(r.Context(), *reqBody.TopicID, 0)
+		if err != nil {
+			serverError(w, r, "Error fetching topic", err)
+			return
+		}
+		if topic == nil {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+		webhook.TopicID = sql.NullInt64{Int64: topic.ID, Valid: true}
+	}
+	Policy := policy.Policy{Stores: m.Stores}
+	allowed, err := Policy.CanManageWebhook(r.Context(), actor, &webhook)
+	if err != nil {
+		serverError(w, r, "Error checking permissions", err)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden: Only admins and the topic owner can add webhooks", http.StatusForbidden)
+		return
+	}
+	webhook.Secret = webhooks.NewSecret()
+	webhookID, err := m.Webhooks.Create(r.Context(), webhook.URL, webhook.Secret, webhook.Events, webhook.TopicID, webhook.CreatedBy)
+	if err != nil {
+		serverError(w, r, "Error creating webhook", err)
+		return
+	}
+	created, err := m.Webhooks.GetByID(r.Context(), webhookID)
+	if err != nil || created == nil {
+		serverError(w, r, "Error fetching webhook", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// Lists every webhook for admins, and the webhooks of the user's own topics for everyone else
+func (m *WebhookHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	actor, ok := getActorFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Auth error. Please ensure you are logged in.", http.StatusBadRequest)
+		return
+	}
+	page, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ownerID := actor.UserID
+	if actor.IsAdmin() {
+		ownerID = 0
+	}
+	list, err := m.Webhooks.All(r.Context(), ownerID, page)
+	if err != nil {
+		serverError(w, r, "Error fetching webhooks", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (m *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := m.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// Deletes a webhook along with its queued deliveries and their log
+func (m *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := m.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := m.Webhooks.Delete(r.Context(), webhook.ID); err != nil {
+		serverError(w, r, "Error deleting webhook", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The delivery log of a webhook, newest first
+func (m *WebhookHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := m.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	page, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deliveries, err := m.Webhooks.Deliveries(r.Context(), webhook.ID, page)
+	if err != nil {
+		serverError(w, r, "Error fetching deliveries", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// Loads the delivery in the URL, which has to belong to webhook
+func (m *WebhookHandler) loadDelivery(w http.ResponseWriter, r *http.Request, webhook *models.Webhook) (*models.WebhookDelivery, bool) {
+	deliveryID, err := strconv.ParseInt(mux.Vars(r)["delivery_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return nil, false
+	}
+	delivery, err := m.Webhooks.GetDelivery(r.Context(), webhook.ID, deliveryID)
+	if err != nil {
+		serverError(w, r, "Error fetching delivery", err)
+		return nil, false
+	}
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return nil, false
+	}
+	return delivery, true
+}
+
+// Returns a delivery with every attempt made for it
+func (m *WebhookHandler) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := m.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	delivery, ok := m.loadDelivery(w, r, webhook)
+	if !ok {
+		return
+	}
+	attempts, err := m.Webhooks.Attempts(r.Context(), delivery.ID)
+	if err != nil {
+		serverError(w, r, "Error fetching attempts", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebhookDeliveryResponse{WebhookDelivery: delivery, AttemptLog: attempts})
+}
+
+// Queues a dead delivery again, e.g. after the receiver was fixed
+func (m *WebhookHandler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := m.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	delivery, ok := m.loadDelivery(w, r, webhook)
+	if !ok {
+		return
+	}
+	retried, err := m.Webhooks.Retry(r.Context(), delivery.ID)
+	if err != nil {
+		serverError(w, r, "Error retrying delivery", err)
+		return
+	}
+	if !retried {
+		http.Error(w, "Only dead deliveries can be retried", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,109 @@
+// Package markdown renders the restricted Markdown dialect used by posts and comments into sanitized HTML.
+//
+// The dialect supports paragraphs, emphasis, strikethrough, inline code, fenced and indented code blocks,
+// links (including bare URLs), lists, block quotes, thematic breaks and ||spoilers||.
+// Raw HTML and headings are not supported and are shown as plain text, images are turned into links.
+// Whatever the parser produces is then run through an allowlist, so the output is always safe to embed.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Added to every link, user content should not pass on ranking to the sites it links to
+const linkRel = "nofollow ugc"
+
+var md = goldmark.New(
+	goldmark.WithParser(parser.NewParser(
+		//The CommonMark block parsers minus headings and raw HTML blocks
+		parser.WithBlockParsers(
+			util.Prioritized(parser.NewThematicBreakParser(), 200),
+			util.Prioritized(parser.NewListParser(), 300),
+			util.Prioritized(parser.NewListItemParser(), 400),
+			util.Prioritized(parser.NewCodeBlockParser(), 500),
+			util.Prioritized(parser.NewFencedCodeBlockParser(), 700),
+			util.Prioritized(parser.NewBlockquoteParser(), 800),
+			util.Prioritized(parser.NewParagraphParser(), 1000),
+		),
+		//The CommonMark inline parsers minus raw HTML
+		parser.WithInlineParsers(
+			util.Prioritized(parser.NewCodeSpanParser(), 100),
+			util.Prioritized(parser.NewLinkParser(), 200),
+			util.Prioritized(parser.NewAutoLinkParser(), 300),
+			util.Prioritized(parser.NewEmphasisParser(), 500),
+		),
+		parser.WithParagraphTransformers(parser.DefaultParagraphTransformers()...),
+		parser.WithASTTransformers(util.Prioritized(linkTransformer{}, 100)),
+	)),
+	goldmark.WithExtensions(extension.Strikethrough, extension.Linkify, spoilerExtension{}),
+)
+
+var policy = newPolicy()
+
+// The allowlist of everything the renderer is expected to produce, anything else is dropped
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "strong", "em", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^spoiler$`)).OnElements("span")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("title").OnElements("a")
+	p.AllowAttrs("rel").Matching(regexp.MustCompile(`^` + linkRel + `$`)).OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.AllowRelativeURLs(true)
+	return p
+}
+
+// Render converts Markdown source into sanitized HTML
+func Render(source string) string {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		//Only happens if writing to the buffer fails, fall back to the escaped source
+		return "<p>" + html.EscapeString(source) + "</p>"
+	}
+	return policy.Sanitize(buf.String())
+}
+
+// Adds rel="nofollow ugc" to every link and turns images into links to the image
+type linkTransformer struct{}
+
+func (linkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var images []*ast.Image
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link, *ast.AutoLink:
+			n.SetAttributeString("rel", []byte(linkRel))
+		case *ast.Image:
+			images = append(images, n)
+		}
+		return ast.WalkContinue, nil
+	})
+	//Replaced after the walk, changing the tree while walking it would skip nodes
+	for _, img := range images {
+		link := ast.NewLink()
+		link.Destination = img.Destination
+		link.Title = img.Title
+		link.SetAttributeString("rel", []byte(linkRel))
+		for c := img.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			link.AppendChild(link, c)
+			c = next
+		}
+		img.Parent().ReplaceChild(img.Parent(), img, link)
+	}
+}
@@ -0,0 +1,90 @@
+package markdown
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		//Links only keep http, https, mailto and relative URLs
+		{"link", "[x](https://example.com)", `<p><a href="https://example.com" rel="nofollow ugc">x</a></p>` + "\n"},
+		{"relative link", "[x](/posts/1)", `<p><a href="/posts/1" rel="nofollow ugc">x</a></p>` + "\n"},
+		{"mailto link", "[x](mailto:a@b.c)", `<p><a href="mailto:a@b.c" rel="nofollow ugc">x</a></p>` + "\n"},
+		{"javascript link", "[x](javascript:alert(1))", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"mixed case javascript link", "[x](JaVaScRiPt:alert(1))", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"javascript autolink", "<javascript:alert(1)>", `<p><a rel="nofollow ugc">javascript:alert(1)</a></p>` + "\n"},
+		{"vbscript link", "[x](vbscript:msgbox)", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"data image", "![x](data:image/png;base64,AAAA)", `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+
+		//Raw HTML is shown as text
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"inline script", "hi <script>alert(1)</script> there", "<p>hi &lt;script&gt;alert(1)&lt;/script&gt; there</p>\n"},
+		{"iframe", `<iframe src="https://evil.example"></iframe>`, "<p>&lt;iframe src=&#34;https://evil.example&#34;&gt;&lt;/iframe&gt;</p>\n"},
+		{"img with handler", "<img src=x onerror=alert(1)>", "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n"},
+		{"div", "<div>x</div>", "<p>&lt;div&gt;x&lt;/div&gt;</p>\n"},
+		{"heading", "# heading", "<p># heading</p>\n"},
+
+		//Quotes can't break out of an attribute
+		{"quote in title", `[x](https://example.com "t\" onmouseover=\"alert(1)")`,
+			`<p><a href="https://example.com" title="t&#34; onmouseover=&#34;alert(1)" rel="nofollow ugc">x</a></p>` + "\n"},
+		{"quote in destination", `[x](<https://example.com" onclick="alert(1)>)`, `<p><a rel="nofollow ugc">x</a></p>` + "\n"},
+		{"quote after bare URL", `[x](https://example.com" onclick="alert(1))`,
+			`<p>[x](<a href="https://example.com" rel="nofollow ugc">https://example.com</a>&#34; onclick=&#34;alert(1))</p>` + "\n"},
+		{"quote in code language", "```js\" onclick=\"alert(1)\ncode\n```", "<pre><code>code\n</code></pre>\n"},
+		{"code language", "```js\ncode\n```", `<pre><code class="language-js">code` + "\n</code></pre>\n"},
+
+		//Every link gets rel="nofollow ugc", bare URLs included
+		{"bare URL", "https://example.com", `<p><a href="https://example.com" rel="nofollow ugc">https://example.com</a></p>` + "\n"},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com" rel="nofollow ugc">https://example.com</a></p>` + "\n"},
+
+		//Images become links to the image
+		{"image", `![cat](https://example.com/cat.png "A cat")`,
+			`<p><a href="https://example.com/cat.png" title="A cat" rel="nofollow ugc">cat</a></p>` + "\n"},
+
+		{"spoiler", "||secret||", `<p><span class="spoiler">secret</span></p>` + "\n"},
+		{"nested spoilers", "||outer ||inner|| still||",
+			`<p><span class="spoiler">outer <span class="spoiler">inner</span> still</span></p>` + "\n"},
+		{"spoiler in emphasis in spoiler", "||outer **bold ||inner||**||",
+			`<p><span class="spoiler">outer <strong>bold <span class="spoiler">inner</span></strong></span></p>` + "\n"},
+		{"spoiler closing before emphasis", "||a *b||*", `<p><span class="spoiler">a *b</span>*</p>` + "\n"},
+		{"single and triple pipes", "|single| and |||three|||", "<p>|single| and |||three|||</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.source); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+// The allowlist on its own, in case the parser ever lets something through
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"script and iframe", `<p><script>alert(1)</script><iframe src="https://evil.example"></iframe>ok</p>`, "<p>ok</p>"},
+		{"image", `<img src="https://example.com/a.png">`, ""},
+		{"heading", "<h1>x</h1>", "x"},
+		{"link handlers and scheme", `<a href="javascript:alert(1)" onclick="alert(1)" rel="nofollow ugc">x</a>`, `<a rel="nofollow ugc">x</a>`},
+		{"other rel and target", `<a href="https://example.com" rel="noopener" target="_blank">x</a>`, `<a href="https://example.com">x</a>`},
+		{"spoiler handler", `<span class="spoiler" onmouseover="alert(1)">x</span>`, `<span class="spoiler">x</span>`},
+		{"other span class", `<span class="spoiler evil">x</span>`, "<span>x</span>"},
+		{"code style", `<code class="language-js" style="color:red">x</code>`, `<code class="language-js">x</code>`},
+		{"other code class", `<code class="language-js x">x</code>`, "<code>x</code>"},
+		{"list start", `<ol start="3" onclick="x"><li>a</li></ol>`, `<ol start="3"><li>a</li></ol>`},
+		{"invalid list start", `<ol start="x"><li>a</li></ol>`, "<ol><li>a</li></ol>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Sanitize(tt.html); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
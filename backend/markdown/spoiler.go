@@ -0,0 +1,86 @@
+package markdown
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Spoiler is an inline ||spoiler||, rendered as <span class="spoiler"> for the clients to hide
+type Spoiler struct {
+	ast.BaseInline
+}
+
+var KindSpoiler = ast.NewNodeKind("Spoiler")
+
+func (n *Spoiler) Kind() ast.NodeKind {
+	return KindSpoiler
+}
+
+func (n *Spoiler) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Spoilers are delimited like strikethrough, just with two pipes instead of two tildes
+type spoilerDelimiterProcessor struct{}
+
+func (p *spoilerDelimiterProcessor) IsDelimiter(b byte) bool {
+	return b == '|'
+}
+
+func (p *spoilerDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+func (p *spoilerDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	return &Spoiler{}
+}
+
+var spoilerDelimiter = &spoilerDelimiterProcessor{}
+
+type spoilerParser struct{}
+
+func (s *spoilerParser) Trigger() []byte {
+	return []byte{'|'}
+}
+
+func (s *spoilerParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, 2, spoilerDelimiter)
+	//Exactly two pipes, a single one is just text
+	if node == nil || node.OriginalLength != 2 || before == '|' {
+		return nil
+	}
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+func (s *spoilerParser) CloseBlock(parent ast.Node, pc parser.Context) {}
+
+type spoilerRenderer struct{}
+
+func (r *spoilerRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindSpoiler, r.render)
+}
+
+func (r *spoilerRenderer) render(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		w.WriteString(`<span class="spoiler">`)
+	} else {
+		w.WriteString("</span>")
+	}
+	return ast.WalkContinue, nil
+}
+
+type spoilerExtension struct{}
+
+func (spoilerExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(&spoilerParser{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&spoilerRenderer{}, 500)))
+}
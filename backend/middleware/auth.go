@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"backend/models"
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -9,18 +11,28 @@ import (
 )
 
 type AuthMiddleware struct { // The AuthMiddleware "class" takes in the jwt key as it needs to verify authentication tokens
-	JWTKey []byte
+	JWTKey   []byte
+	Sessions models.SessionStore // Used to check that the session in the token has not been revoked
 }
 
+var ErrSessionRevoked = errors.New("session has been revoked")
+
 type Claims struct {
-	UserID               int64 `json:"user_id"`
-	jwt.RegisteredClaims       // Standard JWT fields like Expiry
+	UserID               int64  `json:"user_id"`
+	Role                 string `json:"role"` // Global role of the user when the token was issued
+	jwt.RegisteredClaims        // Standard JWT fields like Expiry
 }
 
 type contextKey string
 
 const UserIDKey contextKey = "UserID"
 
+// Holds the session ID (the jti claim of the token) of the request
+const SessionIDKey contextKey = "SessionID"
+
+// Holds the global role of the user making the request
+const RoleKey contextKey = "Role"
+
 func (m *AuthMiddleware) parseUserClaims(r *http.Request) (*Claims, error) { //Takes in a cookie and returns the claims inside it
 	cookie, err := r.Cookie("token")
 	var tokenString string
@@ -45,6 +57,18 @@ func (m *AuthMiddleware) parseUserClaims(r *http.Request) (*Claims, error) { //T
 	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 		return nil, jwt.NoneSignatureTypeDisallowedError
 	}
+	//Checks the session store so that logged out or revoked tokens are rejected even if they haven't expired yet.
+	//Tokens without a session ID were issued before sessions existed and are no longer accepted.
+	if claims.ID == "" {
+		return nil, ErrSessionRevoked
+	}
+	active, err := m.Sessions.IsActive(r.Context(), claims.ID, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrSessionRevoked
+	}
 	return claims, nil
 }
 
@@ -57,6 +81,8 @@ func (m *AuthMiddleware) ValidateToken(next http.Handler) http.Handler { //valid
 		}
 		userID := claims.UserID
 		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		ctx = context.WithValue(ctx, SessionIDKey, claims.ID)
+		ctx = context.WithValue(ctx, RoleKey, claims.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -66,10 +92,31 @@ func (m *AuthMiddleware) OptionalAuthMiddleware(next http.Handler) http.Handler
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, err := m.parseUserClaims(r)
 		var userID int64 = -1
+		var sessionID, role string
 		if err == nil {
 			userID = claims.UserID
+			sessionID = claims.ID
+			role = claims.Role
 		}
 		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		ctx = context.WithValue(ctx, SessionIDKey, sessionID)
+		ctx = context.WithValue(ctx, RoleKey, role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Only lets requests through if the user has one of the given roles, must be used after ValidateToken
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value(RoleKey).(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
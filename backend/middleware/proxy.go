@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// The reverse proxies in front of the backend. X-Forwarded-For is only believed when the request comes from one of them,
+// anyone else could send the header to pretend to be another address.
+type TrustedProxies []netip.Prefix
+
+// Reads a comma separated list of IP addresses and CIDR ranges, e.g. 10.0.0.0/8,127.0.0.1. An empty list trusts nobody.
+func ParseTrustedProxies(list string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP address or CIDR range", entry)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the IP address of the client. Behind trusted proxies that is the last address in X-Forwarded-For
+// that isn't one of the proxies, the ones before it were sent by the client and can be anything.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !p.trusts(remote) {
+		return host
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			//Garbage from before the first trusted proxy, the hop after it is the furthest one we know
+			break
+		}
+		if !p.trusts(addr) {
+			return addr.Unmap().String()
+		}
+		host = addr.Unmap().String()
+	}
+	return host
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/8,proxy.local"); err == nil {
+		t.Error("parsed a host name as a trusted proxy")
+	}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"spoofed header from a client", "203.0.113.7:1234", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"through a proxy", "10.1.2.3:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed header through a proxy", "10.1.2.3:1234", []string{"1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"through two proxies", "10.1.2.3:1234", []string{"203.0.113.7, 192.168.1.1"}, "203.0.113.7"},
+		{"header sent twice", "10.1.2.3:1234", []string{"1.2.3.4", "203.0.113.7"}, "203.0.113.7"},
+		{"proxy without header", "10.1.2.3:1234", nil, "10.1.2.3"},
+		{"only proxies", "10.1.2.3:1234", []string{"10.9.9.9"}, "10.9.9.9"},
+		{"garbage before a proxy", "10.1.2.3:1234", []string{"nonsense, 10.9.9.9"}, "10.9.9.9"},
+		{"IPv6 proxy", "[::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"IPv4 mapped proxy", "[::ffff:10.1.2.3]:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP from %s with %q = %q, want %q", tt.remote, tt.forwarded, got, tt.want)
+			}
+			//Without trusted proxies the header is never read
+			host, _, _ := net.SplitHostPort(tt.remote)
+			if got := TrustedProxies(nil).ClientIP(r); got != host {
+				t.Errorf("ClientIP without trusted proxies = %q, want %q", got, host)
+			}
+		})
+	}
+}
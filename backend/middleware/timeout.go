@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Used when QUERY_TIMEOUT is not set
+const DefaultQueryTimeout = 10 * time.Second
+
+// Deadlines for the database queries of a request. The handlers run their queries with the request context,
+// so once the deadline of the route passes the queries still running are cancelled.
+type QueryTimeouts struct {
+	Default time.Duration            // Deadline of the routes that have no entry in Routes, 0 means no deadline
+	Routes  map[string]time.Duration // Keyed by the path template of the route, e.g. /api/posts/{post_id}
+}
+
+// Reads the default deadline (a duration like 5s) and the per route deadlines, a comma separated list of
+// path=duration pairs such as /api/search=2s,/api/feed=3s. An empty default keeps DefaultQueryTimeout.
+func ParseQueryTimeouts(def, routes string) (QueryTimeouts, error) {
+	t := QueryTimeouts{Default: DefaultQueryTimeout, Routes: map[string]time.Duration{}}
+	if def != "" {
+		d, err := time.ParseDuration(def)
+		if err != nil || d < 0 {
+			return QueryTimeouts{}, fmt.Errorf("invalid query timeout %q", def)
+		}
+		t.Default = d
+	}
+	for _, entry := range strings.Split(routes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, value, ok := strings.Cut(entry, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || !strings.HasPrefix(path, "/") || err != nil || d < 0 {
+			return QueryTimeouts{}, fmt.Errorf("invalid route query timeout %q, expected /path=duration", entry)
+		}
+		t.Routes[strings.TrimSpace(path)] = d
+	}
+	return t, nil
+}
+
+// Returns the deadline of the route with the path template, the default if it has none of its own
+func (t QueryTimeouts) For(path string) time.Duration {
+	if d, ok := t.Routes[path]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// Puts the deadline of the matched route on the request context
+func (t QueryTimeouts) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := t.Default
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err := route.GetPathTemplate(); err == nil {
+				timeout = t.For(path)
+			}
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,315 @@
+// Package migrations evolves the database schema with numbered up and down SQL files, embedded into the binary.
+// Every database has its own directory (mysql, postgres, sqlite) of numbered migrations:
+//
+//	0001_initial.up.sql
+//	0001_initial.down.sql
+//
+// The directories don't need to have the same versions, but new migrations are numbered after the newest one
+// in any of them so a version means the same change everywhere.
+//
+// The applied versions are kept in the schema_migrations table. Up and Down hold a lock in the database while they run,
+// so replicas that start at the same time don't apply a migration twice.
+package migrations
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+var (
+	fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	namePattern     = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// A migration and when it was applied, AppliedAt is nil while it is pending
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Returns the migrations of the dialect ordered by version
+func Load(dialect database.Dialect) ([]Migration, error) {
+	return load(files, string(dialect))
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		match := fileNamePattern.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, dir+"/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return int(a.Version - b.Version) })
+	return migrations, nil
+}
+
+// Applies every pending migration in order and returns the ones it applied
+func Up(db *sql.DB) ([]Migration, error) {
+	var applied []Migration
+	err := locked(db, func(ctx context.Context, conn *sql.Conn) error {
+		migrations, done, err := state(ctx, database.DialectOf(db), conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			if err := run(ctx, conn, m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UTC()); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Rolls back the last steps applied migrations, newest first, and returns the ones it rolled back
+func Down(db *sql.DB, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := locked(db, func(ctx context.Context, conn *sql.Conn) error {
+		migrations, done, err := state(ctx, database.DialectOf(db), conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(done))
+		for v := range done {
+			versions = append(versions, v)
+		}
+		slices.Sort(versions)
+		slices.Reverse(versions)
+		for _, v := range versions[:min(steps, len(versions))] {
+			i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == v })
+			if i < 0 {
+				return fmt.Errorf("migration %d is applied but its files are missing", v)
+			}
+			m := migrations[i]
+			if err := run(ctx, conn, m.Down, "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Lists every migration with when it was applied
+func List(db *sql.DB) ([]Status, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	migrations, done, err := state(ctx, database.DialectOf(db), conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(migrations))
+	for i, m := range migrations {
+		statuses[i].Migration = m
+		if appliedAt, ok := done[m.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Writes empty up and down files for a new migration into every dialect directory under dir,
+// numbered after the newest migration there. Returns the paths of the new files.
+func Create(dir, name string) ([]string, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid migration name %q, use lowercase letters, digits and underscores", name)
+	}
+	dialects := []database.Dialect{database.MySQL, database.Postgres, database.SQLite}
+	var last int64
+	for _, d := range dialects {
+		migrations, err := load(os.DirFS(dir), string(d))
+		if err != nil {
+			return nil, err
+		}
+		if len(migrations) > 0 {
+			last = max(last, migrations[len(migrations)-1].Version)
+		}
+	}
+	var paths []string
+	for _, d := range dialects {
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(dir, string(d), fmt.Sprintf("%04d_%s.%s.sql", last+1, name, direction))
+			if err := os.WriteFile(path, []byte(fmt.Sprintf("-- %s: %s\n", name, direction)), 0o644); err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// The migrations of the dialect and the versions already applied with when they were
+func state(ctx context.Context, dialect database.Dialect, conn *sql.Conn) ([]Migration, map[int64]time.Time, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return nil, nil, err
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	done := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, nil, err
+		}
+		done[version] = appliedAt
+	}
+	return migrations, done, rows.Err()
+}
+
+// Runs the statements of a migration and the bookkeeping query in one transaction.
+// MySQL commits every schema change on its own though, so a MySQL migration that fails halfway has to be cleaned up by hand.
+func run(ctx context.Context, conn *sql.Conn, body, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements(body) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Splits a migration into its statements, which end with a ; at the end of a line.
+// The ; inside the BEGIN ... END body of a trigger don't end the statement.
+func statements(body string) []string {
+	var stmts []string
+	var current strings.Builder
+	inTrigger := false
+	flush := func() {
+		if stmt := strings.TrimSuffix(strings.TrimSpace(current.String()), ";"); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		current.Reset()
+	}
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line + "\n")
+		upper := strings.ToUpper(trimmed)
+		switch {
+		case strings.HasSuffix(upper, " BEGIN"):
+			inTrigger = true
+		case inTrigger && upper == "END;":
+			inTrigger = false
+			flush()
+		case !inTrigger && strings.HasSuffix(trimmed, ";"):
+			flush()
+		}
+	}
+	flush()
+	return stmts
+}
+
+// Name of the MySQL lock and key of the Postgres advisory lock held while migrating
+const (
+	lockName = "schema_migrations"
+	lockKey  = 7_301_145_962
+)
+
+// Runs f on a single connection that holds the migration lock. SQLite has no such locks,
+// but it only ever has one connection open (see database.InitSQLite).
+func locked(db *sql.DB, f func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	switch database.DialectOf(db) {
+	case database.MySQL:
+		var got sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 600)", lockName).Scan(&got); err != nil {
+			return err
+		}
+		if got.Int64 != 1 {
+			return fmt.Errorf("timed out waiting for the migration lock")
+		}
+		defer conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", lockName)
+	case database.Postgres:
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", lockKey); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock(?)", lockKey)
+	}
+	return f(ctx, conn)
+}
@@ -1,23 +1,6 @@
--- MySQL Script generated by MySQL Workbench
--- Sun Jan 18 20:06:29 2026
--- Model: New Model    Version: 1.0
--- MySQL Workbench Forward Engineering
-
-SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
-SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
-SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION';
-
--- -----------------------------------------------------
--- Schema mydb
--- -----------------------------------------------------
--- -----------------------------------------------------
--- Schema cvwo_forum
--- -----------------------------------------------------
-
--- -----------------------------------------------------
--- Table `users`
--- -----------------------------------------------------
-DROP TABLE IF EXISTS `users` ;
+-- The schema of the original MySQL Workbench dump (backend_final.sql), exactly as it was. The tables are only
+-- created if they are missing, so databases that were set up from the dump are adopted, and the later migrations
+-- bring them up to date like any other database.
 
 CREATE TABLE IF NOT EXISTS `users` (
   `id` INT NOT NULL AUTO_INCREMENT,
@@ -26,16 +9,9 @@ CREATE TABLE IF NOT EXISTS `users` (
   PRIMARY KEY (`id`),
   UNIQUE INDEX `username_UNIQUE` (`username` ASC) VISIBLE)
 ENGINE = InnoDB
-AUTO_INCREMENT = 3
 DEFAULT CHARACTER SET = utf8mb4
 COLLATE = utf8mb4_0900_ai_ci;
 
-
--- -----------------------------------------------------
--- Table `topics`
--- -----------------------------------------------------
-DROP TABLE IF EXISTS `topics` ;
-
 CREATE TABLE IF NOT EXISTS `topics` (
   `id` INT NOT NULL AUTO_INCREMENT,
   `title` VARCHAR(45) NOT NULL,
@@ -49,16 +25,9 @@ CREATE TABLE IF NOT EXISTS `topics` (
     FOREIGN KEY (`user_id`)
     REFERENCES `users` (`id`))
 ENGINE = InnoDB
-AUTO_INCREMENT = 12
 DEFAULT CHARACTER SET = utf8mb4
 COLLATE = utf8mb4_0900_ai_ci;
 
-
--- -----------------------------------------------------
--- Table `posts`
--- -----------------------------------------------------
-DROP TABLE IF EXISTS `posts` ;
-
 CREATE TABLE IF NOT EXISTS `posts` (
   `id` INT NOT NULL AUTO_INCREMENT,
   `title` VARCHAR(255) NOT NULL,
@@ -81,16 +50,9 @@ CREATE TABLE IF NOT EXISTS `posts` (
     FOREIGN KEY (`user_id`)
     REFERENCES `users` (`id`))
 ENGINE = InnoDB
-AUTO_INCREMENT = 4
 DEFAULT CHARACTER SET = utf8mb4
 COLLATE = utf8mb4_0900_ai_ci;
 
-
--- -----------------------------------------------------
--- Table `comments`
--- -----------------------------------------------------
-DROP TABLE IF EXISTS `comments` ;
-
 CREATE TABLE IF NOT EXISTS `comments` (
   `id` INT NOT NULL AUTO_INCREMENT,
   `content` TEXT NULL DEFAULT NULL,
@@ -119,16 +81,9 @@ CREATE TABLE IF NOT EXISTS `comments` (
     FOREIGN KEY (`user_id`)
     REFERENCES `users` (`id`))
 ENGINE = InnoDB
-AUTO_INCREMENT = 8
 DEFAULT CHARACTER SET = utf8mb4
 COLLATE = utf8mb4_0900_ai_ci;
 
-
--- -----------------------------------------------------
--- Table `comment_likes`
--- -----------------------------------------------------
-DROP TABLE IF EXISTS `comment_likes` ;
-
 CREATE TABLE IF NOT EXISTS `comment_likes` (
   `user_id` INT NOT NULL,
   `comment_id` INT NOT NULL,
@@ -147,12 +102,6 @@ ENGINE = InnoDB
 DEFAULT CHARACTER SET = utf8mb4
 COLLATE = utf8mb4_0900_ai_ci;
 
-
--- -----------------------------------------------------
--- Table `post_likes`
--- -----------------------------------------------------
-DROP TABLE IF EXISTS `post_likes` ;
-
 CREATE TABLE IF NOT EXISTS `post_likes` (
   `user_id` INT NOT NULL,
   `post_id` INT NOT NULL,
@@ -170,8 +119,3 @@ CREATE TABLE IF NOT EXISTS `post_likes` (
 ENGINE = InnoDB
 DEFAULT CHARACTER SET = utf8mb4
 COLLATE = utf8mb4_0900_ai_ci;
-
-
-SET SQL_MODE=@OLD_SQL_MODE;
-SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
-SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
@@ -0,0 +1,4 @@
+-- Accounts from before passwords existed keep a NULL hash until their owner sets one
+
+ALTER TABLE `users`
+  ADD COLUMN `password_hash` VARCHAR(255) NULL DEFAULT NULL AFTER `username`;
@@ -0,0 +1,33 @@
+CREATE TABLE `sessions` (
+  `id` VARCHAR(64) NOT NULL,
+  `user_id` INT NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `last_seen_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `user_agent` VARCHAR(255) NOT NULL DEFAULT '',
+  `ip_address` VARCHAR(45) NOT NULL DEFAULT '',
+  `revoked_at` TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_sessions_users_id_idx` (`user_id` ASC) VISIBLE,
+  CONSTRAINT `fk_sessions_users_id`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE `refresh_tokens` (
+  `token_hash` CHAR(64) NOT NULL,
+  `session_id` VARCHAR(64) NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `expires_at` TIMESTAMP NOT NULL,
+  `used_at` TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY (`token_hash`),
+  INDEX `fk_refreshtokens_session_idx` (`session_id` ASC) VISIBLE,
+  CONSTRAINT `fk_refreshtokens_session`
+    FOREIGN KEY (`session_id`)
+    REFERENCES `sessions` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
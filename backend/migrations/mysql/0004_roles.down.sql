@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `topic_moderators`;
+
+ALTER TABLE `users` DROP COLUMN `role`;
@@ -0,0 +1,20 @@
+ALTER TABLE `users`
+  ADD COLUMN `role` ENUM('user', 'moderator', 'admin') NOT NULL DEFAULT 'user' AFTER `password_hash`;
+
+CREATE TABLE `topic_moderators` (
+  `topic_id` INT NOT NULL,
+  `user_id` INT NOT NULL,
+  `created_at` TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`topic_id`, `user_id`),
+  INDEX `fk_topicmoderators_user_idx` (`user_id` ASC) VISIBLE,
+  CONSTRAINT `fk_topicmoderators_topic`
+    FOREIGN KEY (`topic_id`)
+    REFERENCES `topics` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_topicmoderators_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,6 @@
+ALTER TABLE `posts`
+  DROP INDEX `posts_created_at_idx`,
+  DROP INDEX `posts_likes_idx`,
+  DROP INDEX `posts_hot_score_idx`,
+  DROP COLUMN `hot_score`,
+  DROP COLUMN `comment_count`;
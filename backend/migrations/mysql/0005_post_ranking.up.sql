@@ -0,0 +1,17 @@
+ALTER TABLE `posts`
+  ADD COLUMN `comment_count` INT NOT NULL DEFAULT '0' AFTER `likes`,
+  ADD COLUMN `hot_score` DOUBLE NOT NULL DEFAULT '0' AFTER `comment_count`,
+  ADD INDEX `posts_hot_score_idx` (`hot_score` DESC) VISIBLE,
+  ADD INDEX `posts_likes_idx` (`likes` DESC) VISIBLE,
+  ADD INDEX `posts_created_at_idx` (`created_at` DESC) VISIBLE;
+
+-- Existing posts get their comment count and hot score computed as models.HotScore would,
+-- 1735689600 is its reference point 2025-01-01 UTC. updated_at is set to itself so it isn't bumped.
+UPDATE `posts` p SET
+  p.`comment_count` = (SELECT COUNT(*) FROM `comments` c WHERE c.`post_id` = p.`id`),
+  p.`updated_at` = p.`updated_at`;
+
+UPDATE `posts` SET
+  `hot_score` = LOG10(GREATEST(COALESCE(`likes`, 0) + 2 * `comment_count`, 1))
+    + (COALESCE(UNIX_TIMESTAMP(`created_at`), 1735689600) - 1735689600) / 45000,
+  `updated_at` = `updated_at`;
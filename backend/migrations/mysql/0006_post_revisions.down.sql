@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `post_revisions`;
+
+ALTER TABLE `posts` DROP COLUMN `edit_count`;
@@ -0,0 +1,23 @@
+ALTER TABLE `posts`
+  ADD COLUMN `edit_count` INT NOT NULL DEFAULT '0' AFTER `hot_score`;
+
+CREATE TABLE `post_revisions` (
+  `post_id` INT NOT NULL,
+  `revision` INT NOT NULL,
+  `title` VARCHAR(255) NOT NULL,
+  `content` TEXT NULL DEFAULT NULL,
+  `editor_id` INT NOT NULL,
+  `edited_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`post_id`, `revision`),
+  INDEX `fk_postrevisions_editor_idx` (`editor_id` ASC) VISIBLE,
+  CONSTRAINT `fk_postrevisions_post`
+    FOREIGN KEY (`post_id`)
+    REFERENCES `posts` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_postrevisions_editor`
+    FOREIGN KEY (`editor_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,22 @@
+CREATE TABLE `comment_revisions` (
+  `comment_id` INT NOT NULL,
+  `revision` INT NOT NULL,
+  `action` ENUM('edit', 'delete', 'redact') NOT NULL,
+  `content` TEXT NULL DEFAULT NULL,
+  `redacted` TINYINT NOT NULL DEFAULT '0',
+  `reason` VARCHAR(255) NULL DEFAULT NULL,
+  `editor_id` INT NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`comment_id`, `revision`),
+  INDEX `fk_commentrevisions_editor_idx` (`editor_id` ASC) VISIBLE,
+  CONSTRAINT `fk_commentrevisions_comment`
+    FOREIGN KEY (`comment_id`)
+    REFERENCES `comments` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_commentrevisions_editor`
+    FOREIGN KEY (`editor_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,5 @@
+ALTER TABLE `post_revisions` DROP COLUMN `content_html`;
+
+ALTER TABLE `comments` DROP COLUMN `content_html`;
+
+ALTER TABLE `posts` DROP COLUMN `content_html`;
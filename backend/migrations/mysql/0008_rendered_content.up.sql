@@ -0,0 +1,11 @@
+-- The rendered Markdown is stored next to the source. It stays NULL for the rows written before this migration,
+-- those are rendered when they are read and stored again on their next edit.
+
+ALTER TABLE `posts`
+  ADD COLUMN `content_html` MEDIUMTEXT NULL DEFAULT NULL AFTER `content`;
+
+ALTER TABLE `comments`
+  ADD COLUMN `content_html` MEDIUMTEXT NULL DEFAULT NULL AFTER `content`;
+
+ALTER TABLE `post_revisions`
+  ADD COLUMN `content_html` MEDIUMTEXT NULL DEFAULT NULL AFTER `content`;
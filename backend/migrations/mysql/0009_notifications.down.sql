@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `mentions`;
+DROP TABLE IF EXISTS `notifications`;
@@ -0,0 +1,67 @@
+CREATE TABLE `notifications` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `user_id` INT NOT NULL,
+  `kind` VARCHAR(32) NOT NULL,
+  `actor_id` INT NULL DEFAULT NULL,
+  `post_id` INT NULL DEFAULT NULL,
+  `comment_id` INT NULL DEFAULT NULL,
+  `message` VARCHAR(512) NULL DEFAULT NULL,
+  `read_at` TIMESTAMP NULL DEFAULT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  INDEX `notifications_user_idx` (`user_id` ASC, `id` DESC) VISIBLE,
+  INDEX `notifications_user_unread_idx` (`user_id` ASC, `read_at` ASC) VISIBLE,
+  INDEX `fk_notifications_actor_idx` (`actor_id` ASC) VISIBLE,
+  INDEX `fk_notifications_post_idx` (`post_id` ASC) VISIBLE,
+  INDEX `fk_notifications_comment_idx` (`comment_id` ASC) VISIBLE,
+  CONSTRAINT `fk_notifications_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_notifications_actor`
+    FOREIGN KEY (`actor_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE SET NULL,
+  CONSTRAINT `fk_notifications_post`
+    FOREIGN KEY (`post_id`)
+    REFERENCES `posts` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_notifications_comment`
+    FOREIGN KEY (`comment_id`)
+    REFERENCES `comments` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE `mentions` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `user_id` INT NOT NULL,
+  `author_id` INT NOT NULL,
+  `post_id` INT NOT NULL,
+  `comment_id` INT NULL DEFAULT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  INDEX `fk_mentions_user_idx` (`user_id` ASC) VISIBLE,
+  INDEX `fk_mentions_author_idx` (`author_id` ASC) VISIBLE,
+  INDEX `fk_mentions_post_idx` (`post_id` ASC) VISIBLE,
+  INDEX `fk_mentions_comment_idx` (`comment_id` ASC) VISIBLE,
+  CONSTRAINT `fk_mentions_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_mentions_author`
+    FOREIGN KEY (`author_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_mentions_post`
+    FOREIGN KEY (`post_id`)
+    REFERENCES `posts` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_mentions_comment`
+    FOREIGN KEY (`comment_id`)
+    REFERENCES `comments` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
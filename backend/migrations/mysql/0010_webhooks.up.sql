@@ -0,0 +1,62 @@
+CREATE TABLE `webhooks` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `url` VARCHAR(2048) NOT NULL,
+  `secret` VARCHAR(64) NOT NULL,
+  `events` VARCHAR(255) NOT NULL,
+  `topic_id` INT NULL DEFAULT NULL,
+  `created_by` INT NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  INDEX `fk_webhooks_topic_idx` (`topic_id` ASC) VISIBLE,
+  INDEX `fk_webhooks_user_idx` (`created_by` ASC) VISIBLE,
+  CONSTRAINT `fk_webhooks_topic`
+    FOREIGN KEY (`topic_id`)
+    REFERENCES `topics` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_webhooks_user`
+    FOREIGN KEY (`created_by`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE `webhook_deliveries` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `webhook_id` INT NOT NULL,
+  `event` VARCHAR(32) NOT NULL,
+  `payload` MEDIUMTEXT NOT NULL,
+  `status` ENUM('pending', 'delivered', 'dead') NOT NULL DEFAULT 'pending',
+  `attempts` INT NOT NULL DEFAULT 0,
+  `next_attempt_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `locked_until` TIMESTAMP NULL DEFAULT NULL,
+  `last_status_code` INT NULL DEFAULT NULL,
+  `last_error` VARCHAR(512) NULL DEFAULT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `delivered_at` TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `webhook_deliveries_due_idx` (`status` ASC, `next_attempt_at` ASC) VISIBLE,
+  INDEX `webhook_deliveries_webhook_idx` (`webhook_id` ASC, `created_at` DESC, `id` DESC) VISIBLE,
+  CONSTRAINT `fk_webhook_deliveries_webhook`
+    FOREIGN KEY (`webhook_id`)
+    REFERENCES `webhooks` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE `webhook_delivery_attempts` (
+  `delivery_id` INT NOT NULL,
+  `attempt` INT NOT NULL,
+  `status_code` INT NULL DEFAULT NULL,
+  `error` VARCHAR(512) NULL DEFAULT NULL,
+  `duration_ms` INT NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`delivery_id`, `attempt`),
+  CONSTRAINT `fk_webhook_delivery_attempts_delivery`
+    FOREIGN KEY (`delivery_id`)
+    REFERENCES `webhook_deliveries` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `topic_subscriptions`;
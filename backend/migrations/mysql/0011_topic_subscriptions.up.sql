@@ -0,0 +1,17 @@
+CREATE TABLE `topic_subscriptions` (
+  `topic_id` INT NOT NULL,
+  `user_id` INT NOT NULL,
+  `created_at` TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`topic_id`, `user_id`),
+  INDEX `fk_topicsubscriptions_user_idx` (`user_id` ASC) VISIBLE,
+  CONSTRAINT `fk_topicsubscriptions_topic`
+    FOREIGN KEY (`topic_id`)
+    REFERENCES `topics` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_topicsubscriptions_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
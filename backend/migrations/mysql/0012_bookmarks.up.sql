@@ -0,0 +1,27 @@
+CREATE TABLE `bookmarks` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `user_id` INT NOT NULL,
+  `post_id` INT NOT NULL,
+  `comment_id` INT NULL DEFAULT NULL,
+  `comment_key` INT AS (IFNULL(`comment_id`, 0)) STORED,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `bookmarks_item_UNIQUE` (`user_id` ASC, `post_id` ASC, `comment_key` ASC) VISIBLE,
+  INDEX `bookmarks_user_idx` (`user_id` ASC, `created_at` DESC, `id` DESC) VISIBLE,
+  INDEX `fk_bookmarks_post_idx` (`post_id` ASC) VISIBLE,
+  INDEX `fk_bookmarks_comment_idx` (`comment_id` ASC) VISIBLE,
+  CONSTRAINT `fk_bookmarks_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_bookmarks_post`
+    FOREIGN KEY (`post_id`)
+    REFERENCES `posts` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_bookmarks_comment`
+    FOREIGN KEY (`comment_id`)
+    REFERENCES `comments` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,37 @@
+CREATE TABLE `post_reactions` (
+  `post_id` INT NOT NULL,
+  `user_id` INT NOT NULL,
+  `kind` VARCHAR(32) NOT NULL,
+  `created_at` TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`post_id`, `user_id`, `kind`),
+  INDEX `fk_postreactions_user_idx` (`user_id` ASC) VISIBLE,
+  CONSTRAINT `fk_postreactions_post`
+    FOREIGN KEY (`post_id`)
+    REFERENCES `posts` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_postreactions_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TABLE `comment_reactions` (
+  `comment_id` INT NOT NULL,
+  `user_id` INT NOT NULL,
+  `kind` VARCHAR(32) NOT NULL,
+  `created_at` TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`comment_id`, `user_id`, `kind`),
+  INDEX `fk_commentreactions_user_idx` (`user_id` ASC) VISIBLE,
+  CONSTRAINT `fk_commentreactions_comment`
+    FOREIGN KEY (`comment_id`)
+    REFERENCES `comments` (`id`)
+    ON DELETE CASCADE,
+  CONSTRAINT `fk_commentreactions_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
@@ -0,0 +1,16 @@
+-- One-time tokens an admin hands to the owner of an account without a password, so they can prove it's theirs
+-- and choose one. Only the SHA-256 hash of the token is stored, a user has at most one token at a time.
+
+CREATE TABLE `password_setup_tokens` (
+  `user_id` INT NOT NULL,
+  `token_hash` CHAR(64) NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `expires_at` TIMESTAMP NOT NULL,
+  PRIMARY KEY (`user_id`),
+  CONSTRAINT `fk_passwordsetuptokens_user`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8mb4
+COLLATE = utf8mb4_0900_ai_ci;
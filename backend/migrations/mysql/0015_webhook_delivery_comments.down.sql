@@ -0,0 +1,3 @@
+ALTER TABLE `webhook_deliveries`
+  DROP INDEX `webhook_deliveries_comment_idx`,
+  DROP COLUMN `comment_id`;
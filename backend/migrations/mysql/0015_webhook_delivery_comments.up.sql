@@ -0,0 +1,6 @@
+-- The comment a comment.created delivery carries, so redacting the comment can scrub the delivery too.
+-- Deliveries queued before this migration have none.
+
+ALTER TABLE `webhook_deliveries`
+  ADD COLUMN `comment_id` INT NULL DEFAULT NULL AFTER `payload`,
+  ADD INDEX `webhook_deliveries_comment_idx` (`comment_id` ASC) VISIBLE;
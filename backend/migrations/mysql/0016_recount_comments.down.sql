@@ -0,0 +1,9 @@
+-- Counts the deleted comments again, like 0005_post_ranking did
+UPDATE `posts` p SET
+  p.`comment_count` = (SELECT COUNT(*) FROM `comments` c WHERE c.`post_id` = p.`id`),
+  p.`updated_at` = p.`updated_at`;
+
+UPDATE `posts` SET
+  `hot_score` = LOG10(GREATEST(COALESCE(`likes`, 0) + 2 * `comment_count`, 1))
+    + (COALESCE(UNIX_TIMESTAMP(`created_at`), 1735689600) - 1735689600) / 45000,
+  `updated_at` = `updated_at`;
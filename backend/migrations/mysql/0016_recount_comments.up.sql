@@ -0,0 +1,10 @@
+-- Deleted comments no longer count towards comment_count, which 0005_post_ranking counted them in and CommentDB.Delete
+-- didn't lower. The hot score is recomputed from the new count the same way, updated_at is set to itself so it isn't bumped.
+UPDATE `posts` p SET
+  p.`comment_count` = (SELECT COUNT(*) FROM `comments` c WHERE c.`post_id` = p.`id` AND c.`deleted` = 0),
+  p.`updated_at` = p.`updated_at`;
+
+UPDATE `posts` SET
+  `hot_score` = LOG10(GREATEST(COALESCE(`likes`, 0) + 2 * `comment_count`, 1))
+    + (COALESCE(UNIX_TIMESTAMP(`created_at`), 1735689600) - 1735689600) / 45000,
+  `updated_at` = `updated_at`;
@@ -0,0 +1,21 @@
+DROP TABLE IF EXISTS comment_reactions;
+DROP TABLE IF EXISTS post_reactions;
+DROP TABLE IF EXISTS bookmarks;
+DROP TABLE IF EXISTS topic_subscriptions;
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS mentions;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS comment_revisions;
+DROP TABLE IF EXISTS post_revisions;
+DROP TABLE IF EXISTS topic_moderators;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS post_likes;
+DROP TABLE IF EXISTS comment_likes;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS topics;
+DROP TABLE IF EXISTS users;
+DROP EXTENSION IF EXISTS citext;
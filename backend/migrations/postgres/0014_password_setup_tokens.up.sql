@@ -0,0 +1,8 @@
+-- One-time tokens an admin hands to the owner of an account without a password, see the MySQL migration
+
+CREATE TABLE password_setup_tokens (
+  user_id BIGINT NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  token_hash CHAR(64) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,6 @@
+-- Deleted comments no longer count towards comment_count, which CommentDB.Delete didn't lower.
+-- The hot score is recomputed from the new count as models.HotScore would, 1735689600 is its reference point 2025-01-01 UTC.
+UPDATE posts SET comment_count = (SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.id AND c.deleted = 0);
+
+UPDATE posts SET hot_score = LOG(GREATEST(COALESCE(likes, 0) + 2 * comment_count, 1))
+  + (COALESCE(EXTRACT(EPOCH FROM created_at), 1735689600) - 1735689600) / 45000;
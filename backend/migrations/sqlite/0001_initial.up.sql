@@ -0,0 +1,245 @@
+-- The schema the MySQL migrations end up with, written for SQLite. There are no SQLite databases from before
+-- the migrations, so the whole schema is created at once and the later MySQL versions have no counterpart here.
+
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username VARCHAR(25) NOT NULL UNIQUE COLLATE NOCASE,
+  password_hash VARCHAR(255) NULL DEFAULT NULL,
+  role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS topics (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title VARCHAR(45) NOT NULL UNIQUE COLLATE NOCASE,
+  description TEXT NULL DEFAULT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  user_id INTEGER NOT NULL REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS fk_topics_users_id_idx ON topics (user_id);
+
+CREATE TABLE IF NOT EXISTS posts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title VARCHAR(255) NOT NULL,
+  content TEXT NULL DEFAULT NULL,
+  content_html TEXT NULL DEFAULT NULL,
+  likes INTEGER NULL DEFAULT 0,
+  comment_count INTEGER NOT NULL DEFAULT 0,
+  hot_score DOUBLE NOT NULL DEFAULT 0,
+  edit_count INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  topic_id INTEGER NOT NULL REFERENCES topics (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS fk_posts_users_id_idx ON posts (user_id);
+CREATE INDEX IF NOT EXISTS fk_posts_topics_id_idx ON posts (topic_id);
+CREATE INDEX IF NOT EXISTS posts_hot_score_idx ON posts (hot_score DESC);
+CREATE INDEX IF NOT EXISTS posts_likes_idx ON posts (likes DESC);
+CREATE INDEX IF NOT EXISTS posts_created_at_idx ON posts (created_at DESC);
+
+-- Full text index over the title and content of the posts, kept in sync by the triggers below
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(title, content, content='posts', content_rowid='id');
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+  INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+  INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+  INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+  INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE TABLE IF NOT EXISTS comments (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  content TEXT NULL DEFAULT NULL,
+  content_html TEXT NULL DEFAULT NULL,
+  likes INTEGER NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users (id),
+  parent_id INTEGER NULL DEFAULT NULL REFERENCES comments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  deleted TINYINT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS fk_comments_posts_id_idx ON comments (post_id);
+CREATE INDEX IF NOT EXISTS fk_comments_users_id_idx ON comments (user_id);
+CREATE INDEX IF NOT EXISTS fk_comments_parent_idx ON comments (parent_id);
+
+CREATE TABLE IF NOT EXISTS comment_likes (
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, comment_id)
+);
+CREATE INDEX IF NOT EXISTS fk_commentlikes_comment_idx ON comment_likes (comment_id);
+
+CREATE TABLE IF NOT EXISTS post_likes (
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, post_id)
+);
+CREATE INDEX IF NOT EXISTS fk_postlikes_post_idx ON post_likes (post_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+  id VARCHAR(64) NOT NULL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  user_agent VARCHAR(255) NOT NULL DEFAULT '',
+  ip_address VARCHAR(45) NOT NULL DEFAULT '',
+  revoked_at TIMESTAMP NULL DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS fk_sessions_users_id_idx ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  token_hash CHAR(64) NOT NULL PRIMARY KEY,
+  session_id VARCHAR(64) NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP NULL DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS fk_refreshtokens_session_idx ON refresh_tokens (session_id);
+
+CREATE TABLE IF NOT EXISTS topic_moderators (
+  topic_id INTEGER NOT NULL REFERENCES topics (id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (topic_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS fk_topicmoderators_user_idx ON topic_moderators (user_id);
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+  post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+  revision INTEGER NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  content TEXT NULL DEFAULT NULL,
+  content_html TEXT NULL DEFAULT NULL,
+  editor_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  edited_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (post_id, revision)
+);
+CREATE INDEX IF NOT EXISTS fk_postrevisions_editor_idx ON post_revisions (editor_id);
+
+CREATE TABLE IF NOT EXISTS comment_revisions (
+  comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  revision INTEGER NOT NULL,
+  action TEXT NOT NULL CHECK (action IN ('edit', 'delete', 'redact')),
+  content TEXT NULL DEFAULT NULL,
+  redacted TINYINT NOT NULL DEFAULT 0,
+  reason VARCHAR(255) NULL DEFAULT NULL,
+  editor_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (comment_id, revision)
+);
+CREATE INDEX IF NOT EXISTS fk_commentrevisions_editor_idx ON comment_revisions (editor_id);
+
+CREATE TABLE IF NOT EXISTS notifications (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  kind VARCHAR(32) NOT NULL,
+  actor_id INTEGER NULL DEFAULT NULL REFERENCES users (id) ON DELETE SET NULL,
+  post_id INTEGER NULL DEFAULT NULL REFERENCES posts (id) ON DELETE CASCADE,
+  comment_id INTEGER NULL DEFAULT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  message VARCHAR(512) NULL DEFAULT NULL,
+  read_at TIMESTAMP NULL DEFAULT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, id DESC);
+CREATE INDEX IF NOT EXISTS notifications_user_unread_idx ON notifications (user_id, read_at);
+CREATE INDEX IF NOT EXISTS fk_notifications_actor_idx ON notifications (actor_id);
+CREATE INDEX IF NOT EXISTS fk_notifications_post_idx ON notifications (post_id);
+CREATE INDEX IF NOT EXISTS fk_notifications_comment_idx ON notifications (comment_id);
+
+CREATE TABLE IF NOT EXISTS mentions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  author_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+  comment_id INTEGER NULL DEFAULT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS fk_mentions_user_idx ON mentions (user_id);
+CREATE INDEX IF NOT EXISTS fk_mentions_author_idx ON mentions (author_id);
+CREATE INDEX IF NOT EXISTS fk_mentions_post_idx ON mentions (post_id);
+CREATE INDEX IF NOT EXISTS fk_mentions_comment_idx ON mentions (comment_id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  url VARCHAR(2048) NOT NULL,
+  secret VARCHAR(64) NOT NULL,
+  events VARCHAR(255) NOT NULL,
+  topic_id INTEGER NULL DEFAULT NULL REFERENCES topics (id) ON DELETE CASCADE,
+  created_by INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS fk_webhooks_topic_idx ON webhooks (topic_id);
+CREATE INDEX IF NOT EXISTS fk_webhooks_user_idx ON webhooks (created_by);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  webhook_id INTEGER NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+  event VARCHAR(32) NOT NULL,
+  payload TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  locked_until TIMESTAMP NULL DEFAULT NULL,
+  last_status_code INTEGER NULL DEFAULT NULL,
+  last_error VARCHAR(512) NULL DEFAULT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  delivered_at TIMESTAMP NULL DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+  delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+  attempt INTEGER NOT NULL,
+  status_code INTEGER NULL DEFAULT NULL,
+  error VARCHAR(512) NULL DEFAULT NULL,
+  duration_ms INTEGER NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (delivery_id, attempt)
+);
+
+CREATE TABLE IF NOT EXISTS topic_subscriptions (
+  topic_id INTEGER NOT NULL REFERENCES topics (id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (topic_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS fk_topicsubscriptions_user_idx ON topic_subscriptions (user_id);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+  comment_id INTEGER NULL DEFAULT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  comment_key INTEGER AS (IFNULL(comment_id, 0)) STORED,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS bookmarks_item_unique ON bookmarks (user_id, post_id, comment_key);
+CREATE INDEX IF NOT EXISTS bookmarks_user_idx ON bookmarks (user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS fk_bookmarks_post_idx ON bookmarks (post_id);
+CREATE INDEX IF NOT EXISTS fk_bookmarks_comment_idx ON bookmarks (comment_id);
+
+CREATE TABLE IF NOT EXISTS post_reactions (
+  post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  kind VARCHAR(32) NOT NULL,
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (post_id, user_id, kind)
+);
+CREATE INDEX IF NOT EXISTS fk_postreactions_user_idx ON post_reactions (user_id);
+
+CREATE TABLE IF NOT EXISTS comment_reactions (
+  comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  kind VARCHAR(32) NOT NULL,
+  created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (comment_id, user_id, kind)
+);
+CREATE INDEX IF NOT EXISTS fk_commentreactions_user_idx ON comment_reactions (user_id);
@@ -0,0 +1,8 @@
+-- One-time tokens an admin hands to the owner of an account without a password, see the MySQL migration
+
+CREATE TABLE password_setup_tokens (
+  user_id INTEGER NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  token_hash CHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS webhook_deliveries_comment_idx;
+ALTER TABLE webhook_deliveries DROP COLUMN comment_id;
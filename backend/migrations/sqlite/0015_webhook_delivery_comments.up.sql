@@ -0,0 +1,4 @@
+-- The comment a comment.created delivery carries, see the MySQL migration
+
+ALTER TABLE webhook_deliveries ADD COLUMN comment_id INTEGER NULL DEFAULT NULL;
+CREATE INDEX webhook_deliveries_comment_idx ON webhook_deliveries (comment_id);
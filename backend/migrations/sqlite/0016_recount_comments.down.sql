@@ -0,0 +1,5 @@
+-- Counts the deleted comments again
+UPDATE posts SET comment_count = (SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.id);
+
+UPDATE posts SET hot_score = LOG10(MAX(COALESCE(likes, 0) + 2 * comment_count, 1))
+  + (COALESCE(unixepoch(created_at), 1735689600) - 1735689600) / 45000.0;
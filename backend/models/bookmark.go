@@ -0,0 +1,156 @@
+package models
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Bookmark types, also accepted by the type filter of the bookmark listing
+const (
+	BookmarkPost    = "post"
+	BookmarkComment = "comment"
+)
+
+// A saved post or comment. Comment bookmarks come with the post they are under, so the client can link to it.
+type Bookmark struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Post      Post      `json:"post"`
+	Comment   *Comment  `json:"comment,omitempty"`
+}
+
+type BookmarkDB struct {
+	DB *sql.DB
+}
+
+// Bookmarks a post, bookmarking it again does nothing
+func (m *BookmarkDB) AddPost(ctx context.Context, postID, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, insertIgnore(database.DialectOf(m.DB), "INTO bookmarks (user_id, post_id, created_at) VALUES (?, ?, ?)"), userID, postID, time.Now().UTC())
+	return err
+}
+
+// Bookmarks a comment, the comment's post is stored with it so comment bookmarks can be filtered by topic
+func (m *BookmarkDB) AddComment(ctx context.Context, commentID, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, insertIgnore(database.DialectOf(m.DB), `INTO bookmarks (user_id, post_id, comment_id, created_at)
+	SELECT ?, c.post_id, c.id, ? FROM comments c WHERE c.id = ?`), userID, time.Now().UTC(), commentID)
+	return err
+}
+
+func (m *BookmarkDB) RemovePost(ctx context.Context, postID, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM bookmarks WHERE user_id = ? AND post_id = ? AND comment_id IS NULL", userID, postID)
+	return err
+}
+
+func (m *BookmarkDB) RemoveComment(ctx context.Context, commentID, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM bookmarks WHERE user_id = ? AND comment_id = ?", userID, commentID)
+	return err
+}
+
+// Filters of the bookmark listing, the zero value lists everything
+type BookmarkFilter struct {
+	Type    string // BookmarkPost, BookmarkComment or "" for both
+	TopicID int64
+}
+
+// Lists the bookmarks of a user, most recently saved first
+func (m *BookmarkDB) All(ctx context.Context, userID int64, filter BookmarkFilter, page PageRequest) (Page[Bookmark], error) {
+	key := keyset{Column: "b.created_at", IDColumn: "b.id"}
+	query := `SELECT b.id, b.created_at, b.comment_id,
+		p.id, p.title, p.content, p.content_html, p.likes, p.comment_count, p.edit_count, p.created_at, p.updated_at, p.topic_id, p.user_id, u.username, t.title,
+		EXISTS (SELECT 1 FROM post_likes pl WHERE pl.post_id = p.id AND pl.user_id = b.user_id) AS post_liked,
+		EXISTS (SELECT 1 FROM bookmarks pb WHERE pb.post_id = p.id AND pb.comment_id IS NULL AND pb.user_id = b.user_id) AS post_bookmarked,
+		COALESCE(c.content, ''), c.content_html, COALESCE(c.likes, 0), c.created_at, c.updated_at, c.user_id, c.parent_id, COALESCE(c.deleted, 0), cu.username,
+		EXISTS (SELECT 1 FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.user_id = b.user_id) AS comment_liked
+	FROM bookmarks b
+	JOIN posts p ON b.post_id = p.id
+	JOIN users u ON p.user_id = u.id
+	JOIN topics t ON p.topic_id = t.id
+	LEFT JOIN comments c ON b.comment_id = c.id
+	LEFT JOIN users cu ON c.user_id = cu.id
+	WHERE b.user_id = ?`
+	args := []any{userID}
+	switch filter.Type {
+	case BookmarkPost:
+		query += " AND b.comment_id IS NULL"
+	case BookmarkComment:
+		query += " AND b.comment_id IS NOT NULL"
+	}
+	if filter.TopicID > 0 {
+		query += " AND p.topic_id = ?"
+		args = append(args, filter.TopicID)
+	}
+	if page.Cursor != nil {
+		cond, condArgs := key.condition(page.Cursor)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	orderBy, _ := key.orderBy(page.Cursor != nil && page.Cursor.Prev)
+	query += " ORDER BY " + orderBy + " LIMIT ?"
+	args = append(args, page.Limit+1)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page[Bookmark]{}, err
+	}
+	defer rows.Close()
+	bookmarks := []Bookmark{}
+	for rows.Next() {
+		var b Bookmark
+		var commentID, commentUserID sql.NullInt64
+		var commentCreatedAt, commentUpdatedAt sql.NullTime
+		var commentUsername sql.NullString
+		var c Comment
+		p := &b.Post
+		if err := rows.Scan(&b.ID, &b.CreatedAt, &commentID,
+			&p.ID, &p.Title, &p.Content, contentHTML{&p.ContentHTML, &p.Content}, &p.Likes, &p.CommentCount, &p.EditCount, &p.CreatedAt, &p.UpdatedAt, &p.TopicID, &p.UserID, &p.CreatedByUsername, &p.TopicTitle,
+			&p.LikedByUser, &p.BookmarkedByUser,
+			&c.Content, contentHTML{&c.ContentHTML, &c.Content}, &c.Likes, &commentCreatedAt, &commentUpdatedAt, &commentUserID, &c.ParentCommentID, &c.Deleted, &commentUsername,
+			&c.LikedByUser); err != nil {
+			return Page[Bookmark]{}, err
+		}
+		p.Edited = p.EditCount > 0
+		b.Type = BookmarkPost
+		if commentID.Valid {
+			b.Type = BookmarkComment
+			c.ID = commentID.Int64
+			c.PostID = p.ID
+			c.CreatedAt = commentCreatedAt.Time
+			c.UpdatedAt = commentUpdatedAt.Time
+			c.UserID = commentUserID.Int64
+			c.CreatedByUsername = commentUsername.String
+			c.BookmarkedByUser = true
+			b.Comment = &c
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[Bookmark]{}, err
+	}
+	if err := loadBookmarkReactions(ctx, m.DB, bookmarks, userID); err != nil {
+		return Page[Bookmark]{}, err
+	}
+	return newPage(bookmarks, page, func(b Bookmark) Cursor {
+		return Cursor{CreatedAt: b.CreatedAt, ID: b.ID}
+	}), nil
+}
+
+// Fills in the reactions of the bookmarked posts and comments, the same way the post and comment listings do
+func loadBookmarkReactions(ctx context.Context, db *sql.DB, bookmarks []Bookmark, userID int64) error {
+	posts := make([]Post, len(bookmarks))
+	var comments []*Comment
+	for i, b := range bookmarks {
+		posts[i] = b.Post
+		if b.Comment != nil {
+			comments = append(comments, b.Comment)
+		}
+	}
+	if err := loadPostReactions(ctx, db, posts, userID); err != nil {
+		return err
+	}
+	for i := range bookmarks {
+		bookmarks[i].Post.Reactions = posts[i].Reactions
+	}
+	return loadCommentReactions(ctx, db, comments, userID)
+}
@@ -1,7 +1,13 @@
 package models
 
 import (
+	"backend/database"
+	"backend/markdown"
+	"backend/notifications"
+	"context"
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
 )
 
@@ -9,9 +15,11 @@ import (
 type Comment struct {
 	ID int64 `json:"id"`
 
-	Content   string    `json:"content"`
-	Likes     int       `json:"likes"`
-	CreatedAt time.Time `json:"created_at"`
+	Content string `json:"content"`
+	//Content rendered from Markdown and sanitized, cached in the database for the current revision
+	ContentHTML string    `json:"content_html"`
+	Likes       int       `json:"likes"`
+	CreatedAt   time.Time `json:"created_at"`
 
 	UpdatedAt time.Time `json:"updated_at"`
 
@@ -22,67 +30,208 @@ type Comment struct {
 
 	ParentCommentID sql.NullInt64 `json:"parent_comment_id,omitempty"`
 	//Added field to indicate if the comment is liked by the user making the request
-	LikedByUser bool `json:"liked_by_user"`
+	LikedByUser      bool `json:"liked_by_user"`
+	BookmarkedByUser bool `json:"bookmarked_by_user"`
+	//Counts per reaction kind and the viewer's own reactions, likes included
+	Reactions Reactions `json:"reactions"`
 	//Username of the comment creator
 	CreatedByUsername string `json:"username"`
 }
 
+// A comment inside a comment tree, together with the replies that were loaded under it
+type CommentNode struct {
+	Comment
+	Depth      int            `json:"depth"`
+	ReplyCount int            `json:"reply_count"`
+	Replies    []*CommentNode `json:"replies"`
+	//Set when not all the direct replies were loaded (because of the depth or the page size),
+	//the rest can be fetched from /api/comments/{id}/replies starting after the cursor.
+	HasMoreReplies    bool   `json:"has_more_replies"`
+	MoreRepliesCursor string `json:"more_replies_cursor,omitempty"`
+}
+
+// Returned when editing or deleting a comment that has already been deleted
+var ErrCommentDeleted = errors.New("comment has been deleted")
+
 // DB instance to make queries to
 type CommentDB struct {
 	DB *sql.DB
+	//Told about the notifications replies, likes and mentions create, can be nil
+	Listener notifications.Listener
 }
 
-func (m *CommentDB) AllByPostID(postID, userID int64) ([]Comment, error) { //Gets all the comments under a certain post
+func (m *CommentDB) AllByPostID(ctx context.Context, postID, userID int64, page PageRequest) (Page[Comment], error) { //Gets the comments under a certain post, oldest first
+	key := keyset{Column: "c.created_at", IDColumn: "c.id", Asc: true}
 	//Gets the respective comment columns, together with the username that matches the user id of the comment row
 	//Also searches the comment_likes table for an entry where the both the user id and comment id match the row entry
-	//This is returned in a separate boolean column liked_by_user
-	query := `SELECT c.id, c.content, c.likes, c.created_at, c.updated_at,
+	//This is returned in a separate boolean column liked_by_user, bookmarked_by_user is found the same way in the bookmarks table
+	query := `SELECT c.id, c.content, c.content_html, c.likes, c.created_at, c.updated_at,
 		 c.post_id, c.user_id, c.parent_id,c.deleted, u.username, 
-		 EXISTS (SELECT 1 FROM comment_likes cl where cl.comment_id = c.id AND cl.user_id = ?) AS liked_by_user
+		 EXISTS (SELECT 1 FROM comment_likes cl where cl.comment_id = c.id AND cl.user_id = ?) AS liked_by_user,
+		 EXISTS (SELECT 1 FROM bookmarks b WHERE b.comment_id = c.id AND b.user_id = ?) AS bookmarked_by_user
 	
 	FROM comments c join users u on c.user_id = u.id WHERE c.post_id = ? `
-	rows, err := m.DB.Query(query, userID, postID)
+	args := []any{userID, userID, postID}
+	if page.Cursor != nil {
+		cond, condArgs := key.condition(page.Cursor)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	orderBy, _ := key.orderBy(page.Cursor != nil && page.Cursor.Prev)
+	query += " ORDER BY " + orderBy + " LIMIT ?"
+	args = append(args, page.Limit+1)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return Page[Comment]{}, err
 	}
 	defer rows.Close()
-	var comments []Comment
+	comments := []Comment{}
 	//Scans through the rows returned and checks that the returned fields matches the Comment class.
 	for rows.Next() {
 		var c Comment
-		if err := rows.Scan(&c.ID, &c.Content, &c.Likes, &c.CreatedAt, &c.UpdatedAt,
-			&c.PostID, &c.UserID, &c.ParentCommentID, &c.Deleted, &c.CreatedByUsername, &c.LikedByUser); err != nil {
-			return nil, err
+		if err := rows.Scan(&c.ID, &c.Content, contentHTML{&c.ContentHTML, &c.Content}, &c.Likes, &c.CreatedAt, &c.UpdatedAt,
+			&c.PostID, &c.UserID, &c.ParentCommentID, &c.Deleted, &c.CreatedByUsername, &c.LikedByUser, &c.BookmarkedByUser); err != nil {
+			return Page[Comment]{}, err
 		}
 		comments = append(comments, c)
 	}
-	return comments, nil
+	if err := rows.Err(); err != nil {
+		return Page[Comment]{}, err
+	}
+	if err := loadCommentReactions(ctx, m.DB, commentPointers(comments), userID); err != nil {
+		return Page[Comment]{}, err
+	}
+	return newPage(comments, page, func(c Comment) Cursor {
+		return Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+	}), nil
 }
 
-func (m *CommentDB) Create(postID int64, userID int64, content string, parentCommentID sql.NullInt64) (int64, error) {
+func (m *CommentDB) Create(ctx context.Context, postID int64, userID int64, content string, parentCommentID sql.NullInt64) (int64, error) {
+	mentioned, err := resolveMentions(ctx, m.DB, content, userID)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := begin(ctx, m.DB, m.Listener)
+	if err != nil {
+		return 0, err
+	}
 	//Inserts a new comment
-	result, err := m.DB.Exec("INSERT INTO comments (content, created_at, updated_at, post_id, user_id, parent_id) VALUES (?, ?, ?, ?, ?, ?)",
-		content, time.Now().UTC(), time.Now().UTC(), postID, userID, parentCommentID)
+	commentID, err := database.InsertID(ctx, tx.dialect, tx, "INSERT INTO comments (content, content_html, created_at, updated_at, post_id, user_id, parent_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		content, markdown.Render(content), time.Now().UTC(), time.Now().UTC(), postID, userID, parentCommentID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	//Keep the post's comment count and hot score up to date, the post listings sort on them
+	if _, err := tx.ExecContext(ctx, "UPDATE posts SET comment_count = comment_count + 1 WHERE id = ?", postID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := updateHotScore(ctx, tx, postID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := saveMentions(ctx, tx, postID, sql.NullInt64{Int64: commentID, Valid: true}, userID, mentioned); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	//Replies notify the author of the parent comment, top level comments the author of the post
+	reply := notifications.Notification{
+		Kind:      notifications.KindPostReply,
+		ActorID:   sql.NullInt64{Int64: userID, Valid: true},
+		PostID:    sql.NullInt64{Int64: postID, Valid: true},
+		CommentID: sql.NullInt64{Int64: commentID, Valid: true},
+	}
+	if parentCommentID.Valid {
+		reply.Kind = notifications.KindCommentReply
+		err = tx.QueryRowContext(ctx, "SELECT user_id FROM comments WHERE id = ?", parentCommentID).Scan(&reply.UserID)
+	} else {
+		err = tx.QueryRowContext(ctx, "SELECT user_id FROM posts WHERE id = ?", postID).Scan(&reply.UserID)
+	}
 	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := notifications.Create(ctx, tx, reply); err != nil {
+		tx.Rollback()
 		return 0, err
 	}
-	return result.LastInsertId()
+	return commentID, tx.Commit()
 }
-func (m *CommentDB) Delete(commentID int64) error {
-	//Sets the deleted flag in a comment to true(1)
-	_, err := m.DB.Exec("UPDATE comments SET deleted = 1 WHERE id = ?", commentID)
-	return err
+
+// Sets the deleted flag of a comment, the content is kept as a revision so moderators can still review it
+func (m *CommentDB) Delete(ctx context.Context, commentID, editorID int64) error {
+	return m.revise(ctx, commentID, editorID, CommentDeleted, func(tx *hookedTx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE comments SET deleted = 1 WHERE id = ?", commentID); err != nil {
+			return err
+		}
+		return uncountComment(ctx, tx, commentID)
+	})
 }
 
-func (m *CommentDB) Update(commentID int64, content string) error {
-	//Updates a comment content
-	_, err := m.DB.Exec("UPDATE comments SET content = ?, updated_at = ? WHERE id = ?", content, time.Now().UTC(), commentID)
-	return err
+// Takes a comment that was just deleted out of its post's comment count and hot score, only comments that aren't deleted count
+func uncountComment(ctx context.Context, q querier, commentID int64) error {
+	var postID int64
+	if err := q.QueryRowContext(ctx, "SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, "UPDATE posts SET comment_count = comment_count - 1 WHERE id = ? AND comment_count > 0", postID); err != nil {
+		return err
+	}
+	return updateHotScore(ctx, q, postID)
+}
+
+// Updates the content of a comment, the previous content is kept as a revision
+func (m *CommentDB) Update(ctx context.Context, commentID, editorID int64, content string) error {
+	mentioned, err := resolveMentions(ctx, m.DB, content, editorID)
+	if err != nil {
+		return err
+	}
+	return m.revise(ctx, commentID, editorID, CommentEdited, func(tx *hookedTx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE comments SET content = ?, content_html = ?, updated_at = ? WHERE id = ?", content, markdown.Render(content), time.Now().UTC(), commentID)
+		if err != nil {
+			return err
+		}
+		var postID int64
+		if err := tx.QueryRowContext(ctx, "SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID); err != nil {
+			return err
+		}
+		return saveMentions(ctx, tx, postID, sql.NullInt64{Int64: commentID, Valid: true}, editorID, mentioned)
+	})
+}
+
+// Saves the current content of a comment as a revision and then applies the change, in one transaction.
+// Comments that are already deleted are left alone.
+func (m *CommentDB) revise(ctx context.Context, commentID, editorID int64, action string, change func(tx *hookedTx) error) error {
+	tx, err := begin(ctx, m.DB, m.Listener)
+	if err != nil {
+		return err
+	}
+	var content sql.NullString
+	var deleted bool
+	//Lock the comment so that two concurrent changes can't get the same revision number
+	if err := tx.QueryRowContext(ctx, "SELECT content, deleted FROM comments WHERE id = ?"+forUpdate(tx.dialect), commentID).Scan(&content, &deleted); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if deleted {
+		tx.Rollback()
+		return ErrCommentDeleted
+	}
+	if err := addCommentRevision(ctx, tx, commentID, editorID, action, content, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := change(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // Get all comments under a parent comment, useful for sub-replies
-func (m *CommentDB) GetByParentID(commentID int64) (*[]Comment, error) {
-	rows, err := m.DB.Query("SELECT c.id, c.content, c.likes, c.created_at, c.updated_at, c.post_id, c.user_id, c.parent_id, u.username FROM comments c join users u on c.user_id = u.id WHERE c.parent_id = ?", commentID)
+func (m *CommentDB) GetByParentID(ctx context.Context, commentID int64) (*[]Comment, error) {
+	rows, err := m.DB.QueryContext(ctx, "SELECT c.id, c.content, c.content_html, c.likes, c.created_at, c.updated_at, c.post_id, c.user_id, c.parent_id, c.deleted, u.username FROM comments c join users u on c.user_id = u.id WHERE c.parent_id = ?", commentID)
 
 	if err != nil {
 		return nil, err
@@ -91,7 +240,7 @@ func (m *CommentDB) GetByParentID(commentID int64) (*[]Comment, error) {
 	var comments []Comment
 	for rows.Next() {
 		var c Comment
-		if err := rows.Scan(&c.ID, &c.Content, &c.Likes, &c.CreatedAt, &c.UpdatedAt, &c.PostID, &c.UserID, &c.ParentCommentID, &c.Deleted, &c.CreatedByUsername); err != nil {
+		if err := rows.Scan(&c.ID, &c.Content, contentHTML{&c.ContentHTML, &c.Content}, &c.Likes, &c.CreatedAt, &c.UpdatedAt, &c.PostID, &c.UserID, &c.ParentCommentID, &c.Deleted, &c.CreatedByUsername); err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
@@ -101,12 +250,15 @@ func (m *CommentDB) GetByParentID(commentID int64) (*[]Comment, error) {
 }
 
 // Get comment by ID
-func (m *CommentDB) GetByID(commentID int64) (*Comment, error) {
-	row := m.DB.QueryRow(`SELECT c.id, c.content, c.likes, c.created_at, c.updated_at, c.post_id, c.user_id, c.parent_id,c.deleted, u.username 
+func (m *CommentDB) GetByID(ctx context.Context, commentID int64) (*Comment, error) {
+	row := m.DB.QueryRowContext(ctx, `SELECT c.id, c.content, c.content_html, c.likes, c.created_at, c.updated_at, c.post_id, c.user_id, c.parent_id,c.deleted, u.username 
 	FROM comments c join users u on c.user_id = u.id WHERE c.id = ?`, commentID)
 
 	var c Comment
-	if err := row.Scan(&c.ID, &c.Content, &c.Likes, &c.CreatedAt, &c.UpdatedAt, &c.PostID, &c.UserID, &c.ParentCommentID, &c.Deleted, &c.CreatedByUsername); err != nil {
+	if err := row.Scan(&c.ID, &c.Content, contentHTML{&c.ContentHTML, &c.Content}, &c.Likes, &c.CreatedAt, &c.UpdatedAt, &c.PostID, &c.UserID, &c.ParentCommentID, &c.Deleted, &c.CreatedByUsername); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 	return &c, nil
@@ -114,40 +266,259 @@ func (m *CommentDB) GetByID(commentID int64) (*Comment, error) {
 }
 
 // Likes a comment
-func (m *CommentDB) LikeComment(commentID, userID int64) error {
-	tx, err := m.DB.Begin()
+func (m *CommentDB) LikeComment(ctx context.Context, commentID, userID int64) error {
+	tx, err := begin(ctx, m.DB, m.Listener)
 	if err != nil {
 		return err
 	}
+	//Lock the comment so concurrent toggles by the same user are applied one after the other
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM comments WHERE id = ?"+forUpdate(tx.dialect), commentID); err != nil {
+		tx.Rollback()
+		return err
+	}
 	//Checks if the comment is already liked, if it is liked, means that the user intends to unlike it, so delete it from the table.
 	var exists bool
-	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM comment_likes WHERE comment_id = ? AND user_id = ?)", commentID, userID).Scan(&exists)
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM comment_likes WHERE comment_id = ? AND user_id = ?)", commentID, userID).Scan(&exists)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	if exists {
-		_, err = tx.Exec("DELETE FROM comment_likes WHERE comment_id = ? AND user_id = ?", commentID, userID)
-		if err != nil {
-			tx.Rollback()
-			return err
+		_, err = removeCommentLike(ctx, tx, commentID, userID)
+	} else { // If the like entry does NOT exist, it means the user intends to like the comment, so insert the like entry into the table.
+		_, err = addCommentLike(ctx, tx, commentID, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Likes (liked) or unlikes a comment, safe to repeat. changed is false if the comment already was in the requested state.
+func (m *CommentDB) SetLike(ctx context.Context, commentID, userID int64, liked bool) (changed bool, err error) {
+	tx, err := begin(ctx, m.DB, m.Listener)
+	if err != nil {
+		return false, err
+	}
+	if liked {
+		changed, err = addCommentLike(ctx, tx, commentID, userID)
+	} else {
+		changed, err = removeCommentLike(ctx, tx, commentID, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return changed, tx.Commit()
+}
+
+// Returns the like state of a comment for the user, nil if the comment doesn't exist
+func (m *CommentDB) LikeState(ctx context.Context, commentID, userID int64) (*LikeState, error) {
+	var state LikeState
+	err := m.DB.QueryRowContext(ctx, "SELECT c.likes, EXISTS (SELECT 1 FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.user_id = ?) FROM comments c WHERE c.id = ?",
+		userID, commentID).Scan(&state.Likes, &state.Liked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
 		}
-		_, err = tx.Exec("UPDATE comments SET likes = likes - 1 WHERE id = ?", commentID)
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Adds the like and, if it wasn't there yet, bumps the like count and notifies the author
+func addCommentLike(ctx context.Context, tx *hookedTx, commentID, userID int64) (bool, error) {
+	result, err := tx.ExecContext(ctx, insertIgnore(tx.dialect, "INTO comment_likes (comment_id, user_id) VALUES (?, ?)"), commentID, userID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE comments SET likes = likes + 1 WHERE id = ?", commentID); err != nil {
+		return false, err
+	}
+	like := notifications.Notification{
+		Kind:      notifications.KindCommentLike,
+		ActorID:   sql.NullInt64{Int64: userID, Valid: true},
+		CommentID: sql.NullInt64{Int64: commentID, Valid: true},
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT user_id, post_id FROM comments WHERE id = ?", commentID).Scan(&like.UserID, &like.PostID); err != nil {
+		return false, err
+	}
+	return true, notifications.Create(ctx, tx, like)
+}
+
+// Removes the like and, if there was one, lowers the like count and withdraws the unread notification
+func removeCommentLike(ctx context.Context, tx *hookedTx, commentID, userID int64) (bool, error) {
+	result, err := tx.ExecContext(ctx, "DELETE FROM comment_likes WHERE comment_id = ? AND user_id = ?", commentID, userID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE comments SET likes = likes - 1 WHERE id = ?", commentID); err != nil {
+		return false, err
+	}
+	return true, notifications.RemoveLike(ctx, tx, userID, 0, sql.NullInt64{Int64: commentID, Valid: true})
+}
+
+// Loads a comment tree one level at a time. If parentID is set the tree starts at the replies of that comment,
+// otherwise it starts at the top level comments of the post. At most maxDepth levels are loaded and every comment
+// gets at most limit replies, the top level is paginated with the after cursor.
+// Returns the top level nodes and the cursor for the next page of top level comments (nil if there are no more).
+func (m *CommentDB) Tree(ctx context.Context, postID int64, parentID sql.NullInt64, userID int64, maxDepth, limit int, after *Cursor) ([]*CommentNode, *Cursor, error) {
+	anchor := "c.post_id = ? AND c.parent_id IS NULL"
+	args := []any{postID}
+	if parentID.Valid {
+		anchor = "c.parent_id = ?"
+		args = []any{parentID.Int64}
+	}
+	if after != nil {
+		anchor += " AND (c.created_at > ? OR (c.created_at = ? AND c.id > ?))"
+		args = append(args, after.CreatedAt, after.CreatedAt, after.ID)
+	}
+	//Every level keeps the first limit+1 rows under each parent, the extra row is only used to know if there is another page.
+	//Only the kept rows are walked further down, so big threads never load more than they return
+	ranked := "SELECT c.id, ROW_NUMBER() OVER (ORDER BY c.created_at, c.id) AS rn FROM comments c WHERE " + anchor + " ORDER BY c.created_at, c.id LIMIT ?"
+	args = append(args, limit+1)
+
+	roots := []*CommentNode{}
+	var next *Cursor
+	nodes := map[int64]*CommentNode{}
+	for depth := 0; depth < maxDepth; depth++ {
+		level, err := m.treeLevel(ctx, ranked, args, userID, limit, depth)
 		if err != nil {
-			tx.Rollback()
-			return err
+			return nil, nil, err
 		}
-	} else { // If the like entry does NOT exist, it means the user intends to like the comment, so insert the like entry into the table.
-		_, err = tx.Exec("INSERT INTO comment_likes (comment_id, user_id) VALUES (?, ?)", commentID, userID)
-		if err != nil {
-			tx.Rollback()
-			return err
+		var parents []int64
+		for _, n := range level {
+			if n.Depth == 0 {
+				roots = append(roots, n)
+			} else {
+				parent := nodes[n.ParentCommentID.Int64]
+				parent.Replies = append(parent.Replies, n)
+			}
+			nodes[n.ID] = n
+			parents = append(parents, n.ID)
 		}
-		_, err = tx.Exec("UPDATE comments SET likes = likes + 1 WHERE id = ?", commentID)
-		if err != nil {
-			tx.Rollback()
-			return err
+		if depth == 0 && len(roots) > limit {
+			//One past the page size, there is another page but the row itself is not returned or walked
+			roots = roots[:limit]
+			delete(nodes, level[limit].ID)
+			parents = parents[:limit]
+			last := roots[limit-1]
+			next = &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+		if len(parents) == 0 {
+			break
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(parents)), ",")
+		ranked = "SELECT c.id, ROW_NUMBER() OVER (PARTITION BY c.parent_id ORDER BY c.created_at, c.id) AS rn FROM comments c WHERE c.parent_id IN (" + placeholders + ")"
+		args = make([]any, len(parents))
+		for i, id := range parents {
+			args[i] = id
 		}
 	}
-	return tx.Commit()
+	loaded := make([]*Comment, 0, len(nodes))
+	for _, n := range nodes {
+		loaded = append(loaded, &n.Comment)
+	}
+	if err := loadCommentReactions(ctx, m.DB, loaded, userID); err != nil {
+		return nil, nil, err
+	}
+	//Flag the comments whose replies were not all loaded, so the client can lazily load the rest
+	for _, n := range nodes {
+		if len(n.Replies) < n.ReplyCount {
+			n.HasMoreReplies = true
+			if len(n.Replies) > 0 {
+				last := n.Replies[len(n.Replies)-1]
+				n.MoreRepliesCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+			}
+		}
+	}
+	return roots, next, nil
+}
+
+// Loads one level of a comment tree. ranked selects the ids of the level with their row number under their parent,
+// replies past limit are dropped but the top level keeps its extra row so Tree can tell there is another page.
+func (m *CommentDB) treeLevel(ctx context.Context, ranked string, rankedArgs []any, userID int64, limit, depth int) ([]*CommentNode, error) {
+	keep := limit
+	if depth == 0 {
+		keep = limit + 1
+	}
+	query := `SELECT c.id, c.content, c.content_html, c.likes, c.created_at, c.updated_at, c.post_id, c.user_id, c.parent_id, c.deleted, u.username,
+		EXISTS (SELECT 1 FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.user_id = ?) AS liked_by_user,
+		EXISTS (SELECT 1 FROM bookmarks b WHERE b.comment_id = c.id AND b.user_id = ?) AS bookmarked_by_user,
+		(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS reply_count
+	FROM (` + ranked + `) ranked
+	JOIN comments c ON c.id = ranked.id
+	JOIN users u ON c.user_id = u.id
+	WHERE ranked.rn <= ?
+	ORDER BY c.created_at, c.id`
+	args := append(append([]any{userID, userID}, rankedArgs...), keep)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	level := []*CommentNode{}
+	for rows.Next() {
+		n := CommentNode{Depth: depth, Replies: []*CommentNode{}}
+		if err := rows.Scan(&n.ID, &n.Content, contentHTML{&n.ContentHTML, &n.Content}, &n.Likes, &n.CreatedAt, &n.UpdatedAt, &n.PostID, &n.UserID, &n.ParentCommentID,
+			&n.Deleted, &n.CreatedByUsername, &n.LikedByUser, &n.BookmarkedByUser, &n.ReplyCount); err != nil {
+			return nil, err
+		}
+		level = append(level, &n)
+	}
+	return level, rows.Err()
+}
+
+// Returns up to levels ancestors of a comment, walking up the parent chain with a recursive CTE.
+// The ancestors are ordered from the furthest one down to the direct parent.
+func (m *CommentDB) Ancestors(ctx context.Context, commentID, userID int64, levels int) ([]Comment, error) {
+	query := `WITH RECURSIVE ancestors (id, parent_id, depth) AS (
+		SELECT c.id, c.parent_id, 0 FROM comments c WHERE c.id = ?
+		UNION ALL
+		SELECT p.id, p.parent_id, a.depth + 1 FROM comments p JOIN ancestors a ON p.id = a.parent_id WHERE a.depth < ?
+	)
+	SELECT c.id, c.content, c.content_html, c.likes, c.created_at, c.updated_at, c.post_id, c.user_id, c.parent_id, c.deleted, u.username,
+		EXISTS (SELECT 1 FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.user_id = ?) AS liked_by_user,
+		EXISTS (SELECT 1 FROM bookmarks b WHERE b.comment_id = c.id AND b.user_id = ?) AS bookmarked_by_user
+	FROM ancestors a
+	JOIN comments c ON c.id = a.id
+	JOIN users u ON c.user_id = u.id
+	WHERE a.depth > 0
+	ORDER BY a.depth DESC`
+	rows, err := m.DB.QueryContext(ctx, query, commentID, levels, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.Content, contentHTML{&c.ContentHTML, &c.Content}, &c.Likes, &c.CreatedAt, &c.UpdatedAt, &c.PostID, &c.UserID, &c.ParentCommentID,
+			&c.Deleted, &c.CreatedByUsername, &c.LikedByUser, &c.BookmarkedByUser); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadCommentReactions(ctx, m.DB, commentPointers(comments), userID); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func commentPointers(comments []Comment) []*Comment {
+	pointers := make([]*Comment, len(comments))
+	for i := range comments {
+		pointers[i] = &comments[i]
+	}
+	return pointers
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"backend/markdown"
+	"fmt"
+)
+
+// Scans the cached content_html column of a post or comment. Rows written before the HTML was
+// cached have a NULL there, in which case the source is rendered on the fly.
+// The source column has to come before content_html in the query, columns are scanned in order.
+type contentHTML struct {
+	html   *string
+	source *string
+}
+
+func (c contentHTML) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*c.html = markdown.Render(*c.source)
+	case []byte:
+		*c.html = string(v)
+	case string:
+		*c.html = v
+	default:
+		return fmt.Errorf("unsupported type %T for content_html", value)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Cursor marks the row a page stops at, the next (or previous) page starts right after (or before) it.
+// It is sent to the client as an opaque base64 string so that the format can change without breaking clients.
+// Listings sorted by time use CreatedAt as the sort key, the others use Score. The ID breaks ties.
+type Cursor struct {
+	Sort      string    `json:"s,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	Score     float64   `json:"v,omitempty"`
+	ID        int64     `json:"i"`
+	//Time the first page was loaded, rising scores depend on it so every page has to use the same one
+	Now time.Time `json:"n,omitempty"`
+	//Set on prev_cursor, the page is read backwards from the cursor
+	Prev bool `json:"p,omitempty"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decodes a cursor sent by the client, an empty string means start from the beginning and returns nil
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Page is the response envelope of every list endpoint
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// PageRequest is the page a client asked for, Cursor is nil for the first page
+type PageRequest struct {
+	Limit  int
+	Cursor *Cursor
+}
+
+// keyset describes the order of a listing: by Column and then by IDColumn, both descending unless Asc is set.
+// Args are the arguments Column itself needs, e.g. the reference time of the rising score.
+type keyset struct {
+	Column   string
+	IDColumn string
+	Args     []any
+	ByScore  bool
+	Asc      bool
+}
+
+// Returns the condition selecting the rows after the cursor (or before it for a prev cursor)
+func (k keyset) condition(c *Cursor) (string, []any) {
+	op := "<"
+	if k.Asc != c.Prev {
+		op = ">"
+	}
+	var v any = c.CreatedAt
+	if k.ByScore {
+		v = c.Score
+	}
+	cond := fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", k.Column, op, k.Column, k.IDColumn, op)
+	args := append([]any{}, k.Args...)
+	args = append(args, v)
+	args = append(args, k.Args...)
+	args = append(args, v, c.ID)
+	return cond, args
+}
+
+// Returns the ORDER BY clause, a prev page is read in the opposite order and flipped afterwards
+func (k keyset) orderBy(prev bool) (string, []any) {
+	dir := "DESC"
+	if k.Asc != prev {
+		dir = "ASC"
+	}
+	return fmt.Sprintf("%s %s, %s %s", k.Column, dir, k.IDColumn, dir), k.Args
+}
+
+// Builds the page from the rows of a query that fetched one row more than the limit.
+// cursorOf returns the cursor pointing at an item.
+func newPage[T any](rows []T, req PageRequest, cursorOf func(T) Cursor) Page[T] {
+	prev := req.Cursor != nil && req.Cursor.Prev
+	hasMore := len(rows) > req.Limit
+	if hasMore {
+		rows = rows[:req.Limit]
+	}
+	if prev { //Read backwards, so put the rows back in display order
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	page := Page[T]{Items: rows}
+	if len(rows) == 0 {
+		return page
+	}
+	//Coming back from a later page there is always a next page, otherwise only if the extra row was found
+	if prev || hasMore {
+		page.NextCursor = cursorOf(rows[len(rows)-1]).Encode()
+	}
+	//Likewise there is a previous page if we went back and found more rows, or if we came from an earlier page
+	if (prev && hasMore) || (!prev && req.Cursor != nil) {
+		c := cursorOf(rows[0])
+		c.Prev = true
+		page.PrevCursor = c.Encode()
+	}
+	return page
+}
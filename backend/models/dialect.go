@@ -0,0 +1,110 @@
+package models
+
+import (
+	"backend/database"
+	"strings"
+	"unicode"
+)
+
+// The queries in this package are written for MySQL, these helpers return the parts other databases spell differently.
+// The dialect comes from the database the query runs on, see database.DialectOf.
+
+// INSERT that skips rows which would violate a unique key, rest is everything after INSERT
+func insertIgnore(d database.Dialect, rest string) string {
+	switch d {
+	case database.SQLite:
+		return "INSERT OR IGNORE " + rest
+	case database.Postgres:
+		return "INSERT " + rest + " ON CONFLICT DO NOTHING"
+	default:
+		return "INSERT IGNORE " + rest
+	}
+}
+
+// Locks the selected rows until the transaction ends. SQLite has no row locks and only ever runs one write transaction.
+func forUpdate(d database.Dialect) string {
+	if d == database.SQLite {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// Whole minutes from the column to the time passed as the next argument
+func minutesSince(d database.Dialect, column string) string {
+	switch d {
+	case database.SQLite:
+		return "((unixepoch(?) - unixepoch(" + column + ")) / 60)"
+	case database.Postgres:
+		return "CAST(FLOOR(EXTRACT(EPOCH FROM (CAST(? AS TIMESTAMPTZ) - " + column + ")) / 60) AS INTEGER)"
+	default:
+		return "TIMESTAMPDIFF(MINUTE, " + column + ", ?)"
+	}
+}
+
+// The floating point type for CAST
+func doubleType(d database.Dialect) string {
+	if d == database.Postgres {
+		return "DOUBLE PRECISION"
+	}
+	return "DOUBLE"
+}
+
+// Length in characters
+func charLength(d database.Dialect, column string) string {
+	if d == database.SQLite {
+		return "LENGTH(" + column + ")"
+	}
+	return "CHAR_LENGTH(" + column + ")"
+}
+
+// LIKE with \ as the escape character, which MySQL and Postgres use by default and SQLite has to be told about
+func likeEscaped(d database.Dialect) string {
+	if d == database.SQLite {
+		return `LIKE ? ESCAPE '\'`
+	}
+	return "LIKE ?"
+}
+
+// Full text search over the title and content of the posts, matching any of the words or words starting with them.
+// Returns the condition and its argument for PostDB.list.
+func postSearch(d database.Dialect, query string) (string, []any) {
+	switch d {
+	case database.SQLite:
+		//Every word is quoted so FTS5 doesn't read its operators out of the search
+		words := strings.Fields(query)
+		if len(words) == 0 {
+			return "1 = 0", nil
+		}
+		for i, w := range words {
+			words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"*`
+		}
+		return "p.id IN (SELECT rowid FROM posts_fts WHERE posts_fts MATCH ?)", []any{strings.Join(words, " OR ")}
+	case database.Postgres:
+		return tsquery("p.search_vector", query)
+	default:
+		return "MATCH(p.title,p.content) AGAINST (? IN BOOLEAN MODE)", []any{"*" + query + "*"}
+	}
+}
+
+// Search of the topic titles and descriptions, full text on Postgres and a substring match elsewhere
+func topicSearch(d database.Dialect, query string) (string, []any) {
+	if d == database.Postgres {
+		return tsquery("t.search_vector", query)
+	}
+	searchTerm := "%" + query + "%"
+	return "(t.title LIKE ? OR t.description LIKE ?)", []any{searchTerm, searchTerm}
+}
+
+// Matches a tsvector column against any of the words of the query or words starting with them.
+// Only letters and digits are kept, which is also how the 'simple' configuration splits the indexed text,
+// so nothing in the query can be read as a tsquery operator.
+func tsquery(column, query string) (string, []any) {
+	words := strings.FieldsFunc(query, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if len(words) == 0 {
+		return "1 = 0", nil
+	}
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return column + " @@ to_tsquery('simple', ?)", []any{strings.Join(words, " | ")}
+}
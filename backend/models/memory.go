@@ -0,0 +1,1507 @@
+package models
+
+import (
+	"backend/database"
+	"backend/markdown"
+	"backend/notifications"
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// In-memory stores for tests, they follow the database stores down to the constraints: usernames and topic titles are unique,
+// users can't be deleted while they own content and deleting a topic or post takes everything under it along.
+// Mentions and their notifications are kept, the reply and like notifications the database stores send along with a change aren't.
+// listener is told about the notifications and can be nil.
+// Webhook deliveries stay queued, there is no worker sending them.
+func NewMemoryStores(listener notifications.Listener) Stores {
+	mem := &memory{
+		mentions:         map[memoryMention]map[int64]bool{},
+		listener:         listener,
+		users:            map[int64]*User{},
+		topics:           map[int64]*Topic{},
+		posts:            map[int64]*memoryPost{},
+		comments:         map[int64]*memoryComment{},
+		postLikes:        map[int64]map[int64]bool{},
+		commentLikes:     map[int64]map[int64]bool{},
+		postReactions:    map[int64]map[int64][]string{},
+		commentReactions: map[int64]map[int64][]string{},
+		moderators:       map[int64][]int64{},
+		subscriptions:    map[int64]map[int64]bool{},
+		setupTokens:      map[int64]memorySetupToken{},
+		sessions:         map[string]*memorySession{},
+		refreshTokens:    map[string]*memoryRefreshToken{},
+		bookmarks:        map[int64]*memoryBookmark{},
+		notifications:    map[int64]*notifications.Notification{},
+		webhooks:         map[int64]*Webhook{},
+		deliveries:       map[int64]*WebhookDelivery{},
+	}
+	return Stores{
+		Posts:         &memoryPosts{mem},
+		Topics:        &memoryTopics{mem},
+		Comments:      &memoryComments{mem},
+		Users:         &memoryUsers{mem},
+		Sessions:      &memorySessions{mem},
+		RefreshTokens: &memoryRefreshTokens{mem},
+		Bookmarks:     &memoryBookmarks{mem},
+		Notifications: &memoryNotifications{mem},
+		Webhooks:      &memoryWebhooks{mem},
+	}
+}
+
+type memoryPost struct {
+	Post
+	revisions []PostRevision
+}
+
+type memoryComment struct {
+	Comment
+	revisions []CommentRevision
+}
+
+// The tables shared by the memory stores, the like counts, comment counts and usernames are worked out when reading
+type memory struct {
+	mu     sync.Mutex
+	lastID int64
+
+	users    map[int64]*User
+	topics   map[int64]*Topic
+	posts    map[int64]*memoryPost
+	comments map[int64]*memoryComment
+
+	//Likes by item and then by user, reactions other than likes by item and then by user
+	postLikes        map[int64]map[int64]bool
+	commentLikes     map[int64]map[int64]bool
+	postReactions    map[int64]map[int64][]string
+	commentReactions map[int64]map[int64][]string
+
+	//Moderators in the order they were added, subscribers by topic
+	moderators    map[int64][]int64
+	subscriptions map[int64]map[int64]bool
+
+	//Password setup tokens by user
+	setupTokens map[int64]memorySetupToken
+
+	//Sessions by ID, refresh tokens by hash
+	sessions      map[string]*memorySession
+	refreshTokens map[string]*memoryRefreshToken
+
+	//Mentioned users by post or comment
+	mentions map[memoryMention]map[int64]bool
+
+	bookmarks     map[int64]*memoryBookmark
+	notifications map[int64]*notifications.Notification
+	listener      notifications.Listener
+	webhooks      map[int64]*Webhook
+	deliveries    map[int64]*WebhookDelivery
+}
+
+type memorySetupToken struct {
+	hash      string
+	expiresAt time.Time
+}
+
+type memorySession struct {
+	Session
+	revoked bool
+}
+
+type memoryRefreshToken struct {
+	sessionID string
+	expiresAt time.Time
+	used      bool
+}
+
+// commentID is 0 for the mentions in the post itself
+type memoryMention struct {
+	postID    int64
+	commentID int64
+}
+
+// commentID is 0 for post bookmarks
+type memoryBookmark struct {
+	id        int64
+	userID    int64
+	postID    int64
+	commentID int64
+	createdAt time.Time
+}
+
+type memoryPosts struct{ *memory }
+type memoryTopics struct{ *memory }
+type memoryComments struct{ *memory }
+type memoryUsers struct{ *memory }
+type memorySessions struct{ *memory }
+type memoryRefreshTokens struct{ *memory }
+type memoryBookmarks struct{ *memory }
+type memoryNotifications struct{ *memory }
+type memoryWebhooks struct{ *memory }
+
+// IDs are unique over all tables, which the stores don't rely on but makes mixed up IDs show up in tests
+func (m *memory) nextID() int64 {
+	m.lastID++
+	return m.lastID
+}
+
+// TIMESTAMP columns have no fractional seconds, so neither do the memory stores
+func memoryNow() time.Time {
+	return time.Now().UTC().Round(time.Second)
+}
+
+// Pages through rows the way the keyset listings do in SQL: ordered by the sort key of their cursor and then by ID,
+// starting after the page's cursor, with the one extra row newPage looks for
+func memoryPage[T any](rows []T, page PageRequest, key keyset, cursorOf func(T) Cursor) Page[T] {
+	type keyed struct {
+		row    T
+		cursor Cursor
+	}
+	compare := func(a, b Cursor) int {
+		c := a.CreatedAt.Compare(b.CreatedAt)
+		if key.ByScore {
+			c = cmp.Compare(a.Score, b.Score)
+		}
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if key.Asc == (page.Cursor != nil && page.Cursor.Prev) { //Descending
+			c = -c
+		}
+		return c
+	}
+	all := make([]keyed, 0, len(rows))
+	for _, r := range rows {
+		k := keyed{row: r, cursor: cursorOf(r)}
+		if page.Cursor == nil || compare(k.cursor, *page.Cursor) > 0 {
+			all = append(all, k)
+		}
+	}
+	slices.SortFunc(all, func(a, b keyed) int { return compare(a.cursor, b.cursor) })
+	selected := []T{}
+	for i := 0; i < len(all) && i <= page.Limit; i++ {
+		selected = append(selected, all[i].row)
+	}
+	return newPage(selected, page, cursorOf)
+}
+
+// Case insensitive like the database collation
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func (m *memory) reactions(likes map[int64]bool, reactions map[int64][]string, userID int64) Reactions {
+	counts := map[string]int{}
+	for _, kinds := range reactions {
+		for _, kind := range kinds {
+			counts[kind]++
+		}
+	}
+	return newReactions(len(likes), likes[userID], counts, reactions[userID])
+}
+
+// Adds (on) or removes a reaction, returns whether anything changed
+func setReaction(reactions map[int64]map[int64][]string, itemID, userID int64, kind string, on bool) bool {
+	mine := reactions[itemID][userID]
+	if slices.Contains(mine, kind) == on {
+		return false
+	}
+	if reactions[itemID] == nil {
+		reactions[itemID] = map[int64][]string{}
+	}
+	if on {
+		reactions[itemID][userID] = append(mine, kind)
+	} else {
+		reactions[itemID][userID] = slices.DeleteFunc(slices.Clone(mine), func(k string) bool { return k == kind })
+	}
+	return true
+}
+
+// Likes (liked) or unlikes an item, returns whether anything changed
+func setLike(likes map[int64]map[int64]bool, itemID, userID int64, liked bool) bool {
+	if likes[itemID][userID] == liked {
+		return false
+	}
+	if likes[itemID] == nil {
+		likes[itemID] = map[int64]bool{}
+	}
+	if liked {
+		likes[itemID][userID] = true
+	} else {
+		delete(likes[itemID], userID)
+	}
+	return true
+}
+
+func (m *memory) username(userID int64) string {
+	if u, ok := m.users[userID]; ok {
+		return u.Username
+	}
+	return ""
+}
+
+// The post as the listings return it to userID
+func (m *memory) post(p *memoryPost, userID int64) Post {
+	post := p.Post
+	post.Likes = len(m.postLikes[p.ID])
+	post.CommentCount = 0
+	for _, c := range m.comments {
+		if c.PostID == p.ID && !c.Deleted {
+			post.CommentCount++
+		}
+	}
+	post.Edited = post.EditCount > 0
+	post.TopicTitle = m.topics[p.TopicID].Title
+	post.CreatedByUsername = m.username(p.UserID)
+	post.LikedByUser = m.postLikes[p.ID][userID]
+	post.BookmarkedByUser = m.bookmark(userID, p.ID, 0) != nil
+	post.Reactions = m.reactions(m.postLikes[p.ID], m.postReactions[p.ID], userID)
+	return post
+}
+
+// The comment as the listings return it to userID
+func (m *memory) comment(c *memoryComment, userID int64) Comment {
+	comment := c.Comment
+	comment.Likes = len(m.commentLikes[c.ID])
+	comment.CreatedByUsername = m.username(c.UserID)
+	comment.LikedByUser = m.commentLikes[c.ID][userID]
+	comment.BookmarkedByUser = m.bookmark(userID, c.PostID, c.ID) != nil
+	comment.Reactions = m.reactions(m.commentLikes[c.ID], m.commentReactions[c.ID], userID)
+	return comment
+}
+
+func (m *memory) deletePost(postID int64) {
+	for id, c := range m.comments {
+		if c.PostID == postID {
+			delete(m.comments, id)
+			delete(m.commentLikes, id)
+			delete(m.commentReactions, id)
+		}
+	}
+	delete(m.posts, postID)
+	delete(m.postLikes, postID)
+	delete(m.postReactions, postID)
+	//The bookmarks, mentions and notifications of the comments point at the post as well
+	for key := range m.mentions {
+		if key.postID == postID {
+			delete(m.mentions, key)
+		}
+	}
+	for id, b := range m.bookmarks {
+		if b.postID == postID {
+			delete(m.bookmarks, id)
+		}
+	}
+	for id, n := range m.notifications {
+		if (n.PostID.Valid && n.PostID.Int64 == postID) || (n.CommentID.Valid && m.comments[n.CommentID.Int64] == nil) {
+			delete(m.notifications, id)
+		}
+	}
+}
+
+func (m *memoryPosts) GetByID(ctx context.Context, postID, userID int64) (*Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.posts[postID]
+	if !ok {
+		return nil, nil
+	}
+	post := m.post(p, userID)
+	return &post, nil
+}
+
+func (m *memoryPosts) GetAll(ctx context.Context, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) {
+	return m.list(nil, userID, opts, page)
+}
+
+func (m *memoryPosts) AllByTopicID(ctx context.Context, topicID, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) {
+	return m.list(func(p *memoryPost) bool { return p.TopicID == topicID }, userID, opts, page)
+}
+
+func (m *memoryPosts) Feed(ctx context.Context, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) {
+	return m.list(func(p *memoryPost) bool { return m.subscriptions[p.TopicID][userID] }, userID, opts, page)
+}
+
+// Matches the query anywhere in the title or content, a rough stand-in for the full text index
+func (m *memoryPosts) SearchPost(ctx context.Context, query string, page PageRequest) (Page[Post], error) {
+	return m.list(func(p *memoryPost) bool { return containsFold(p.Title, query) || containsFold(p.Content, query) }, 0, PostListOptions{Sort: SortNew}, page)
+}
+
+func (m *memoryPosts) list(match func(p *memoryPost) bool, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) {
+	now := time.Now().UTC()
+	if page.Cursor != nil && !page.Cursor.Now.IsZero() {
+		now = page.Cursor.Now
+	}
+	//Only the order of the keyset is used here, its SQL never runs
+	key := opts.keyset(database.MySQL, now)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	posts := []Post{}
+	for _, p := range m.posts {
+		if match != nil && !match(p) {
+			continue
+		}
+		if !opts.Since.IsZero() && p.CreatedAt.Before(opts.Since) {
+			continue
+		}
+		posts = append(posts, m.post(p, userID))
+	}
+	return memoryPage(posts, page, key, func(p Post) Cursor {
+		c := Cursor{Sort: opts.Sort, CreatedAt: p.CreatedAt, ID: p.ID, Now: now}
+		if key.ByScore {
+			c.Score = opts.score(p, now)
+		}
+		return c
+	}), nil
+}
+
+func (m *memoryPosts) Create(ctx context.Context, title, content string, topicID, userID int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.topics[topicID]; !ok {
+		return 0, fmt.Errorf("topic %d does not exist", topicID)
+	}
+	if _, ok := m.users[userID]; !ok {
+		return 0, fmt.Errorf("user %d does not exist", userID)
+	}
+	now := memoryNow()
+	p := &memoryPost{Post: Post{ID: m.nextID(), Title: title, Content: content, ContentHTML: markdown.Render(content),
+		CreatedAt: now, UpdatedAt: now, TopicID: topicID, UserID: userID}}
+	m.posts[p.ID] = p
+	m.saveMentions(p.ID, 0, userID, content)
+	return p.ID, nil
+}
+
+func (m *memoryPosts) Update(ctx context.Context, postID, editorID int64, title, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.posts[postID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	now := memoryNow()
+	p.revisions = append(p.revisions, PostRevision{PostID: postID, Revision: p.EditCount + 1, Title: p.Title, Content: p.Content, ContentHTML: p.ContentHTML,
+		EditorID: editorID, EditedAt: now})
+	p.Title = title
+	p.Content = content
+	p.ContentHTML = markdown.Render(content)
+	p.UpdatedAt = now
+	p.EditCount++
+	m.saveMentions(postID, 0, editorID, content)
+	return nil
+}
+
+func (m *memoryPosts) Delete(ctx context.Context, postID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deletePost(postID)
+	return nil
+}
+
+func (m *memoryPosts) LikePost(ctx context.Context, postID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.posts[postID]; ok {
+		setLike(m.postLikes, postID, userID, !m.postLikes[postID][userID])
+	}
+	return nil
+}
+
+func (m *memoryPosts) SetLike(ctx context.Context, postID, userID int64, liked bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.posts[postID] == nil || m.users[userID] == nil {
+		return false, nil
+	}
+	return setLike(m.postLikes, postID, userID, liked), nil
+}
+
+func (m *memoryPosts) LikeState(ctx context.Context, postID, userID int64) (*LikeState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.posts[postID]; !ok {
+		return nil, nil
+	}
+	return &LikeState{Likes: len(m.postLikes[postID]), Liked: m.postLikes[postID][userID]}, nil
+}
+
+func (m *memoryPosts) SetReaction(ctx context.Context, postID, userID int64, kind string, on bool) (bool, error) {
+	if kind == ReactionLike {
+		return m.SetLike(ctx, postID, userID, on)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.posts[postID] == nil || m.users[userID] == nil {
+		return false, nil
+	}
+	return setReaction(m.postReactions, postID, userID, kind, on), nil
+}
+
+func (m *memoryPosts) Reactions(ctx context.Context, postID, userID int64) (Reactions, error) {
+	post, err := m.GetByID(ctx, postID, userID)
+	if err != nil || post == nil {
+		return Reactions{}, err
+	}
+	return post.Reactions, nil
+}
+
+func (m *memoryPosts) Revisions(ctx context.Context, postID int64) ([]PostRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revisions := []PostRevision{}
+	if p, ok := m.posts[postID]; ok {
+		for _, r := range p.revisions {
+			r.EditorUsername = m.username(r.EditorID)
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions, nil
+}
+
+func (m *memoryPosts) GetRevision(ctx context.Context, postID int64, revision int) (*PostRevision, error) {
+	revisions, err := m.Revisions(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revisions {
+		if r.Revision == revision {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryTopics) GetByID(ctx context.Context, topicID, userID int64) (*Topic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.topics[topicID]
+	if !ok {
+		return nil, nil
+	}
+	topic := m.topic(t, userID)
+	topic.PostCount = 0 //Like the database store, only the listings count posts
+	return &topic, nil
+}
+
+func (m *memory) topic(t *Topic, userID int64) Topic {
+	topic := *t
+	topic.CreatedByUsername = m.username(t.UserID)
+	for _, p := range m.posts {
+		if p.TopicID == t.ID {
+			topic.PostCount++
+		}
+	}
+	topic.SubscriberCount = int64(len(m.subscriptions[t.ID]))
+	topic.Subscribed = m.subscriptions[t.ID][userID]
+	return topic
+}
+
+func (m *memoryTopics) All(ctx context.Context, userID int64, page PageRequest) (Page[Topic], error) {
+	return m.list(nil, userID, page)
+}
+
+func (m *memoryTopics) SearchTopic(ctx context.Context, query string, userID int64, page PageRequest) (Page[Topic], error) {
+	return m.list(func(t *Topic) bool { return containsFold(t.Title, query) || containsFold(t.Description, query) }, userID, page)
+}
+
+func (m *memoryTopics) list(match func(t *Topic) bool, userID int64, page PageRequest) (Page[Topic], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	topics := []Topic{}
+	for _, t := range m.topics {
+		if match == nil || match(t) {
+			topics = append(topics, m.topic(t, userID))
+		}
+	}
+	return memoryPage(topics, page, keyset{}, func(t Topic) Cursor {
+		return Cursor{CreatedAt: t.CreatedAt, ID: t.ID}
+	}), nil
+}
+
+// Topic titles are unique, excludeID is the topic being renamed
+func (m *memoryTopics) titleTaken(title string, excludeID int64) bool {
+	for _, t := range m.topics {
+		if t.ID != excludeID && strings.EqualFold(t.Title, title) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryTopics) Create(ctx context.Context, title, description string, createdBy int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[createdBy]; !ok {
+		return 0, fmt.Errorf("user %d does not exist", createdBy)
+	}
+	if m.titleTaken(title, 0) {
+		return 0, fmt.Errorf("duplicate topic title %q", title)
+	}
+	t := &Topic{ID: m.nextID(), Title: title, Description: description, CreatedAt: memoryNow(), UserID: createdBy}
+	m.topics[t.ID] = t
+	return t.ID, nil
+}
+
+func (m *memoryTopics) Update(ctx context.Context, topicID int64, title, description string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.topics[topicID]
+	if !ok {
+		return nil
+	}
+	if m.titleTaken(title, topicID) {
+		return fmt.Errorf("duplicate topic title %q", title)
+	}
+	t.Title = title
+	t.Description = description
+	return nil
+}
+
+func (m *memoryTopics) Delete(ctx context.Context, topicID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, p := range m.posts {
+		if p.TopicID == topicID {
+			m.deletePost(id)
+		}
+	}
+	for id, w := range m.webhooks {
+		if w.TopicID.Valid && w.TopicID.Int64 == topicID {
+			m.deleteWebhook(id)
+		}
+	}
+	delete(m.topics, topicID)
+	delete(m.moderators, topicID)
+	delete(m.subscriptions, topicID)
+	return nil
+}
+
+func (m *memoryTopics) IsModerator(ctx context.Context, topicID, userID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Contains(m.moderators[topicID], userID), nil
+}
+
+func (m *memoryTopics) AddModerator(ctx context.Context, topicID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.topics[topicID] != nil && m.users[userID] != nil && !slices.Contains(m.moderators[topicID], userID) {
+		m.moderators[topicID] = append(m.moderators[topicID], userID)
+	}
+	return nil
+}
+
+func (m *memoryTopics) RemoveModerator(ctx context.Context, topicID, userID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.Index(m.moderators[topicID], userID)
+	if i < 0 {
+		return false, nil
+	}
+	m.moderators[topicID] = slices.Delete(slices.Clone(m.moderators[topicID]), i, i+1)
+	return true, nil
+}
+
+func (m *memoryTopics) Moderators(ctx context.Context, topicID int64) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := []User{}
+	for _, id := range m.moderators[topicID] {
+		u := *m.users[id]
+		u.PasswordHash = sql.NullString{}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (m *memoryTopics) Subscribe(ctx context.Context, topicID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.topics[topicID] != nil && m.users[userID] != nil {
+		setLike(m.subscriptions, topicID, userID, true)
+	}
+	return nil
+}
+
+func (m *memoryTopics) Unsubscribe(ctx context.Context, topicID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	setLike(m.subscriptions, topicID, userID, false)
+	return nil
+}
+
+func (m *memoryComments) GetByID(ctx context.Context, commentID int64) (*Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.comments[commentID]
+	if !ok {
+		return nil, nil
+	}
+	//Like the database store, a single comment comes without the viewer's like and the reactions
+	comment := m.comment(c, 0)
+	comment.Reactions = Reactions{}
+	return &comment, nil
+}
+
+// Oldest first, ties broken by ID
+func sortComments(comments []*memoryComment) {
+	slices.SortFunc(comments, func(a, b *memoryComment) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+func (m *memoryComments) AllByPostID(ctx context.Context, postID, userID int64, page PageRequest) (Page[Comment], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	comments := []Comment{}
+	for _, c := range m.comments {
+		if c.PostID == postID {
+			comments = append(comments, m.comment(c, userID))
+		}
+	}
+	return memoryPage(comments, page, keyset{Asc: true}, func(c Comment) Cursor {
+		return Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+	}), nil
+}
+
+// Builds the tree level by level, keeping the first limit+1 replies of every comment like CommentDB.Tree does
+func (m *memoryComments) Tree(ctx context.Context, postID int64, parentID sql.NullInt64, userID int64, maxDepth, limit int, after *Cursor) ([]*CommentNode, *Cursor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var level []*memoryComment
+	for _, c := range m.comments {
+		if parentID.Valid {
+			if c.ParentCommentID != parentID {
+				continue
+			}
+		} else if c.PostID != postID || c.ParentCommentID.Valid {
+			continue
+		}
+		if after != nil && !(c.CreatedAt.After(after.CreatedAt) || (c.CreatedAt.Equal(after.CreatedAt) && c.ID > after.ID)) {
+			continue
+		}
+		level = append(level, c)
+	}
+	roots := []*CommentNode{}
+	var next *Cursor
+	nodes := map[int64]*CommentNode{}
+	for depth := 0; len(level) > 0; depth++ {
+		sortComments(level)
+		rn := map[int64]int{}
+		var loaded []*CommentNode
+		for _, c := range level {
+			rn[c.ParentCommentID.Int64]++
+			if rn[c.ParentCommentID.Int64] > limit {
+				if depth == 0 {
+					last := roots[len(roots)-1]
+					next = &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+				}
+				continue
+			}
+			n := &CommentNode{Comment: m.comment(c, userID), Depth: depth, Replies: []*CommentNode{}}
+			for _, r := range m.comments {
+				if r.ParentCommentID.Valid && r.ParentCommentID.Int64 == c.ID {
+					n.ReplyCount++
+				}
+			}
+			if depth == 0 {
+				roots = append(roots, n)
+			} else {
+				parent := nodes[c.ParentCommentID.Int64]
+				parent.Replies = append(parent.Replies, n)
+			}
+			nodes[n.ID] = n
+			loaded = append(loaded, n)
+		}
+		if depth >= maxDepth-1 {
+			break
+		}
+		level = nil
+		for _, c := range m.comments {
+			if c.ParentCommentID.Valid && slices.ContainsFunc(loaded, func(n *CommentNode) bool { return n.ID == c.ParentCommentID.Int64 }) {
+				level = append(level, c)
+			}
+		}
+	}
+	for _, n := range nodes {
+		if len(n.Replies) < n.ReplyCount {
+			n.HasMoreReplies = true
+			if len(n.Replies) > 0 {
+				last := n.Replies[len(n.Replies)-1]
+				n.MoreRepliesCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+			}
+		}
+	}
+	return roots, next, nil
+}
+
+func (m *memoryComments) Ancestors(ctx context.Context, commentID, userID int64, levels int) ([]Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	comments := []Comment{}
+	c, ok := m.comments[commentID]
+	for i := 0; ok && i < levels && c.ParentCommentID.Valid; i++ {
+		if c, ok = m.comments[c.ParentCommentID.Int64]; ok {
+			comments = append(comments, m.comment(c, userID))
+		}
+	}
+	slices.Reverse(comments)
+	return comments, nil
+}
+
+func (m *memoryComments) Create(ctx context.Context, postID int64, userID int64, content string, parentCommentID sql.NullInt64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.posts[postID]; !ok {
+		return 0, fmt.Errorf("post %d does not exist", postID)
+	}
+	if _, ok := m.users[userID]; !ok {
+		return 0, fmt.Errorf("user %d does not exist", userID)
+	}
+	if _, ok := m.comments[parentCommentID.Int64]; parentCommentID.Valid && !ok {
+		return 0, fmt.Errorf("comment %d does not exist", parentCommentID.Int64)
+	}
+	now := memoryNow()
+	c := &memoryComment{Comment: Comment{ID: m.nextID(), Content: content, ContentHTML: markdown.Render(content), CreatedAt: now, UpdatedAt: now,
+		PostID: postID, UserID: userID, ParentCommentID: parentCommentID}}
+	m.comments[c.ID] = c
+	m.saveMentions(postID, c.ID, userID, content)
+	return c.ID, nil
+}
+
+// Keeps the current content as a revision and applies the change, like CommentDB.revise
+func (m *memoryComments) revise(commentID, editorID int64, action string, change func(c *memoryComment)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.comments[commentID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if c.Deleted {
+		return ErrCommentDeleted
+	}
+	c.addRevision(editorID, action, c.Content, "")
+	change(c)
+	return nil
+}
+
+func (c *memoryComment) addRevision(editorID int64, action, content, reason string) {
+	c.revisions = append(c.revisions, CommentRevision{CommentID: c.ID, Revision: len(c.revisions) + 1, Action: action, Content: content, Reason: reason,
+		EditorID: editorID, CreatedAt: memoryNow()})
+}
+
+func (m *memoryComments) Update(ctx context.Context, commentID, editorID int64, content string) error {
+	return m.revise(commentID, editorID, CommentEdited, func(c *memoryComment) {
+		c.Content = content
+		c.ContentHTML = markdown.Render(content)
+		c.UpdatedAt = memoryNow()
+		m.saveMentions(c.PostID, c.ID, editorID, content)
+	})
+}
+
+func (m *memoryComments) Delete(ctx context.Context, commentID, editorID int64) error {
+	return m.revise(commentID, editorID, CommentDeleted, func(c *memoryComment) {
+		c.Deleted = true
+	})
+}
+
+func (m *memoryComments) Redact(ctx context.Context, commentID, editorID int64, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.comments[commentID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	c.addRevision(editorID, CommentRedacted, "", reason)
+	for i := range c.revisions {
+		c.revisions[i].Content = ""
+		c.revisions[i].Redacted = true
+	}
+	c.Content = ""
+	c.ContentHTML = ""
+	c.Deleted = true
+	for _, d := range m.deliveries {
+		if d.CommentID.Valid && d.CommentID.Int64 == commentID {
+			d.Payload = redactedCommentPayload(d.Payload, commentID)
+		}
+	}
+	return nil
+}
+
+func (m *memoryComments) Revisions(ctx context.Context, commentID int64) ([]CommentRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revisions := []CommentRevision{}
+	if c, ok := m.comments[commentID]; ok {
+		for _, r := range c.revisions {
+			r.EditorUsername = m.username(r.EditorID)
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions, nil
+}
+
+func (m *memoryComments) LikeComment(ctx context.Context, commentID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.comments[commentID]; ok {
+		setLike(m.commentLikes, commentID, userID, !m.commentLikes[commentID][userID])
+	}
+	return nil
+}
+
+func (m *memoryComments) SetLike(ctx context.Context, commentID, userID int64, liked bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.comments[commentID] == nil || m.users[userID] == nil {
+		return false, nil
+	}
+	return setLike(m.commentLikes, commentID, userID, liked), nil
+}
+
+func (m *memoryComments) LikeState(ctx context.Context, commentID, userID int64) (*LikeState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.comments[commentID]; !ok {
+		return nil, nil
+	}
+	return &LikeState{Likes: len(m.commentLikes[commentID]), Liked: m.commentLikes[commentID][userID]}, nil
+}
+
+func (m *memoryComments) SetReaction(ctx context.Context, commentID, userID int64, kind string, on bool) (bool, error) {
+	if kind == ReactionLike {
+		return m.SetLike(ctx, commentID, userID, on)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.comments[commentID] == nil || m.users[userID] == nil {
+		return false, nil
+	}
+	return setReaction(m.commentReactions, commentID, userID, kind, on), nil
+}
+
+func (m *memoryComments) Reactions(ctx context.Context, commentID, userID int64) (Reactions, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.comments[commentID]
+	if !ok {
+		return Reactions{}, nil
+	}
+	return m.comment(c, userID).Reactions, nil
+}
+
+func (m *memoryUsers) GetByID(ctx context.Context, userID int64) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	user := *u
+	return &user, nil
+}
+
+// Usernames compare case insensitively, like the unique index on them
+func (m *memoryUsers) GetByUsername(ctx context.Context, username string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.byUsername(username); u != nil {
+		user := *u
+		return &user, nil
+	}
+	return nil, nil
+}
+
+func (m *memory) byUsername(username string) *User {
+	for _, u := range m.users {
+		if strings.EqualFold(u.Username, username) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (m *memoryUsers) Create(ctx context.Context, username, passwordHash string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.byUsername(username) != nil {
+		return 0, fmt.Errorf("duplicate username %q", username)
+	}
+	u := &User{ID: m.nextID(), Username: username, CreatedAt: memoryNow(), Role: RoleUser, PasswordHash: sql.NullString{String: passwordHash, Valid: passwordHash != ""}}
+	m.users[u.ID] = u
+	return u.ID, nil
+}
+
+// Fails for users that still own topics, posts, comments or revisions, their likes, reactions and subscriptions go with them
+func (m *memoryUsers) Delete(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.topics {
+		if t.UserID == userID {
+			return fmt.Errorf("user %d still owns topic %d", userID, t.ID)
+		}
+	}
+	for _, p := range m.posts {
+		if p.UserID == userID || slices.ContainsFunc(p.revisions, func(r PostRevision) bool { return r.EditorID == userID }) {
+			return fmt.Errorf("user %d still owns post %d", userID, p.ID)
+		}
+	}
+	for _, c := range m.comments {
+		if c.UserID == userID || slices.ContainsFunc(c.revisions, func(r CommentRevision) bool { return r.EditorID == userID }) {
+			return fmt.Errorf("user %d still owns comment %d", userID, c.ID)
+		}
+	}
+	for _, likes := range []map[int64]map[int64]bool{m.postLikes, m.commentLikes, m.subscriptions} {
+		for _, users := range likes {
+			delete(users, userID)
+		}
+	}
+	for _, reactions := range []map[int64]map[int64][]string{m.postReactions, m.commentReactions} {
+		for _, users := range reactions {
+			delete(users, userID)
+		}
+	}
+	for topicID, moderators := range m.moderators {
+		m.moderators[topicID] = slices.DeleteFunc(slices.Clone(moderators), func(id int64) bool { return id == userID })
+	}
+	delete(m.setupTokens, userID)
+	for id, s := range m.sessions {
+		if s.UserID == userID {
+			m.deleteSession(id)
+		}
+	}
+	for id, b := range m.bookmarks {
+		if b.userID == userID {
+			delete(m.bookmarks, id)
+		}
+	}
+	for id, n := range m.notifications {
+		if n.UserID == userID {
+			delete(m.notifications, id)
+		} else if n.ActorID.Valid && n.ActorID.Int64 == userID {
+			n.ActorID = sql.NullInt64{}
+		}
+	}
+	for id, w := range m.webhooks {
+		if w.CreatedBy == userID {
+			m.deleteWebhook(id)
+		}
+	}
+	delete(m.users, userID)
+	return nil
+}
+
+func (m *memoryUsers) CreatePasswordSetupToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[userID]
+	if !ok || u.PasswordHash.Valid {
+		return false, nil
+	}
+	m.setupTokens[userID] = memorySetupToken{hash: tokenHash, expiresAt: expiresAt}
+	return true, nil
+}
+
+func (m *memoryUsers) CheckPasswordSetupToken(ctx context.Context, userID int64, tokenHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.validSetupToken(userID, tokenHash), nil
+}
+
+func (m *memory) validSetupToken(userID int64, tokenHash string) bool {
+	token, ok := m.setupTokens[userID]
+	return ok && token.hash == tokenHash && time.Now().Before(token.expiresAt)
+}
+
+func (m *memoryUsers) SetInitialPassword(ctx context.Context, userID int64, tokenHash, passwordHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[userID]
+	if !ok || u.PasswordHash.Valid || !m.validSetupToken(userID, tokenHash) {
+		return false, nil
+	}
+	delete(m.setupTokens, userID)
+	u.PasswordHash = sql.NullString{String: passwordHash, Valid: true}
+	return true, nil
+}
+
+func (m *memoryUsers) SetRole(ctx context.Context, userID int64, role string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[userID]
+	if !ok {
+		return false, nil
+	}
+	u.Role = role
+	return true, nil
+}
+
+func (m *memoryUsers) Autocomplete(ctx context.Context, prefix string, limit int) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := []User{}
+	for _, u := range m.users {
+		if strings.HasPrefix(strings.ToLower(u.Username), strings.ToLower(prefix)) {
+			user := *u
+			user.PasswordHash = sql.NullString{}
+			users = append(users, user)
+		}
+	}
+	slices.SortFunc(users, func(a, b User) int {
+		if c := cmp.Compare(utf8.RuneCountInString(a.Username), utf8.RuneCountInString(b.Username)); c != 0 {
+			return c
+		}
+		return cmp.Compare(strings.ToLower(a.Username), strings.ToLower(b.Username))
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (m *memorySessions) Create(ctx context.Context, sessionID string, userID int64, userAgent, ipAddress string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d does not exist", userID)
+	}
+	if _, ok := m.sessions[sessionID]; ok {
+		return fmt.Errorf("duplicate session %q", sessionID)
+	}
+	now := memoryNow()
+	m.sessions[sessionID] = &memorySession{Session: Session{ID: sessionID, UserID: userID, CreatedAt: now, LastSeenAt: now, UserAgent: userAgent, IPAddress: ipAddress}}
+	return nil
+}
+
+func (m *memorySessions) IsActive(ctx context.Context, sessionID string, userID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok || s.UserID != userID || s.revoked {
+		return false, nil
+	}
+	s.LastSeenAt = memoryNow()
+	return true, nil
+}
+
+func (m *memorySessions) AllByUserID(ctx context.Context, userID int64) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := []Session{}
+	for _, s := range m.sessions {
+		if s.UserID == userID && !s.revoked {
+			sessions = append(sessions, s.Session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b Session) int {
+		if c := b.LastSeenAt.Compare(a.LastSeenAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return sessions, nil
+}
+
+func (m *memorySessions) Revoke(ctx context.Context, sessionID string, userID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok || s.UserID != userID || s.revoked {
+		return false, nil
+	}
+	s.revoked = true
+	return true, nil
+}
+
+func (m *memory) deleteSession(sessionID string) {
+	for hash, t := range m.refreshTokens {
+		if t.sessionID == sessionID {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	delete(m.sessions, sessionID)
+}
+
+func (m *memoryRefreshTokens) Create(ctx context.Context, sessionID, tokenHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addRefreshToken(sessionID, tokenHash, expiresAt)
+}
+
+func (m *memory) addRefreshToken(sessionID, tokenHash string, expiresAt time.Time) error {
+	if _, ok := m.sessions[sessionID]; !ok {
+		return fmt.Errorf("session %q does not exist", sessionID)
+	}
+	if _, ok := m.refreshTokens[tokenHash]; ok {
+		return fmt.Errorf("duplicate refresh token")
+	}
+	m.refreshTokens[tokenHash] = &memoryRefreshToken{sessionID: sessionID, expiresAt: expiresAt.UTC()}
+	return nil
+}
+
+// Works like RefreshTokenDB.Rotate, a replayed token revokes its session
+func (m *memoryRefreshTokens) Rotate(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (string, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.refreshTokens[oldHash]
+	if !ok {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+	s := m.sessions[t.sessionID]
+	if t.used {
+		s.revoked = true
+		return "", 0, ErrRefreshTokenReused
+	}
+	if s.revoked || time.Now().After(t.expiresAt) {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+	if err := m.addRefreshToken(s.ID, newHash, expiresAt); err != nil {
+		return "", 0, err
+	}
+	t.used = true
+	s.LastSeenAt = memoryNow()
+	return s.ID, s.UserID, nil
+}
+
+func (m *memoryRefreshTokens) GetSession(ctx context.Context, tokenHash string) (string, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return "", 0, nil
+	}
+	return t.sessionID, m.sessions[t.sessionID].UserID, nil
+}
+
+// Returns the bookmark of a post (commentID 0) or comment, nil if the user hasn't saved it
+func (m *memory) bookmark(userID, postID, commentID int64) *memoryBookmark {
+	for _, b := range m.bookmarks {
+		if b.userID == userID && b.postID == postID && b.commentID == commentID {
+			return b
+		}
+	}
+	return nil
+}
+
+func (m *memory) addBookmark(userID, postID, commentID int64) {
+	if m.bookmark(userID, postID, commentID) == nil {
+		b := &memoryBookmark{id: m.nextID(), userID: userID, postID: postID, commentID: commentID, createdAt: memoryNow()}
+		m.bookmarks[b.id] = b
+	}
+}
+
+func (m *memory) removeBookmark(userID, postID, commentID int64) {
+	if b := m.bookmark(userID, postID, commentID); b != nil {
+		delete(m.bookmarks, b.id)
+	}
+}
+
+func (m *memoryBookmarks) AddPost(ctx context.Context, postID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.posts[postID]; !ok {
+		return fmt.Errorf("post %d does not exist", postID)
+	}
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d does not exist", userID)
+	}
+	m.addBookmark(userID, postID, 0)
+	return nil
+}
+
+// Like the database store, bookmarking a comment that doesn't exist does nothing
+func (m *memoryBookmarks) AddComment(ctx context.Context, commentID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.comments[commentID]
+	if !ok {
+		return nil
+	}
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d does not exist", userID)
+	}
+	m.addBookmark(userID, c.PostID, commentID)
+	return nil
+}
+
+func (m *memoryBookmarks) RemovePost(ctx context.Context, postID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeBookmark(userID, postID, 0)
+	return nil
+}
+
+func (m *memoryBookmarks) RemoveComment(ctx context.Context, commentID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.comments[commentID]; ok {
+		m.removeBookmark(userID, c.PostID, commentID)
+	}
+	return nil
+}
+
+func (m *memoryBookmarks) All(ctx context.Context, userID int64, filter BookmarkFilter, page PageRequest) (Page[Bookmark], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bookmarks := []Bookmark{}
+	for _, b := range m.bookmarks {
+		p := m.posts[b.postID]
+		if b.userID != userID || (filter.TopicID > 0 && p.TopicID != filter.TopicID) {
+			continue
+		}
+		if (filter.Type == BookmarkPost && b.commentID != 0) || (filter.Type == BookmarkComment && b.commentID == 0) {
+			continue
+		}
+		bookmark := Bookmark{ID: b.id, Type: BookmarkPost, CreatedAt: b.createdAt, Post: m.post(p, userID)}
+		if b.commentID != 0 {
+			comment := m.comment(m.comments[b.commentID], userID)
+			bookmark.Type = BookmarkComment
+			bookmark.Comment = &comment
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return memoryPage(bookmarks, page, keyset{}, func(b Bookmark) Cursor {
+		return Cursor{CreatedAt: b.CreatedAt, ID: b.ID}
+	}), nil
+}
+
+// Users are never notified about their own actions, like notifications.Create
+func (m *memoryNotifications) Create(ctx context.Context, n notifications.Notification) error {
+	if n.ActorID.Valid && n.ActorID.Int64 == n.UserID {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[n.UserID]; !ok {
+		return fmt.Errorf("user %d does not exist", n.UserID)
+	}
+	m.notify(n)
+	return nil
+}
+
+// Stores a notification and tells the listener, which runs with the memory locked
+func (m *memory) notify(n notifications.Notification) {
+	n.ID = m.nextID()
+	n.ActorUsername = ""
+	n.Read = false
+	n.CreatedAt = memoryNow()
+	stored := n
+	m.notifications[n.ID] = &stored
+	if m.listener != nil {
+		m.listener(n)
+	}
+}
+
+// Replaces the mentions of a post (commentID 0) or comment and notifies the users that weren't mentioned before, like saveMentions
+func (m *memory) saveMentions(postID, commentID, authorID int64, content string) {
+	userIDs, _ := mentionedUsers(content, authorID, func(name string) (*User, error) {
+		return m.byUsername(name), nil
+	})
+	key := memoryMention{postID: postID, commentID: commentID}
+	mentioned := map[int64]bool{}
+	for _, userID := range userIDs {
+		mentioned[userID] = true
+		if m.mentions[key][userID] {
+			continue
+		}
+		m.notify(notifications.Notification{
+			UserID:    userID,
+			Kind:      notifications.KindMention,
+			ActorID:   sql.NullInt64{Int64: authorID, Valid: true},
+			PostID:    sql.NullInt64{Int64: postID, Valid: true},
+			CommentID: sql.NullInt64{Int64: commentID, Valid: commentID != 0},
+		})
+	}
+	m.mentions[key] = mentioned
+}
+
+func (m *memoryNotifications) List(ctx context.Context, userID int64, unreadOnly bool, limit int, cursor *notifications.Cursor) (notifications.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev := cursor != nil && cursor.Prev
+	items := []notifications.Notification{}
+	for _, n := range m.notifications {
+		if n.UserID != userID || (unreadOnly && n.Read) {
+			continue
+		}
+		if cursor != nil && ((prev && n.ID <= cursor.ID) || (!prev && n.ID >= cursor.ID)) {
+			continue
+		}
+		item := *n
+		item.ActorUsername = m.username(n.ActorID.Int64)
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b notifications.Notification) int {
+		if prev {
+			return cmp.Compare(a.ID, b.ID)
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	if len(items) > limit+1 {
+		items = items[:limit+1]
+	}
+	return notifications.NewPage(items, limit, cursor), nil
+}
+
+func (m *memoryNotifications) MarkRead(ctx context.Context, userID int64, ids []int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var marked int64
+	for _, n := range m.notifications {
+		if n.UserID == userID && !n.Read && (len(ids) == 0 || slices.Contains(ids, n.ID)) {
+			n.Read = true
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+func (m *memoryNotifications) UnreadCount(ctx context.Context, userID int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, n := range m.notifications {
+		if n.UserID == userID && !n.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryWebhooks) Create(ctx context.Context, url, secret string, events []string, topicID sql.NullInt64, createdBy int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[createdBy]; !ok {
+		return 0, fmt.Errorf("user %d does not exist", createdBy)
+	}
+	if _, ok := m.topics[topicID.Int64]; topicID.Valid && !ok {
+		return 0, fmt.Errorf("topic %d does not exist", topicID.Int64)
+	}
+	w := &Webhook{ID: m.nextID(), URL: url, Events: slices.Clone(events), Secret: secret, TopicID: topicID, CreatedBy: createdBy, CreatedAt: memoryNow()}
+	m.webhooks[w.ID] = w
+	return w.ID, nil
+}
+
+func (m *memoryWebhooks) Delete(ctx context.Context, webhookID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteWebhook(webhookID)
+	return nil
+}
+
+func (m *memory) deleteWebhook(webhookID int64) {
+	for id, d := range m.deliveries {
+		if d.WebhookID == webhookID {
+			delete(m.deliveries, id)
+		}
+	}
+	delete(m.webhooks, webhookID)
+}
+
+func (m *memoryWebhooks) GetByID(ctx context.Context, webhookID int64) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.webhooks[webhookID]
+	if !ok {
+		return nil, nil
+	}
+	webhook := *w
+	webhook.Events = slices.Clone(w.Events)
+	return &webhook, nil
+}
+
+func (m *memoryWebhooks) All(ctx context.Context, ownerID int64, page PageRequest) (Page[Webhook], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhooks := []Webhook{}
+	for _, w := range m.webhooks {
+		if ownerID > 0 && (!w.TopicID.Valid || m.topics[w.TopicID.Int64].UserID != ownerID) {
+			continue
+		}
+		webhook := *w
+		webhook.Events = slices.Clone(w.Events)
+		webhook.Secret = ""
+		webhooks = append(webhooks, webhook)
+	}
+	return memoryPage(webhooks, page, keyset{}, func(w Webhook) Cursor {
+		return Cursor{CreatedAt: w.CreatedAt, ID: w.ID}
+	}), nil
+}
+
+func (m *memoryWebhooks) Matching(ctx context.Context, event string, topicID int64) ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var webhooks []Webhook
+	for _, w := range m.webhooks {
+		if (!w.TopicID.Valid || w.TopicID.Int64 == topicID) && w.Wants(event) {
+			webhook := *w
+			webhook.Events = slices.Clone(w.Events)
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b Webhook) int { return cmp.Compare(a.ID, b.ID) })
+	return webhooks, nil
+}
+
+func (m *memoryWebhooks) Enqueue(ctx context.Context, webhookID int64, event string, payload []byte, commentID sql.NullInt64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[webhookID]; !ok {
+		return fmt.Errorf("webhook %d does not exist", webhookID)
+	}
+	now := memoryNow()
+	d := &WebhookDelivery{ID: m.nextID(), WebhookID: webhookID, Event: event, Payload: slices.Clone(payload), CommentID: commentID,
+		Status: DeliveryPending, NextAttemptAt: now, CreatedAt: now}
+	m.deliveries[d.ID] = d
+	return nil
+}
+
+func (m *memoryWebhooks) Deliveries(ctx context.Context, webhookID int64, page PageRequest) (Page[WebhookDelivery], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deliveries := []WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	return memoryPage(deliveries, page, keyset{}, func(d WebhookDelivery) Cursor {
+		return Cursor{CreatedAt: d.CreatedAt, ID: d.ID}
+	}), nil
+}
+
+func (m *memoryWebhooks) GetDelivery(ctx context.Context, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[deliveryID]
+	if !ok || d.WebhookID != webhookID {
+		return nil, nil
+	}
+	delivery := *d
+	return &delivery, nil
+}
+
+// Nothing ever sends the memory deliveries, so there are no attempts to list
+func (m *memoryWebhooks) Attempts(ctx context.Context, deliveryID int64) ([]WebhookAttempt, error) {
+	return []WebhookAttempt{}, nil
+}
+
+func (m *memoryWebhooks) Retry(ctx context.Context, deliveryID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[deliveryID]
+	if !ok || d.Status != DeliveryDead {
+		return false, nil
+	}
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = memoryNow()
+	return true, nil
+}
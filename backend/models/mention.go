@@ -0,0 +1,137 @@
+package models
+
+import (
+	"backend/notifications"
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// Only the first few distinct mentions of a post or comment are looked up, so a wall of @s can't spam everyone
+const maxMentions = 20
+
+var (
+	//An @ at the start of the content or after whitespace or an opening bracket, followed by the username.
+	//Usernames can be any run of non whitespace characters, see UserHandler.Create.
+	mentionPattern = regexp.MustCompile(`(?:^|[\s(\[])@([^\s@]+)`)
+	//Mentions inside code are not mentions
+	fencedCodePattern = regexp.MustCompile("(?s)```.*?(?:```|$)")
+	inlineCodePattern = regexp.MustCompile("`[^`\n]*`")
+)
+
+// Returns the distinct @username tokens in Markdown content, in the order they appear
+func parseMentions(content string) []string {
+	content = fencedCodePattern.ReplaceAllString(content, " ")
+	content = inlineCodePattern.ReplaceAllString(content, " ")
+	var names []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+// Looks up the users mentioned in content, skipping names that don't exist and the author themselves.
+// "@username," also matches username, punctuation right after a mention is only kept if it is part of a real username.
+func resolveMentions(ctx context.Context, db *sql.DB, content string, authorID int64) ([]int64, error) {
+	UserDB := UserDB{DB: db}
+	return mentionedUsers(content, authorID, func(name string) (*User, error) {
+		return UserDB.GetByUsername(ctx, name)
+	})
+}
+
+// resolveMentions with the users looked up by lookup, which returns nil for names that don't exist
+func mentionedUsers(content string, authorID int64, lookup func(name string) (*User, error)) ([]int64, error) {
+	var userIDs []int64
+	seen := map[int64]bool{}
+	for _, name := range parseMentions(content) {
+		user, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		if trimmed := strings.TrimRight(name, ".,;:!?)]}'\"*_~|>"); user == nil && trimmed != name && trimmed != "" {
+			if user, err = lookup(trimmed); err != nil {
+				return nil, err
+			}
+		}
+		if user == nil || user.ID == authorID || seen[user.ID] {
+			continue
+		}
+		seen[user.ID] = true
+		userIDs = append(userIDs, user.ID)
+	}
+	return userIDs, nil
+}
+
+// Replaces the stored mentions of a post (commentID not valid) or of a comment with userIDs.
+// Only users that were not mentioned before are notified, so fixing a typo doesn't notify everyone again.
+func saveMentions(ctx context.Context, tx *hookedTx, postID int64, commentID sql.NullInt64, authorID int64, userIDs []int64) error {
+	var rows *sql.Rows
+	var err error
+	if commentID.Valid {
+		rows, err = tx.QueryContext(ctx, "SELECT user_id FROM mentions WHERE comment_id = ?", commentID)
+	} else {
+		rows, err = tx.QueryContext(ctx, "SELECT user_id FROM mentions WHERE post_id = ? AND comment_id IS NULL", postID)
+	}
+	if err != nil {
+		return err
+	}
+	existing := map[int64]bool{}
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[userID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	mentioned := map[int64]bool{}
+	for _, userID := range userIDs {
+		mentioned[userID] = true
+		if existing[userID] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO mentions (user_id, author_id, post_id, comment_id) VALUES (?, ?, ?, ?)", userID, authorID, postID, commentID); err != nil {
+			return err
+		}
+		err := notifications.Create(ctx, tx, notifications.Notification{
+			UserID:    userID,
+			Kind:      notifications.KindMention,
+			ActorID:   sql.NullInt64{Int64: authorID, Valid: true},
+			PostID:    sql.NullInt64{Int64: postID, Valid: true},
+			CommentID: commentID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	//Mentions that were edited out are removed, the table always matches the current content
+	for userID := range existing {
+		if mentioned[userID] {
+			continue
+		}
+		if commentID.Valid {
+			_, err = tx.ExecContext(ctx, "DELETE FROM mentions WHERE comment_id = ? AND user_id = ?", commentID, userID)
+		} else {
+			_, err = tx.ExecContext(ctx, "DELETE FROM mentions WHERE post_id = ? AND comment_id IS NULL AND user_id = ?", postID, userID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
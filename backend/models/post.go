@@ -1,6 +1,10 @@
 package models
 
 import (
+	"backend/database"
+	"backend/markdown"
+	"backend/notifications"
+	"context"
 	"database/sql"
 	"time"
 )
@@ -10,9 +14,17 @@ type Post struct { //Post Class, holds important attributes in our Post object
 	Title string `json:"title"`
 
 	Content string `json:"content"`
+	//Content rendered from Markdown and sanitized, cached in the database for the current revision
+	ContentHTML string `json:"content_html"`
 
 	Likes int `json:"likes"`
 
+	CommentCount int `json:"comment_count"`
+
+	//Number of times the post was edited, every edit is kept in post_revisions
+	EditCount int  `json:"edit_count"`
+	Edited    bool `json:"edited"`
+
 	CreatedAt time.Time `json:"created_at"`
 
 	UpdatedAt time.Time `json:"updated_at"`
@@ -25,155 +37,304 @@ type Post struct { //Post Class, holds important attributes in our Post object
 	TopicTitle        string `json:"topic_title"`
 	CreatedByUsername string `json:"username"`
 	LikedByUser       bool   `json:"liked_by_user"`
+	BookmarkedByUser  bool   `json:"bookmarked_by_user"`
+	//Counts per reaction kind and the viewer's own reactions, likes included
+	Reactions Reactions `json:"reactions"`
 }
 
 type PostDB struct {
 	DB *sql.DB
+	//Told about the notifications likes and mentions create, can be nil
+	Listener notifications.Listener
 }
 
-func (m *PostDB) AllByTopicID(topicID int64) ([]Post, error) { //Selects all the posts under a specific topic
-	rows, err := m.DB.Query("SELECT p.id, p.title, p.content, p.created_at, p.updated_at, p.topic_id, p.user_id, u.username FROM posts p join users u on p.user_id = u.id WHERE p.topic_id = ?", topicID)
+func (m *PostDB) AllByTopicID(ctx context.Context, topicID, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) { //Selects the posts under a specific topic
+	return m.list(ctx, "p.topic_id = ?", []any{topicID}, userID, opts, page)
+}
+func (m *PostDB) Create(ctx context.Context, title, content string, topicID, userID int64) (int64, error) { //Creates a new Post
+	mentioned, err := resolveMentions(ctx, m.DB, content, userID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
-	var posts []Post
-	for rows.Next() { //Ensure that the rows retrieved from the backend matches our Post object attributes
-		var p Post
-		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.CreatedAt, &p.UpdatedAt, &p.TopicID, &p.UserID, &p.CreatedByUsername); err != nil {
-			return nil, err
-		}
-		posts = append(posts, p)
+	tx, err := begin(ctx, m.DB, m.Listener)
+	if err != nil {
+		return 0, err
 	}
-	return posts, nil
-}
-func (m *PostDB) Create(title, content string, topicID, userID int64) (int64, error) { //Creates a new Post
-	result, err := m.DB.Exec("INSERT INTO posts (title, content, created_at, updated_at, topic_id, user_id) VALUES (?, ?, ?, ?, ?, ?)",
-		title, content, time.Now().UTC(), time.Now().UTC(), topicID, userID)
+	now := time.Now().UTC()
+	postID, err := database.InsertID(ctx, tx.dialect, tx, "INSERT INTO posts (title, content, content_html, created_at, updated_at, topic_id, user_id, hot_score) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		title, content, markdown.Render(content), now, now, topicID, userID, HotScore(0, 0, now))
 	if err != nil {
+		tx.Rollback()
 		return 0, err
 	}
-	return result.LastInsertId()
+	if err := saveMentions(ctx, tx, postID, sql.NullInt64{}, userID, mentioned); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return postID, tx.Commit()
 }
-func (m *PostDB) Delete(postID int64) error {
-	_, err := m.DB.Exec("DELETE FROM posts WHERE id = ?", postID)
+func (m *PostDB) Delete(ctx context.Context, postID int64) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM posts WHERE id = ?", postID)
 	return err
 }
 
 // Returns a Post by ID together with an additional column of whether the post is liked by the user
-func (m *PostDB) GetByID(postID, userID int64) (*Post, error) {
-	query := `SELECT p.id, p.title, p.content, p.likes, p.created_at, p.updated_at, p.topic_id, p.user_id, u.username, t.title,
-						EXISTS (SELECT 1 FROM post_likes pl where pl.post_id = p.id AND pl.user_id = ?) AS liked_by_user
+func (m *PostDB) GetByID(ctx context.Context, postID, userID int64) (*Post, error) {
+	query := `SELECT p.id, p.title, p.content, p.content_html, p.likes, p.comment_count, p.edit_count, p.created_at, p.updated_at, p.topic_id, p.user_id, u.username, t.title,
+						EXISTS (SELECT 1 FROM post_likes pl where pl.post_id = p.id AND pl.user_id = ?) AS liked_by_user,
+						EXISTS (SELECT 1 FROM bookmarks b WHERE b.post_id = p.id AND b.comment_id IS NULL AND b.user_id = ?) AS bookmarked_by_user
 	FROM posts p 
 	JOIN users u ON p.user_id = u.id 
 	JOIN topics t ON p.topic_id = t.id
 	WHERE p.id = ?`
-	row := m.DB.QueryRow(query, userID, postID)
+	row := m.DB.QueryRowContext(ctx, query, userID, userID, postID)
 	var p Post
-	if err := row.Scan(&p.ID, &p.Title, &p.Content, &p.Likes, &p.CreatedAt, &p.UpdatedAt, &p.TopicID, &p.UserID, &p.CreatedByUsername, &p.TopicTitle, &p.LikedByUser); err != nil {
+	if err := row.Scan(&p.ID, &p.Title, &p.Content, contentHTML{&p.ContentHTML, &p.Content}, &p.Likes, &p.CommentCount, &p.EditCount, &p.CreatedAt, &p.UpdatedAt, &p.TopicID, &p.UserID, &p.CreatedByUsername, &p.TopicTitle, &p.LikedByUser, &p.BookmarkedByUser); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return &p, nil
+	p.Edited = p.EditCount > 0
+	posts := []Post{p}
+	if err := loadPostReactions(ctx, m.DB, posts, userID); err != nil {
+		return nil, err
+	}
+	return &posts[0], nil
 }
 
-// Updates the post
-func (m *PostDB) Update(postID int64, title, content string) error {
-	_, err := m.DB.Exec("UPDATE posts SET title = ?, content = ?, updated_at = ? WHERE id = ?", title, content, time.Now().UTC(), postID)
-	return err
+// Updates the post, the previous title and content are saved as a new revision first so edits never erase anything
+func (m *PostDB) Update(ctx context.Context, postID, editorID int64, title, content string) error {
+	mentioned, err := resolveMentions(ctx, m.DB, content, editorID)
+	if err != nil {
+		return err
+	}
+	tx, err := begin(ctx, m.DB, m.Listener)
+	if err != nil {
+		return err
+	}
+	var oldTitle, oldContent string
+	var oldHTML sql.NullString
+	var editCount int
+	//Lock the post so that two concurrent edits can't get the same revision number
+	err = tx.QueryRowContext(ctx, "SELECT title, COALESCE(content, ''), content_html, edit_count FROM posts WHERE id = ?"+forUpdate(tx.dialect), postID).Scan(&oldTitle, &oldContent, &oldHTML, &editCount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	now := time.Now().UTC()
+	//The rendered HTML moves along with the content, so every revision keeps its own cached copy
+	_, err = tx.ExecContext(ctx, "INSERT INTO post_revisions (post_id, revision, title, content, content_html, editor_id, edited_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		postID, editCount+1, oldTitle, oldContent, oldHTML, editorID, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := saveMentions(ctx, tx, postID, sql.NullInt64{}, editorID, mentioned); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE posts SET title = ?, content = ?, content_html = ?, updated_at = ?, edit_count = edit_count + 1 WHERE id = ?", title, content, markdown.Render(content), now, postID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-// Like post
-func (m *PostDB) LikePost(postID, userID int64) error {
-	tx, err := m.DB.Begin()
+// Like post, liking a post that is already liked unlikes it
+func (m *PostDB) LikePost(ctx context.Context, postID, userID int64) error {
+	tx, err := begin(ctx, m.DB, m.Listener)
 	if err != nil {
 		return err
 	}
+	//Lock the post first, otherwise two toggles at once both see the same state and the second one doesn't undo the first
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM posts WHERE id = ?"+forUpdate(tx.dialect), postID); err != nil {
+		tx.Rollback()
+		return err
+	}
 	var exists bool
-
-	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM post_likes WHERE post_id = ? AND user_id = ?)", postID, userID).Scan(&exists)
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM post_likes WHERE post_id = ? AND user_id = ?)", postID, userID).Scan(&exists)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	if exists {
-		_, err := tx.Exec("DELETE FROM post_likes WHERE post_id = ? AND user_id = ?", postID, userID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		_, err = tx.Exec("UPDATE posts SET likes = likes - 1 WHERE id = ?", postID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+		_, err = removePostLike(ctx, tx, postID, userID)
 	} else {
-		_, err := tx.Exec("INSERT INTO post_likes (post_id,user_id) VALUES (?,?)", postID, userID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		_, err = tx.Exec("UPDATE posts SET likes = likes + 1 WHERE id = ?", postID)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+		_, err = addPostLike(ctx, tx, postID, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
 	return tx.Commit()
+}
 
+// Likes (liked) or unlikes a post. Unlike LikePost this can be repeated safely,
+// changed is false if the post already was in the requested state.
+func (m *PostDB) SetLike(ctx context.Context, postID, userID int64, liked bool) (changed bool, err error) {
+	tx, err := begin(ctx, m.DB, m.Listener)
+	if err != nil {
+		return false, err
+	}
+	if liked {
+		changed, err = addPostLike(ctx, tx, postID, userID)
+	} else {
+		changed, err = removePostLike(ctx, tx, postID, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return changed, tx.Commit()
 }
-func (m *PostDB) SearchPost(query string) ([]Post, error) {
-	sql_qry := `SELECT p.id, p.title, p.content, p.created_at, p.updated_at, p.topic_id, t.title, p.user_id,
-	u.username FROM posts p 
-	JOIN users u ON p.user_id = u.id
-	JOIN topics t ON p.topic_id = t.id
-	WHERE MATCH(p.title,p.content) AGAINST (? IN BOOLEAN MODE)
-	ORDER BY p.created_at DESC
-	`
-	rows, err := m.DB.Query(sql_qry, "*"+query+"*")
+
+// The like count of a post or comment and whether the user likes it
+type LikeState struct {
+	Likes int  `json:"likes"`
+	Liked bool `json:"liked"`
+}
+
+// Returns the like state of a post for the user, nil if the post doesn't exist
+func (m *PostDB) LikeState(ctx context.Context, postID, userID int64) (*LikeState, error) {
+	var state LikeState
+	err := m.DB.QueryRowContext(ctx, "SELECT p.likes, EXISTS (SELECT 1 FROM post_likes pl WHERE pl.post_id = p.id AND pl.user_id = ?) FROM posts p WHERE p.id = ?",
+		userID, postID).Scan(&state.Likes, &state.Liked)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
-	defer rows.Close()
-	var posts []Post
-	for rows.Next() {
-		var p Post
-		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.CreatedAt,
-			&p.UpdatedAt, &p.TopicID, &p.TopicTitle, &p.UserID, &p.CreatedByUsername); err != nil {
-			return nil, err
-		}
-		posts = append(posts, p)
+	return &state, nil
+}
+
+// Adds the like and, if it wasn't there yet, bumps the like count and notifies the author.
+// The counter only moves when the like row actually changed, so concurrent requests can't make it drift.
+func addPostLike(ctx context.Context, tx *hookedTx, postID, userID int64) (bool, error) {
+	result, err := tx.ExecContext(ctx, insertIgnore(tx.dialect, "INTO post_likes (post_id, user_id) VALUES (?, ?)"), postID, userID)
+	if err != nil {
+		return false, err
 	}
-	if posts == nil {
-		posts = []Post{}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
 	}
-	return posts, nil
+	if _, err := tx.ExecContext(ctx, "UPDATE posts SET likes = likes + 1 WHERE id = ?", postID); err != nil {
+		return false, err
+	}
+	like := notifications.Notification{
+		Kind:    notifications.KindPostLike,
+		ActorID: sql.NullInt64{Int64: userID, Valid: true},
+		PostID:  sql.NullInt64{Int64: postID, Valid: true},
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT user_id FROM posts WHERE id = ?", postID).Scan(&like.UserID); err != nil {
+		return false, err
+	}
+	if err := notifications.Create(ctx, tx, like); err != nil {
+		return false, err
+	}
+	//The like count changed so the hot score has to be recomputed
+	return true, updateHotScore(ctx, tx, postID)
+}
+
+// Removes the like and, if there was one, lowers the like count and withdraws the unread notification
+func removePostLike(ctx context.Context, tx *hookedTx, postID, userID int64) (bool, error) {
+	result, err := tx.ExecContext(ctx, "DELETE FROM post_likes WHERE post_id = ? AND user_id = ?", postID, userID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE posts SET likes = likes - 1 WHERE id = ?", postID); err != nil {
+		return false, err
+	}
+	if err := notifications.RemoveLike(ctx, tx, userID, postID, sql.NullInt64{}); err != nil {
+		return false, err
+	}
+	return true, updateHotScore(ctx, tx, postID)
+}
+func (m *PostDB) SearchPost(ctx context.Context, query string, page PageRequest) (Page[Post], error) {
+	where, args := postSearch(database.DialectOf(m.DB), query)
+	return m.list(ctx, where, args, 0, PostListOptions{Sort: SortNew}, page)
+}
+func (m *PostDB) GetAll(ctx context.Context, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) {
+	return m.list(ctx, "", nil, userID, opts, page)
 }
-func (m *PostDB) GetAll(userID int64, limit int64, offset int64) ([]Post, error) {
-	query := `SELECT p.id, p.title, p.content, p.likes, p.created_at, p.updated_at, p.topic_id, p.user_id, u.username, t.title,
-						EXISTS (SELECT 1 FROM post_likes pl where pl.post_id = p.id AND pl.user_id = ?) AS liked_by_user
+
+// The home feed of a user, the posts of the topics the user is subscribed to
+func (m *PostDB) Feed(ctx context.Context, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) {
+	return m.list(ctx, "p.topic_id IN (SELECT ts.topic_id FROM topic_subscriptions ts WHERE ts.user_id = ?)", []any{userID}, userID, opts, page)
+}
+
+// Shared query for the post listings, where is an optional extra condition with its arguments.
+// The posts are paginated with a keyset on the sort key, so pages stay stable while new posts come in.
+func (m *PostDB) list(ctx context.Context, where string, whereArgs []any, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error) {
+	now := time.Now().UTC()
+	if page.Cursor != nil && !page.Cursor.Now.IsZero() {
+		now = page.Cursor.Now
+	}
+	key := opts.keyset(database.DialectOf(m.DB), now)
+	query := `SELECT p.id, p.title, p.content, p.content_html, p.likes, p.comment_count, p.edit_count, p.created_at, p.updated_at, p.topic_id, p.user_id, u.username, t.title,
+						EXISTS (SELECT 1 FROM post_likes pl where pl.post_id = p.id AND pl.user_id = ?) AS liked_by_user,
+						EXISTS (SELECT 1 FROM bookmarks b WHERE b.post_id = p.id AND b.comment_id IS NULL AND b.user_id = ?) AS bookmarked_by_user,
+						` + key.Column + ` AS sort_key
 	FROM posts p 
 	JOIN users u ON p.user_id = u.id
 	JOIN topics t ON p.topic_id = t.id
-	ORDER BY p.created_at DESC
-	LIMIT ? OFFSET ?`
+	WHERE 1 = 1`
+	args := []any{userID, userID}
+	args = append(args, key.Args...)
+	if where != "" {
+		query += " AND " + where
+		args = append(args, whereArgs...)
+	}
+	if !opts.Since.IsZero() {
+		query += " AND p.created_at >= ?"
+		args = append(args, opts.Since)
+	}
+	if page.Cursor != nil {
+		cond, condArgs := key.condition(page.Cursor)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	orderBy, orderArgs := key.orderBy(page.Cursor != nil && page.Cursor.Prev)
+	query += " ORDER BY " + orderBy + " LIMIT ?"
+	args = append(args, orderArgs...)
+	args = append(args, page.Limit+1)
 
-	rows, err := m.DB.Query(query, userID, limit, offset)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-
-		return nil, err
+		return Page[Post]{}, err
 	}
 	defer rows.Close()
 
-	var posts []Post
+	posts := []Post{}
+	scores := map[int64]float64{}
 	for rows.Next() {
 		var p Post
-		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.Likes, &p.CreatedAt, &p.UpdatedAt, &p.TopicID, &p.UserID, &p.CreatedByUsername, &p.TopicTitle, &p.LikedByUser); err != nil {
-			return nil, err
+		var sortKey any
+		if err := rows.Scan(&p.ID, &p.Title, &p.Content, contentHTML{&p.ContentHTML, &p.Content}, &p.Likes, &p.CommentCount, &p.EditCount, &p.CreatedAt, &p.UpdatedAt, &p.TopicID, &p.UserID, &p.CreatedByUsername, &p.TopicTitle, &p.LikedByUser, &p.BookmarkedByUser, &sortKey); err != nil {
+			return Page[Post]{}, err
+		}
+		p.Edited = p.EditCount > 0
+		if key.ByScore {
+			var score sql.NullFloat64
+			if err := score.Scan(sortKey); err != nil {
+				return Page[Post]{}, err
+			}
+			scores[p.ID] = score.Float64
 		}
 		posts = append(posts, p)
 	}
-	return posts, nil
+	if err := rows.Err(); err != nil {
+		return Page[Post]{}, err
+	}
+	if err := loadPostReactions(ctx, m.DB, posts, userID); err != nil {
+		return Page[Post]{}, err
+	}
+	return newPage(posts, page, func(p Post) Cursor {
+		return Cursor{Sort: opts.Sort, CreatedAt: p.CreatedAt, Score: scores[p.ID], ID: p.ID, Now: now}
+	}), nil
 }
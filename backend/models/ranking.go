@@ -0,0 +1,90 @@
+package models
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Sort orders for the post listings
+const (
+	SortNew    = "new"
+	SortHot    = "hot"
+	SortTop    = "top"
+	SortRising = "rising"
+)
+
+// Options for the post listings, Since limits the listing to posts created after it (zero means no limit)
+type PostListOptions struct {
+	Sort  string
+	Since time.Time
+}
+
+// A comment counts for as much as two likes when ranking posts
+const commentWeight = 2
+
+// Reference point for the hot score, any fixed date works as only the differences between scores matter
+var hotEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Reddit style hot score: the log of the engagement plus a bonus that grows with the creation time.
+// A post needs 10 times the engagement to beat a post that is 12.5 hours newer. Because the time part
+// only depends on created_at, the score never has to be recomputed as time passes, only when likes or comments change.
+// The MySQL migration 0005_post_ranking computes the same in SQL for the posts from before the score existed.
+func HotScore(likes, comments int, createdAt time.Time) float64 {
+	engagement := float64(likes + commentWeight*comments)
+	return math.Log10(math.Max(engagement, 1)) + createdAt.Sub(hotEpoch).Seconds()/45000
+}
+
+// Anything that can run queries, i.e. *sql.DB or *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Recomputes the stored hot score of a post from its current likes and comment count
+func updateHotScore(ctx context.Context, q querier, postID int64) error {
+	var likes, comments int
+	var createdAt time.Time
+	if err := q.QueryRowContext(ctx, "SELECT likes, comment_count, created_at FROM posts WHERE id = ?", postID).Scan(&likes, &comments, &createdAt); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, "UPDATE posts SET hot_score = ? WHERE id = ?", HotScore(likes, comments, createdAt), postID)
+	return err
+}
+
+// Returns the keyset the listing is ordered by in dialect d. now is the reference time of the rising score.
+func (o PostListOptions) keyset(d database.Dialect, now time.Time) keyset {
+	switch o.Sort {
+	case SortHot:
+		return keyset{Column: "p.hot_score", IDColumn: "p.id", ByScore: true}
+	case SortTop:
+		return keyset{Column: "p.likes", IDColumn: "p.id", ByScore: true}
+	case SortRising:
+		//Engagement per hour of age, the extra 2 hours stop brand new posts with a single like from jumping to the top
+		return keyset{
+			Column:   fmt.Sprintf("CAST(p.likes + %d * p.comment_count AS %s) / (%s + 120)", commentWeight, doubleType(d), minutesSince(d, "p.created_at")),
+			IDColumn: "p.id",
+			Args:     []any{now},
+			ByScore:  true,
+		}
+	default:
+		return keyset{Column: "p.created_at", IDColumn: "p.id"}
+	}
+}
+
+// Returns the value the listing sorts a post by when it is ordered by score, the same value the keyset's column computes
+func (o PostListOptions) score(p Post, now time.Time) float64 {
+	switch o.Sort {
+	case SortHot:
+		return HotScore(p.Likes, p.CommentCount, p.CreatedAt)
+	case SortTop:
+		return float64(p.Likes)
+	case SortRising:
+		return float64(p.Likes+commentWeight*p.CommentCount) / (float64(int64(now.Sub(p.CreatedAt)/time.Minute)) + 120)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The "like" reaction is the original like. It stays in post_likes and comment_likes and keeps the likes columns up to date,
+// so clients that only know about likes keep working. Every other kind is stored in post_reactions and comment_reactions.
+const ReactionLike = "like"
+
+// The reaction kinds users can pick from, in display order. Changed with SetReactionKinds.
+var reactionKinds = []string{ReactionLike, "love", "laugh", "wow", "sad", "angry"}
+
+var reactionKindPattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// Configures the reaction kinds, like is always available and is added in front if missing.
+// Removing a kind hides its reactions but keeps them in the database, adding it back brings them back.
+func SetReactionKinds(kinds []string) error {
+	configured := []string{ReactionLike}
+	for _, k := range kinds {
+		k = strings.TrimSpace(k)
+		if k == "" || slices.Contains(configured, k) {
+			continue
+		}
+		if !reactionKindPattern.MatchString(k) {
+			return fmt.Errorf("invalid reaction kind %q, kinds are lowercase letters, digits and underscores", k)
+		}
+		configured = append(configured, k)
+	}
+	reactionKinds = configured
+	return nil
+}
+
+func ReactionKinds() []string {
+	return reactionKinds
+}
+
+func ValidReactionKind(kind string) bool {
+	return slices.Contains(reactionKinds, kind)
+}
+
+// Reactions of a post or comment: the count of every kind that has any, and the kinds the viewer picked
+type Reactions struct {
+	Counts map[string]int `json:"counts"`
+	Mine   []string       `json:"mine"`
+}
+
+// Always encodes counts and mine as an object and an array, also for posts and comments whose reactions weren't loaded
+func (r Reactions) MarshalJSON() ([]byte, error) {
+	type reactions Reactions
+	if r.Counts == nil {
+		r.Counts = map[string]int{}
+	}
+	if r.Mine == nil {
+		r.Mine = []string{}
+	}
+	return json.Marshal(reactions(r))
+}
+
+// Adds the like column to the counts loaded from the reaction tables, and drops kinds that are no longer configured
+func newReactions(likes int, likedByUser bool, counts map[string]int, mine []string) Reactions {
+	r := Reactions{Counts: map[string]int{}, Mine: []string{}}
+	if likes > 0 {
+		r.Counts[ReactionLike] = likes
+	}
+	if likedByUser {
+		r.Mine = append(r.Mine, ReactionLike)
+	}
+	for _, kind := range reactionKinds[1:] {
+		if counts[kind] > 0 {
+			r.Counts[kind] = counts[kind]
+		}
+		if slices.Contains(mine, kind) {
+			r.Mine = append(r.Mine, kind)
+		}
+	}
+	return r
+}
+
+// Loads the reaction counts and the viewer's own reactions of a batch of posts or comments from table, keyed by id
+func loadReactions(ctx context.Context, db *sql.DB, table, idColumn string, ids []int64, userID int64) (map[int64]map[string]int, map[int64][]string, error) {
+	counts := map[int64]map[string]int{}
+	mine := map[int64][]string{}
+	if len(ids) == 0 {
+		return counts, mine, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]any, 0, len(ids)+1)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s, kind, COUNT(*) FROM %s WHERE %s IN (%s) GROUP BY %s, kind", idColumn, table, idColumn, placeholders, idColumn), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var kind string
+		var n int
+		if err := rows.Scan(&id, &kind, &n); err != nil {
+			return nil, nil, err
+		}
+		if counts[id] == nil {
+			counts[id] = map[string]int{}
+		}
+		counts[id][kind] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if userID <= 0 {
+		return counts, mine, nil
+	}
+	rows, err = db.QueryContext(ctx, fmt.Sprintf("SELECT %s, kind FROM %s WHERE user_id = ? AND %s IN (%s)", idColumn, table, idColumn, placeholders), append([]any{userID}, args...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var kind string
+		if err := rows.Scan(&id, &kind); err != nil {
+			return nil, nil, err
+		}
+		mine[id] = append(mine[id], kind)
+	}
+	return counts, mine, rows.Err()
+}
+
+// Fills in the reactions of posts as seen by userID
+func loadPostReactions(ctx context.Context, db *sql.DB, posts []Post, userID int64) error {
+	ids := make([]int64, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	counts, mine, err := loadReactions(ctx, db, "post_reactions", "post_id", ids, userID)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		p := &posts[i]
+		p.Reactions = newReactions(p.Likes, p.LikedByUser, counts[p.ID], mine[p.ID])
+	}
+	return nil
+}
+
+// Fills in the reactions of comments as seen by userID
+func loadCommentReactions(ctx context.Context, db *sql.DB, comments []*Comment, userID int64) error {
+	ids := make([]int64, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	counts, mine, err := loadReactions(ctx, db, "comment_reactions", "comment_id", ids, userID)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		c.Reactions = newReactions(c.Likes, c.LikedByUser, counts[c.ID], mine[c.ID])
+	}
+	return nil
+}
+
+// Adds (on) or removes a reaction of the user to a post, likes go through SetLike.
+// Setting a reaction the user already has, or removing one they don't, changes nothing and returns changed false.
+func (m *PostDB) SetReaction(ctx context.Context, postID, userID int64, kind string, on bool) (changed bool, err error) {
+	if kind == ReactionLike {
+		return m.SetLike(ctx, postID, userID, on)
+	}
+	var result sql.Result
+	if on {
+		result, err = m.DB.ExecContext(ctx, insertIgnore(database.DialectOf(m.DB), "INTO post_reactions (post_id, user_id, kind, created_at) VALUES (?, ?, ?, ?)"), postID, userID, kind, time.Now().UTC())
+	} else {
+		result, err = m.DB.ExecContext(ctx, "DELETE FROM post_reactions WHERE post_id = ? AND user_id = ? AND kind = ?", postID, userID, kind)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Returns the reactions of a post as seen by userID
+func (m *PostDB) Reactions(ctx context.Context, postID, userID int64) (Reactions, error) {
+	post, err := m.GetByID(ctx, postID, userID)
+	if err != nil || post == nil {
+		return Reactions{}, err
+	}
+	return post.Reactions, nil
+}
+
+// Adds (on) or removes a reaction of the user to a comment, likes go through SetLike
+func (m *CommentDB) SetReaction(ctx context.Context, commentID, userID int64, kind string, on bool) (changed bool, err error) {
+	if kind == ReactionLike {
+		return m.SetLike(ctx, commentID, userID, on)
+	}
+	var result sql.Result
+	if on {
+		result, err = m.DB.ExecContext(ctx, insertIgnore(database.DialectOf(m.DB), "INTO comment_reactions (comment_id, user_id, kind, created_at) VALUES (?, ?, ?, ?)"), commentID, userID, kind, time.Now().UTC())
+	} else {
+		result, err = m.DB.ExecContext(ctx, "DELETE FROM comment_reactions WHERE comment_id = ? AND user_id = ? AND kind = ?", commentID, userID, kind)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Returns the reactions of a comment as seen by userID
+func (m *CommentDB) Reactions(ctx context.Context, commentID, userID int64) (Reactions, error) {
+	var c Comment
+	err := m.DB.QueryRowContext(ctx, `SELECT c.id, c.likes, EXISTS (SELECT 1 FROM comment_likes cl WHERE cl.comment_id = c.id AND cl.user_id = ?)
+	FROM comments c WHERE c.id = ?`, userID, commentID).Scan(&c.ID, &c.Likes, &c.LikedByUser)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Reactions{}, nil
+		}
+		return Reactions{}, err
+	}
+	if err := loadCommentReactions(ctx, m.DB, []*Comment{&c}, userID); err != nil {
+		return Reactions{}, err
+	}
+	return c.Reactions, nil
+}
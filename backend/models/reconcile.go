@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+)
+
+// A like counter that didn't match the like table, Likes is what the column said and Actual what it was set to
+type LikeDrift struct {
+	ID     int64 `json:"id"`
+	Likes  int   `json:"likes"`
+	Actual int   `json:"actual"`
+}
+
+// Recomputes posts.likes and comments.likes from post_likes and comment_likes and returns the rows that were off.
+// With dryRun the drift is only reported. Hot scores of the corrected posts are recomputed as well.
+func ReconcileLikes(ctx context.Context, db *sql.DB, dryRun bool) (posts, comments []LikeDrift, err error) {
+	posts, err = findLikeDrift(ctx, db, `SELECT p.id, p.likes, COUNT(pl.user_id) FROM posts p
+	LEFT JOIN post_likes pl ON pl.post_id = p.id
+	GROUP BY p.id, p.likes
+	HAVING p.likes <> COUNT(pl.user_id)`)
+	if err != nil {
+		return nil, nil, err
+	}
+	comments, err = findLikeDrift(ctx, db, `SELECT c.id, c.likes, COUNT(cl.user_id) FROM comments c
+	LEFT JOIN comment_likes cl ON cl.comment_id = c.id
+	GROUP BY c.id, c.likes
+	HAVING c.likes <> COUNT(cl.user_id)`)
+	if err != nil || dryRun {
+		return posts, comments, err
+	}
+	//Every row is recounted inside its own transaction, likes that came in since the scan are counted too
+	for i, d := range posts {
+		tx, err := begin(ctx, db, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE posts SET likes = (SELECT COUNT(*) FROM post_likes WHERE post_id = ?) WHERE id = ?", d.ID, d.ID); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		if err := tx.QueryRowContext(ctx, "SELECT likes FROM posts WHERE id = ?", d.ID).Scan(&posts[i].Actual); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		if err := updateHotScore(ctx, tx, d.ID); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, d := range comments {
+		if _, err := db.ExecContext(ctx, "UPDATE comments SET likes = (SELECT COUNT(*) FROM comment_likes WHERE comment_id = ?) WHERE id = ?", d.ID, d.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	return posts, comments, nil
+}
+
+func findLikeDrift(ctx context.Context, db *sql.DB, query string) ([]LikeDrift, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	drift := []LikeDrift{}
+	for rows.Next() {
+		var d LikeDrift
+		if err := rows.Scan(&d.ID, &d.Likes, &d.Actual); err != nil {
+			return nil, err
+		}
+		drift = append(drift, d)
+	}
+	return drift, rows.Err()
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Refresh tokens are opaque random strings, only their SHA-256 hash is stored.
+// Every refresh token belongs to a session, the session acts as the token family: rotating a token
+// creates a new token in the same session and presenting an already rotated token revokes the whole session.
+type RefreshTokenDB struct {
+	DB *sql.DB
+}
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+func (m *RefreshTokenDB) Create(ctx context.Context, sessionID, tokenHash string, expiresAt time.Time) error {
+	_, err := m.DB.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		tokenHash, sessionID, time.Now().UTC(), expiresAt.UTC())
+	return err
+}
+
+// Marks the old token as used and stores its replacement in the same session, returning the session ID and user ID.
+// If the old token was already used it has been replayed, so the session is revoked and ErrRefreshTokenReused is returned.
+func (m *RefreshTokenDB) Rotate(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (string, int64, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	var sessionID string
+	var userID int64
+	var usedAt, revokedAt sql.NullTime
+	var tokenExpiresAt time.Time
+	//Lock the token row so that two concurrent refreshes with the same token can't both succeed
+	err = tx.QueryRowContext(ctx, `SELECT rt.session_id, rt.used_at, rt.expires_at, s.user_id, s.revoked_at
+	FROM refresh_tokens rt
+	JOIN sessions s ON rt.session_id = s.id
+	WHERE rt.token_hash = ?`+forUpdate(database.DialectOf(m.DB)), oldHash).Scan(&sessionID, &usedAt, &tokenExpiresAt, &userID, &revokedAt)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return "", 0, ErrRefreshTokenInvalid
+		}
+		return "", 0, err
+	}
+	now := time.Now().UTC()
+	if usedAt.Valid {
+		//Reuse detected, someone is holding a copy of an old token so kill the whole family
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, sessionID); err != nil {
+			tx.Rollback()
+			return "", 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", 0, err
+		}
+		return "", 0, ErrRefreshTokenReused
+	}
+	if revokedAt.Valid || now.After(tokenExpiresAt) {
+		tx.Rollback()
+		return "", 0, ErrRefreshTokenInvalid
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?", now, oldHash); err != nil {
+		tx.Rollback()
+		return "", 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		newHash, sessionID, now, expiresAt.UTC()); err != nil {
+		tx.Rollback()
+		return "", 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?", now, sessionID); err != nil {
+		tx.Rollback()
+		return "", 0, err
+	}
+	return sessionID, userID, tx.Commit()
+}
+
+// Returns the session a refresh token belongs to, used on logout when the access token has already expired
+func (m *RefreshTokenDB) GetSession(ctx context.Context, tokenHash string) (string, int64, error) {
+	var sessionID string
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, `SELECT s.id, s.user_id FROM refresh_tokens rt
+	JOIN sessions s ON rt.session_id = s.id
+	WHERE rt.token_hash = ?`, tokenHash).Scan(&sessionID, &userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+	return sessionID, userID, nil
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostRevision is what a post looked like before one of its edits. Revision n holds the title and content
+// that edit n replaced, so revision 1 is the original post and the post itself is revision edit_count + 1.
+type PostRevision struct {
+	PostID         int64     `json:"post_id"`
+	Revision       int       `json:"revision"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content"`
+	ContentHTML    string    `json:"content_html"`
+	EditorID       int64     `json:"editor_id"`
+	EditorUsername string    `json:"editor_username"`
+	EditedAt       time.Time `json:"edited_at"`
+}
+
+// Returns every stored revision of a post, oldest first
+func (m *PostDB) Revisions(ctx context.Context, postID int64) ([]PostRevision, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT r.post_id, r.revision, r.title, r.content, r.content_html, r.editor_id, u.username, r.edited_at
+	FROM post_revisions r
+	JOIN users u ON r.editor_id = u.id
+	WHERE r.post_id = ?
+	ORDER BY r.revision`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var r PostRevision
+		if err := rows.Scan(&r.PostID, &r.Revision, &r.Title, &r.Content, contentHTML{&r.ContentHTML, &r.Content}, &r.EditorID, &r.EditorUsername, &r.EditedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// Returns a single revision of a post, nil if it doesn't exist
+func (m *PostDB) GetRevision(ctx context.Context, postID int64, revision int) (*PostRevision, error) {
+	row := m.DB.QueryRowContext(ctx, `SELECT r.post_id, r.revision, r.title, r.content, r.content_html, r.editor_id, u.username, r.edited_at
+	FROM post_revisions r
+	JOIN users u ON r.editor_id = u.id
+	WHERE r.post_id = ? AND r.revision = ?`, postID, revision)
+	var r PostRevision
+	if err := row.Scan(&r.PostID, &r.Revision, &r.Title, &r.Content, contentHTML{&r.ContentHTML, &r.Content}, &r.EditorID, &r.EditorUsername, &r.EditedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// What happened to a comment in a revision
+const (
+	CommentEdited   = "edit"
+	CommentDeleted  = "delete"
+	CommentRedacted = "redact"
+)
+
+// CommentRevision is the content a comment had right before it was edited, deleted or redacted.
+// Content is empty once the comment has been redacted, the text is purged from every revision.
+type CommentRevision struct {
+	CommentID      int64     `json:"comment_id"`
+	Revision       int       `json:"revision"`
+	Action         string    `json:"action"`
+	Content        string    `json:"content"`
+	Redacted       bool      `json:"redacted"`
+	Reason         string    `json:"reason,omitempty"`
+	EditorID       int64     `json:"editor_id"`
+	EditorUsername string    `json:"editor_username"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Stores the current content of a comment as its next revision. The comment row must already be locked by the transaction.
+func addCommentRevision(ctx context.Context, tx querier, commentID, editorID int64, action string, content sql.NullString, reason string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO comment_revisions (comment_id, revision, action, content, reason, editor_id, created_at)
+	SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?, ? FROM comment_revisions WHERE comment_id = ?`,
+		commentID, action, content, reason, editorID, time.Now().UTC(), commentID)
+	return err
+}
+
+// Returns the revisions of a comment, oldest first
+func (m *CommentDB) Revisions(ctx context.Context, commentID int64) ([]CommentRevision, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT r.comment_id, r.revision, r.action, r.content, r.redacted, r.reason, r.editor_id, u.username, r.created_at
+	FROM comment_revisions r
+	JOIN users u ON r.editor_id = u.id
+	WHERE r.comment_id = ?
+	ORDER BY r.revision`, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []CommentRevision{}
+	for rows.Next() {
+		var r CommentRevision
+		var content, reason sql.NullString
+		if err := rows.Scan(&r.CommentID, &r.Revision, &r.Action, &content, &r.Redacted, &reason, &r.EditorID, &r.EditorUsername, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Content = content.String
+		r.Reason = reason.String
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// Permanently purges the text of a comment, of all its revisions and of the webhook deliveries that carried it, for legal takedowns.
+// The comment is left behind as a deleted comment and the redaction itself is recorded as a revision.
+func (m *CommentDB) Redact(ctx context.Context, commentID, editorID int64, reason string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var deleted bool
+	if err := tx.QueryRowContext(ctx, "SELECT deleted FROM comments WHERE id = ?"+forUpdate(database.DialectOf(m.DB)), commentID).Scan(&deleted); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addCommentRevision(ctx, tx, commentID, editorID, CommentRedacted, sql.NullString{}, reason); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE comment_revisions SET content = NULL, redacted = 1 WHERE comment_id = ?", commentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE comments SET content = '', content_html = '', deleted = 1 WHERE id = ?", commentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	//A comment that was deleted before has already left the comment count
+	if !deleted {
+		if err := uncountComment(ctx, tx, commentID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	//The comment.created webhooks carried the text too, both in the delivery log and in the retries still to be sent
+	if err := redactCommentDeliveries(ctx, tx, commentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func redactCommentDeliveries(ctx context.Context, tx *sql.Tx, commentID int64) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, payload FROM webhook_deliveries WHERE comment_id = ?", commentID)
+	if err != nil {
+		return err
+	}
+	payloads := map[int64][]byte{}
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return err
+		}
+		payloads[id] = redactedCommentPayload([]byte(payload), commentID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, payload := range payloads {
+		if _, err := tx.ExecContext(ctx, "UPDATE webhook_deliveries SET payload = ? WHERE id = ?", string(payload), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
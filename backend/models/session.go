@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Session class, each login creates a session whose ID is stored in the JWT as the jti claim.
+// This lets the server revoke a token before it expires.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+
+	//Added field to indicate if this is the session making the request
+	Current bool `json:"current"`
+}
+
+type SessionDB struct {
+	DB *sql.DB
+}
+
+// How often last_seen_at is refreshed, so that every authenticated request doesn't turn into a write
+const sessionTouchInterval = time.Minute
+
+func (m *SessionDB) Create(ctx context.Context, sessionID string, userID int64, userAgent, ipAddress string) error {
+	now := time.Now().UTC()
+	_, err := m.DB.ExecContext(ctx, "INSERT INTO sessions (id, user_id, created_at, last_seen_at, user_agent, ip_address) VALUES (?, ?, ?, ?, ?, ?)",
+		sessionID, userID, now, now, userAgent, ipAddress)
+	return err
+}
+
+// Checks that the session exists, belongs to the user and has not been revoked, and bumps its last seen time.
+func (m *SessionDB) IsActive(ctx context.Context, sessionID string, userID int64) (bool, error) {
+	var lastSeen time.Time
+	err := m.DB.QueryRowContext(ctx, "SELECT last_seen_at FROM sessions WHERE id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).Scan(&lastSeen)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	now := time.Now().UTC()
+	if now.Sub(lastSeen) > sessionTouchInterval {
+		if _, err := m.DB.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?", now, sessionID); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Returns all the active sessions of a user, most recently used first
+func (m *SessionDB) AllByUserID(ctx context.Context, userID int64) ([]Session, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, user_id, created_at, last_seen_at, user_agent, ip_address
+	FROM sessions WHERE user_id = ? AND revoked_at IS NULL
+	ORDER BY last_seen_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.LastSeenAt, &s.UserAgent, &s.IPAddress); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// Revokes a session of the user, returns false if there was no such active session
+func (m *SessionDB) Revoke(ctx context.Context, sessionID string, userID int64) (bool, error) {
+	result, err := m.DB.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
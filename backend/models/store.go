@@ -0,0 +1,147 @@
+package models
+
+import (
+	"backend/notifications"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The stores are everything the handlers read and write through.
+// PostDB, TopicDB, CommentDB, UserDB and the other *DB types implement them on the database, NewMemoryStores keeps everything in memory
+// so handlers can be tested without one. Both behave the same, which storetest.Run checks.
+// Missing rows are reported like everywhere else in this package: getters return nil, nil.
+
+type PostStore interface {
+	GetByID(ctx context.Context, postID, userID int64) (*Post, error)
+	GetAll(ctx context.Context, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error)
+	AllByTopicID(ctx context.Context, topicID, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error)
+	Feed(ctx context.Context, userID int64, opts PostListOptions, page PageRequest) (Page[Post], error)
+	SearchPost(ctx context.Context, query string, page PageRequest) (Page[Post], error)
+	Create(ctx context.Context, title, content string, topicID, userID int64) (int64, error)
+	Update(ctx context.Context, postID, editorID int64, title, content string) error
+	Delete(ctx context.Context, postID int64) error
+	LikePost(ctx context.Context, postID, userID int64) error
+	SetLike(ctx context.Context, postID, userID int64, liked bool) (changed bool, err error)
+	LikeState(ctx context.Context, postID, userID int64) (*LikeState, error)
+	SetReaction(ctx context.Context, postID, userID int64, kind string, on bool) (changed bool, err error)
+	Reactions(ctx context.Context, postID, userID int64) (Reactions, error)
+	Revisions(ctx context.Context, postID int64) ([]PostRevision, error)
+	GetRevision(ctx context.Context, postID int64, revision int) (*PostRevision, error)
+}
+
+type TopicStore interface {
+	GetByID(ctx context.Context, topicID, userID int64) (*Topic, error)
+	All(ctx context.Context, userID int64, page PageRequest) (Page[Topic], error)
+	SearchTopic(ctx context.Context, query string, userID int64, page PageRequest) (Page[Topic], error)
+	Create(ctx context.Context, title, description string, createdBy int64) (int64, error)
+	Update(ctx context.Context, topicID int64, title, description string) error
+	Delete(ctx context.Context, topicID int64) error
+	IsModerator(ctx context.Context, topicID, userID int64) (bool, error)
+	AddModerator(ctx context.Context, topicID, userID int64) error
+	RemoveModerator(ctx context.Context, topicID, userID int64) (bool, error)
+	Moderators(ctx context.Context, topicID int64) ([]User, error)
+	Subscribe(ctx context.Context, topicID, userID int64) error
+	Unsubscribe(ctx context.Context, topicID, userID int64) error
+}
+
+type CommentStore interface {
+	GetByID(ctx context.Context, commentID int64) (*Comment, error)
+	AllByPostID(ctx context.Context, postID, userID int64, page PageRequest) (Page[Comment], error)
+	Tree(ctx context.Context, postID int64, parentID sql.NullInt64, userID int64, maxDepth, limit int, after *Cursor) ([]*CommentNode, *Cursor, error)
+	Ancestors(ctx context.Context, commentID, userID int64, levels int) ([]Comment, error)
+	Create(ctx context.Context, postID int64, userID int64, content string, parentCommentID sql.NullInt64) (int64, error)
+	Update(ctx context.Context, commentID, editorID int64, content string) error
+	Delete(ctx context.Context, commentID, editorID int64) error
+	Redact(ctx context.Context, commentID, editorID int64, reason string) error
+	Revisions(ctx context.Context, commentID int64) ([]CommentRevision, error)
+	LikeComment(ctx context.Context, commentID, userID int64) error
+	SetLike(ctx context.Context, commentID, userID int64, liked bool) (changed bool, err error)
+	LikeState(ctx context.Context, commentID, userID int64) (*LikeState, error)
+	SetReaction(ctx context.Context, commentID, userID int64, kind string, on bool) (changed bool, err error)
+	Reactions(ctx context.Context, commentID, userID int64) (Reactions, error)
+}
+
+type UserStore interface {
+	GetByID(ctx context.Context, userID int64) (*User, error)
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	Create(ctx context.Context, username, passwordHash string) (int64, error)
+	Delete(ctx context.Context, userID int64) error
+	CreatePasswordSetupToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) (bool, error)
+	CheckPasswordSetupToken(ctx context.Context, userID int64, tokenHash string) (bool, error)
+	SetInitialPassword(ctx context.Context, userID int64, tokenHash, passwordHash string) (bool, error)
+	SetRole(ctx context.Context, userID int64, role string) (bool, error)
+	Autocomplete(ctx context.Context, prefix string, limit int) ([]User, error)
+}
+
+type SessionStore interface {
+	Create(ctx context.Context, sessionID string, userID int64, userAgent, ipAddress string) error
+	IsActive(ctx context.Context, sessionID string, userID int64) (bool, error)
+	AllByUserID(ctx context.Context, userID int64) ([]Session, error)
+	Revoke(ctx context.Context, sessionID string, userID int64) (bool, error)
+}
+
+type RefreshTokenStore interface {
+	Create(ctx context.Context, sessionID, tokenHash string, expiresAt time.Time) error
+	Rotate(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (string, int64, error)
+	GetSession(ctx context.Context, tokenHash string) (string, int64, error)
+}
+
+type BookmarkStore interface {
+	AddPost(ctx context.Context, postID, userID int64) error
+	AddComment(ctx context.Context, commentID, userID int64) error
+	RemovePost(ctx context.Context, postID, userID int64) error
+	RemoveComment(ctx context.Context, commentID, userID int64) error
+	All(ctx context.Context, userID int64, filter BookmarkFilter, page PageRequest) (Page[Bookmark], error)
+}
+
+// Implemented by notifications.NotificationDB
+type NotificationStore interface {
+	Create(ctx context.Context, n notifications.Notification) error
+	List(ctx context.Context, userID int64, unreadOnly bool, limit int, cursor *notifications.Cursor) (notifications.Page, error)
+	MarkRead(ctx context.Context, userID int64, ids []int64) (int64, error)
+	UnreadCount(ctx context.Context, userID int64) (int, error)
+}
+
+// The webhooks and their delivery log, the worker in the webhooks package claims and records the deliveries on the database itself
+type WebhookStore interface {
+	Create(ctx context.Context, url, secret string, events []string, topicID sql.NullInt64, createdBy int64) (int64, error)
+	Delete(ctx context.Context, webhookID int64) error
+	GetByID(ctx context.Context, webhookID int64) (*Webhook, error)
+	All(ctx context.Context, ownerID int64, page PageRequest) (Page[Webhook], error)
+	Matching(ctx context.Context, event string, topicID int64) ([]Webhook, error)
+	//commentID is the comment the payload carries, if any, see CommentStore.Redact
+	Enqueue(ctx context.Context, webhookID int64, event string, payload []byte, commentID sql.NullInt64) error
+	Deliveries(ctx context.Context, webhookID int64, page PageRequest) (Page[WebhookDelivery], error)
+	GetDelivery(ctx context.Context, webhookID, deliveryID int64) (*WebhookDelivery, error)
+	Attempts(ctx context.Context, deliveryID int64) ([]WebhookAttempt, error)
+	Retry(ctx context.Context, deliveryID int64) (bool, error)
+}
+
+// Stores groups the stores a handler may need, routers.SetupRouter hands the same set to every handler
+type Stores struct {
+	Posts         PostStore
+	Topics        TopicStore
+	Comments      CommentStore
+	Users         UserStore
+	Sessions      SessionStore
+	RefreshTokens RefreshTokenStore
+	Bookmarks     BookmarkStore
+	Notifications NotificationStore
+	Webhooks      WebhookStore
+}
+
+// The stores backed by the database. listener is told about every notification once it is committed, it can be nil.
+func NewStores(db *sql.DB, listener notifications.Listener) Stores {
+	return Stores{
+		Posts:         &PostDB{DB: db, Listener: listener},
+		Topics:        &TopicDB{DB: db},
+		Comments:      &CommentDB{DB: db, Listener: listener},
+		Users:         &UserDB{DB: db},
+		Sessions:      &SessionDB{DB: db},
+		RefreshTokens: &RefreshTokenDB{DB: db},
+		Bookmarks:     &BookmarkDB{DB: db},
+		Notifications: &notifications.NotificationDB{DB: db, Listener: listener},
+		Webhooks:      &WebhookDB{DB: db},
+	}
+}
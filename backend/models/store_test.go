@@ -0,0 +1,13 @@
+package models_test
+
+import (
+	"backend/models"
+	"backend/models/storetest"
+	"testing"
+)
+
+func TestMemoryStores(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) models.Stores { return models.NewMemoryStores(nil) })
+}
+
+func TestSQLStores(t *testing.T) { storetest.RunSQL(t) }
@@ -0,0 +1,52 @@
+package storetest
+
+import (
+	"backend/database"
+	"backend/migrations"
+	"backend/models"
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// Runs the suite against the database backed stores of every SQL backend, so the queries are checked in each dialect:
+//
+//	func TestSQLStores(t *testing.T) { storetest.RunSQL(t) }
+//
+// SQLite always runs in memory, Postgres and MySQL run when TEST_POSTGRES_DSN and TEST_MYSQL_DSN point at a database
+// and are skipped otherwise (the MySQL DSN needs parseTime=true). The migrations are applied to each database before
+// the suite runs and the rows the suite creates are left behind, so use scratch databases.
+func RunSQL(t *testing.T) {
+	backends := []struct {
+		name string
+		open func() *sql.DB
+	}{
+		{"SQLite", func() *sql.DB { return database.InitSQLite(":memory:") }},
+		{"Postgres", fromEnv("TEST_POSTGRES_DSN", database.InitPostgres)},
+		{"MySQL", fromEnv("TEST_MYSQL_DSN", database.InitMySQL)},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			db := b.open()
+			if db == nil {
+				t.Skip("no database configured")
+			}
+			defer db.Close()
+			if _, err := migrations.Up(db); err != nil {
+				t.Fatalf("migrating: %v", err)
+			}
+			Run(t, func(t *testing.T) models.Stores { return models.NewStores(db, nil) })
+		})
+	}
+}
+
+// Opens the database whose DSN is in the environment variable, nil if it is not set
+func fromEnv(name string, open func(dsn string) *sql.DB) func() *sql.DB {
+	return func() *sql.DB {
+		dsn := os.Getenv(name)
+		if dsn == "" {
+			return nil
+		}
+		return open(dsn)
+	}
+}
@@ -0,0 +1,1240 @@
+// Package storetest is the conformance suite of the model stores. Every implementation of models.Stores has to pass it,
+// so handler tests against the memory stores behave like the server does against the database:
+//
+//	func TestMemoryStores(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) models.Stores { return models.NewMemoryStores(nil) })
+//	}
+//
+// The suite only looks at rows it created itself and gives them unique names, so it can also run against a database
+// that already holds data, e.g. storetest.Run(t, func(t *testing.T) models.Stores { return models.NewStores(db, nil) }).
+package storetest
+
+import (
+	"backend/models"
+	"backend/notifications"
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Runs the whole suite, newStores is called for every test and may return fresh or shared stores
+func Run(t *testing.T, newStores func(t *testing.T) models.Stores) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s models.Stores)
+	}{
+		{"Users", testUsers},
+		{"PasswordSetup", testPasswordSetup},
+		{"Topics", testTopics},
+		{"TopicModerators", testTopicModerators},
+		{"TopicPages", testTopicPages},
+		{"Posts", testPosts},
+		{"PostRevisions", testPostRevisions},
+		{"PostLikes", testPostLikes},
+		{"PostPages", testPostPages},
+		{"Feed", testFeed},
+		{"Comments", testComments},
+		{"CommentRevisions", testCommentRevisions},
+		{"CommentLikes", testCommentLikes},
+		{"CommentTree", testCommentTree},
+		{"Sessions", testSessions},
+		{"RefreshTokens", testRefreshTokens},
+		{"Bookmarks", testBookmarks},
+		{"Notifications", testNotifications},
+		{"Mentions", testMentions},
+		{"Webhooks", testWebhooks},
+		{"Cascade", testCascade},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStores(t))
+		})
+	}
+}
+
+var counter atomic.Int64
+
+// A name no other row has, short enough for the username column
+func unique(prefix string) string {
+	return prefix + strconv.FormatInt(time.Now().UnixNano()%1e9, 36) + strconv.FormatInt(counter.Add(1), 36)
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func firstPage(limit int) models.PageRequest {
+	return models.PageRequest{Limit: limit}
+}
+
+// The page the cursor of an earlier page points at
+func pageAt(t *testing.T, limit int, cursor string) models.PageRequest {
+	t.Helper()
+	c, err := models.DecodeCursor(cursor)
+	check(t, err)
+	return models.PageRequest{Limit: limit, Cursor: c}
+}
+
+func newUser(t *testing.T, s models.Stores) int64 {
+	t.Helper()
+	id, err := s.Users.Create(t.Context(), unique("user"), "hash")
+	check(t, err)
+	return id
+}
+
+func newTopic(t *testing.T, s models.Stores, userID int64) int64 {
+	t.Helper()
+	id, err := s.Topics.Create(t.Context(), unique("topic"), "A topic", userID)
+	check(t, err)
+	return id
+}
+
+func newPost(t *testing.T, s models.Stores, topicID, userID int64) int64 {
+	t.Helper()
+	id, err := s.Posts.Create(t.Context(), "A post", "Some **content**", topicID, userID)
+	check(t, err)
+	return id
+}
+
+func newComment(t *testing.T, s models.Stores, postID, userID int64, parentID int64) int64 {
+	t.Helper()
+	parent := sql.NullInt64{Int64: parentID, Valid: parentID != 0}
+	id, err := s.Comments.Create(t.Context(), postID, userID, "A comment", parent)
+	check(t, err)
+	return id
+}
+
+func ids[T any](items []T, id func(T) int64) []int64 {
+	result := []int64{}
+	for _, item := range items {
+		result = append(result, id(item))
+	}
+	return result
+}
+
+func postIDs(posts []models.Post) []int64 {
+	return ids(posts, func(p models.Post) int64 { return p.ID })
+}
+
+func expectIDs(t *testing.T, what string, got, want []int64) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Fatalf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func testUsers(t *testing.T, s models.Stores) {
+	name := unique("user")
+	id, err := s.Users.Create(t.Context(), name, "hash")
+	check(t, err)
+	user, err := s.Users.GetByID(t.Context(), id)
+	check(t, err)
+	if user == nil || user.Username != name || user.Role != models.RoleUser || !user.PasswordHash.Valid || user.PasswordHash.String != "hash" {
+		t.Fatalf("GetByID returned %+v", user)
+	}
+	byName, err := s.Users.GetByUsername(t.Context(), name)
+	check(t, err)
+	if byName == nil || byName.ID != id {
+		t.Fatalf("GetByUsername returned %+v, want user %d", byName, id)
+	}
+	if _, err := s.Users.Create(t.Context(), name, "other"); err == nil {
+		t.Fatal("creating a user with a taken username succeeded")
+	}
+	if missing, err := s.Users.GetByUsername(t.Context(), unique("nobody")); err != nil || missing != nil {
+		t.Fatalf("GetByUsername of a missing user returned %+v, %v", missing, err)
+	}
+
+	//Setup tokens are only for accounts without a password
+	if ok, err := s.Users.CreatePasswordSetupToken(t.Context(), id, "token", time.Now().Add(time.Hour)); err != nil || ok {
+		t.Fatalf("CreatePasswordSetupToken for an account with a password returned %v, %v", ok, err)
+	}
+	if set, err := s.Users.SetInitialPassword(t.Context(), id, "token", "new"); err != nil || set {
+		t.Fatalf("SetInitialPassword over an existing password returned %v, %v", set, err)
+	}
+
+	if ok, err := s.Users.SetRole(t.Context(), id, models.RoleModerator); err != nil || !ok {
+		t.Fatalf("SetRole returned %v, %v", ok, err)
+	}
+	user, err = s.Users.GetByID(t.Context(), id)
+	check(t, err)
+	if user.Role != models.RoleModerator {
+		t.Fatalf("role is %q after SetRole", user.Role)
+	}
+	if ok, err := s.Users.SetRole(t.Context(), 0, models.RoleAdmin); err != nil || ok {
+		t.Fatalf("SetRole of a missing user returned %v, %v", ok, err)
+	}
+
+	//Shortest names first, so the longer name with the same prefix comes second
+	longer := name + "x"
+	longerID, err := s.Users.Create(t.Context(), longer, "hash")
+	check(t, err)
+	users, err := s.Users.Autocomplete(t.Context(), name, 10)
+	check(t, err)
+	expectIDs(t, "Autocomplete", ids(users, func(u models.User) int64 { return u.ID }), []int64{id, longerID})
+	if users[0].PasswordHash.Valid {
+		t.Fatal("Autocomplete returned a password hash")
+	}
+	if users, err := s.Users.Autocomplete(t.Context(), name, 1); err != nil || len(users) != 1 {
+		t.Fatalf("Autocomplete with limit 1 returned %d users, %v", len(users), err)
+	}
+
+	check(t, s.Users.Delete(t.Context(), longerID))
+	if deleted, err := s.Users.GetByID(t.Context(), longerID); err != nil || deleted != nil {
+		t.Fatalf("GetByID of a deleted user returned %+v, %v", deleted, err)
+	}
+}
+
+func testPasswordSetup(t *testing.T, s models.Stores) {
+	//Accounts from before passwords existed have no hash
+	id, err := s.Users.Create(t.Context(), unique("legacy"), "")
+	check(t, err)
+	user, err := s.Users.GetByID(t.Context(), id)
+	check(t, err)
+	if user.PasswordHash.Valid {
+		t.Fatalf("user created without a password has hash %q", user.PasswordHash.String)
+	}
+	if ok, err := s.Users.CheckPasswordSetupToken(t.Context(), id, "token"); err != nil || ok {
+		t.Fatalf("CheckPasswordSetupToken without a token returned %v, %v", ok, err)
+	}
+
+	//A new token replaces the old one
+	expires := time.Now().Add(time.Hour)
+	for _, token := range []string{"old", "token"} {
+		if ok, err := s.Users.CreatePasswordSetupToken(t.Context(), id, token, expires); err != nil || !ok {
+			t.Fatalf("CreatePasswordSetupToken returned %v, %v", ok, err)
+		}
+	}
+	if ok, err := s.Users.CheckPasswordSetupToken(t.Context(), id, "old"); err != nil || ok {
+		t.Fatalf("CheckPasswordSetupToken of a replaced token returned %v, %v", ok, err)
+	}
+	if ok, err := s.Users.CheckPasswordSetupToken(t.Context(), id, "token"); err != nil || !ok {
+		t.Fatalf("CheckPasswordSetupToken returned %v, %v", ok, err)
+	}
+	if set, err := s.Users.SetInitialPassword(t.Context(), id, "old", "hash"); err != nil || set {
+		t.Fatalf("SetInitialPassword with a replaced token returned %v, %v", set, err)
+	}
+
+	//The token is used up by setting the password
+	if set, err := s.Users.SetInitialPassword(t.Context(), id, "token", "hash"); err != nil || !set {
+		t.Fatalf("SetInitialPassword returned %v, %v", set, err)
+	}
+	user, err = s.Users.GetByID(t.Context(), id)
+	check(t, err)
+	if user.PasswordHash.String != "hash" {
+		t.Fatalf("hash is %q after SetInitialPassword", user.PasswordHash.String)
+	}
+	if ok, err := s.Users.CheckPasswordSetupToken(t.Context(), id, "token"); err != nil || ok {
+		t.Fatalf("CheckPasswordSetupToken of a used token returned %v, %v", ok, err)
+	}
+	if set, err := s.Users.SetInitialPassword(t.Context(), id, "token", "other"); err != nil || set {
+		t.Fatalf("SetInitialPassword with a used token returned %v, %v", set, err)
+	}
+
+	//Expired tokens are worthless
+	expiredID, err := s.Users.Create(t.Context(), unique("legacy"), "")
+	check(t, err)
+	if ok, err := s.Users.CreatePasswordSetupToken(t.Context(), expiredID, "token", time.Now().Add(-time.Minute)); err != nil || !ok {
+		t.Fatalf("CreatePasswordSetupToken returned %v, %v", ok, err)
+	}
+	if ok, err := s.Users.CheckPasswordSetupToken(t.Context(), expiredID, "token"); err != nil || ok {
+		t.Fatalf("CheckPasswordSetupToken of an expired token returned %v, %v", ok, err)
+	}
+	if set, err := s.Users.SetInitialPassword(t.Context(), expiredID, "token", "hash"); err != nil || set {
+		t.Fatalf("SetInitialPassword with an expired token returned %v, %v", set, err)
+	}
+
+	if ok, err := s.Users.CreatePasswordSetupToken(t.Context(), 0, "token", expires); err != nil || ok {
+		t.Fatalf("CreatePasswordSetupToken for a missing user returned %v, %v", ok, err)
+	}
+}
+
+func testTopics(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	title := unique("topic")
+	topicID, err := s.Topics.Create(t.Context(), title, "About things", userID)
+	check(t, err)
+	topic, err := s.Topics.GetByID(t.Context(), topicID, userID)
+	check(t, err)
+	if topic == nil || topic.Title != title || topic.Description != "About things" || topic.UserID != userID || topic.CreatedByUsername == "" {
+		t.Fatalf("GetByID returned %+v", topic)
+	}
+	if _, err := s.Topics.Create(t.Context(), title, "Again", userID); err == nil {
+		t.Fatal("creating a topic with a taken title succeeded")
+	}
+	if missing, err := s.Topics.GetByID(t.Context(), 0, userID); err != nil || missing != nil {
+		t.Fatalf("GetByID of a missing topic returned %+v, %v", missing, err)
+	}
+
+	newTitle := unique("renamed")
+	check(t, s.Topics.Update(t.Context(), topicID, newTitle, "About other things"))
+	topic, err = s.Topics.GetByID(t.Context(), topicID, userID)
+	check(t, err)
+	if topic.Title != newTitle || topic.Description != "About other things" {
+		t.Fatalf("Update didn't change the topic: %+v", topic)
+	}
+
+	//Subscribing twice counts once
+	subscriber := newUser(t, s)
+	check(t, s.Topics.Subscribe(t.Context(), topicID, subscriber))
+	check(t, s.Topics.Subscribe(t.Context(), topicID, subscriber))
+	topic, err = s.Topics.GetByID(t.Context(), topicID, subscriber)
+	check(t, err)
+	if topic.SubscriberCount != 1 || !topic.Subscribed {
+		t.Fatalf("after subscribing: subscriber_count %d, subscribed %v", topic.SubscriberCount, topic.Subscribed)
+	}
+	topic, err = s.Topics.GetByID(t.Context(), topicID, userID)
+	check(t, err)
+	if topic.Subscribed {
+		t.Fatal("topic is subscribed for a user that didn't subscribe")
+	}
+	check(t, s.Topics.Unsubscribe(t.Context(), topicID, subscriber))
+	check(t, s.Topics.Unsubscribe(t.Context(), topicID, subscriber))
+	topic, err = s.Topics.GetByID(t.Context(), topicID, subscriber)
+	check(t, err)
+	if topic.SubscriberCount != 0 || topic.Subscribed {
+		t.Fatalf("after unsubscribing: subscriber_count %d, subscribed %v", topic.SubscriberCount, topic.Subscribed)
+	}
+
+	check(t, s.Topics.Delete(t.Context(), topicID))
+	if deleted, err := s.Topics.GetByID(t.Context(), topicID, userID); err != nil || deleted != nil {
+		t.Fatalf("GetByID of a deleted topic returned %+v, %v", deleted, err)
+	}
+}
+
+func testTopicModerators(t *testing.T, s models.Stores) {
+	owner := newUser(t, s)
+	topicID := newTopic(t, s, owner)
+	first, second := newUser(t, s), newUser(t, s)
+	check(t, s.Topics.AddModerator(t.Context(), topicID, first))
+	check(t, s.Topics.AddModerator(t.Context(), topicID, second))
+	check(t, s.Topics.AddModerator(t.Context(), topicID, first))
+	if is, err := s.Topics.IsModerator(t.Context(), topicID, first); err != nil || !is {
+		t.Fatalf("IsModerator returned %v, %v", is, err)
+	}
+	if is, err := s.Topics.IsModerator(t.Context(), topicID, owner); err != nil || is {
+		t.Fatalf("IsModerator of the owner returned %v, %v", is, err)
+	}
+	moderators, err := s.Topics.Moderators(t.Context(), topicID)
+	check(t, err)
+	expectIDs(t, "Moderators", ids(moderators, func(u models.User) int64 { return u.ID }), []int64{first, second})
+
+	if removed, err := s.Topics.RemoveModerator(t.Context(), topicID, first); err != nil || !removed {
+		t.Fatalf("RemoveModerator returned %v, %v", removed, err)
+	}
+	if removed, err := s.Topics.RemoveModerator(t.Context(), topicID, first); err != nil || removed {
+		t.Fatalf("RemoveModerator of a user that isn't a moderator returned %v, %v", removed, err)
+	}
+	if is, err := s.Topics.IsModerator(t.Context(), topicID, first); err != nil || is {
+		t.Fatalf("IsModerator after removing returned %v, %v", is, err)
+	}
+}
+
+func testTopicPages(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	tag := unique("tag")
+	var created []int64
+	for i := 0; i < 3; i++ {
+		id, err := s.Topics.Create(t.Context(), unique("topic"), "Tagged "+tag, userID)
+		check(t, err)
+		created = append(created, id)
+	}
+	postTopic := created[0]
+	newPost(t, s, postTopic, userID)
+
+	//Newest first, topics created in the same second are ordered by ID
+	page, err := s.Topics.SearchTopic(t.Context(), tag, userID, firstPage(2))
+	check(t, err)
+	topicIDs := func(topics []models.Topic) []int64 { return ids(topics, func(t models.Topic) int64 { return t.ID }) }
+	expectIDs(t, "first page", topicIDs(page.Items), []int64{created[2], created[1]})
+	if page.NextCursor == "" || page.PrevCursor != "" {
+		t.Fatalf("first page has next cursor %q and prev cursor %q", page.NextCursor, page.PrevCursor)
+	}
+	page, err = s.Topics.SearchTopic(t.Context(), tag, userID, pageAt(t, 2, page.NextCursor))
+	check(t, err)
+	expectIDs(t, "second page", topicIDs(page.Items), []int64{created[0]})
+	if page.NextCursor != "" || page.PrevCursor == "" {
+		t.Fatalf("last page has next cursor %q and prev cursor %q", page.NextCursor, page.PrevCursor)
+	}
+	if page.Items[0].PostCount != 1 {
+		t.Fatalf("post_count is %d, want 1", page.Items[0].PostCount)
+	}
+	page, err = s.Topics.SearchTopic(t.Context(), tag, userID, pageAt(t, 2, page.PrevCursor))
+	check(t, err)
+	expectIDs(t, "previous page", topicIDs(page.Items), []int64{created[2], created[1]})
+
+	all, err := s.Topics.All(t.Context(), userID, firstPage(100))
+	check(t, err)
+	for _, id := range created {
+		if !slices.Contains(topicIDs(all.Items), id) {
+			t.Fatalf("All is missing topic %d", id)
+		}
+	}
+}
+
+func testPosts(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	topicID := newTopic(t, s, userID)
+	postID, err := s.Posts.Create(t.Context(), "Hello", "Some **bold** text", topicID, userID)
+	check(t, err)
+	post, err := s.Posts.GetByID(t.Context(), postID, userID)
+	check(t, err)
+	if post == nil || post.Title != "Hello" || post.Content != "Some **bold** text" || post.TopicID != topicID || post.UserID != userID {
+		t.Fatalf("GetByID returned %+v", post)
+	}
+	if post.ContentHTML == "" || post.ContentHTML == post.Content {
+		t.Fatalf("content wasn't rendered: %q", post.ContentHTML)
+	}
+	if post.TopicTitle == "" || post.CreatedByUsername == "" || post.Likes != 0 || post.Edited || post.LikedByUser {
+		t.Fatalf("GetByID returned %+v", post)
+	}
+	if missing, err := s.Posts.GetByID(t.Context(), 0, userID); err != nil || missing != nil {
+		t.Fatalf("GetByID of a missing post returned %+v, %v", missing, err)
+	}
+	if _, err := s.Posts.Create(t.Context(), "Nowhere", "No topic", 0, userID); err == nil {
+		t.Fatal("creating a post in a missing topic succeeded")
+	}
+
+	word := unique("word")
+	found, err := s.Posts.Create(t.Context(), "Searchable", "This mentions "+word+" once", topicID, userID)
+	check(t, err)
+	results, err := s.Posts.SearchPost(t.Context(), word, firstPage(10))
+	check(t, err)
+	expectIDs(t, "SearchPost", postIDs(results.Items), []int64{found})
+
+	check(t, s.Posts.Delete(t.Context(), postID))
+	if deleted, err := s.Posts.GetByID(t.Context(), postID, userID); err != nil || deleted != nil {
+		t.Fatalf("GetByID of a deleted post returned %+v, %v", deleted, err)
+	}
+}
+
+func testPostRevisions(t *testing.T, s models.Stores) {
+	author, editor := newUser(t, s), newUser(t, s)
+	topicID := newTopic(t, s, author)
+	postID, err := s.Posts.Create(t.Context(), "First", "First content", topicID, author)
+	check(t, err)
+	check(t, s.Posts.Update(t.Context(), postID, author, "Second", "Second content"))
+	check(t, s.Posts.Update(t.Context(), postID, editor, "Third", "Third content"))
+	post, err := s.Posts.GetByID(t.Context(), postID, author)
+	check(t, err)
+	if post.Title != "Third" || post.Content != "Third content" || post.EditCount != 2 || !post.Edited {
+		t.Fatalf("after two edits: %+v", post)
+	}
+	revisions, err := s.Posts.Revisions(t.Context(), postID)
+	check(t, err)
+	if len(revisions) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revisions))
+	}
+	//Revision n holds what edit n replaced
+	if r := revisions[0]; r.Revision != 1 || r.Title != "First" || r.Content != "First content" || r.EditorID != author || r.EditorUsername == "" {
+		t.Fatalf("first revision is %+v", r)
+	}
+	if r := revisions[1]; r.Revision != 2 || r.Title != "Second" || r.EditorID != editor {
+		t.Fatalf("second revision is %+v", r)
+	}
+	revision, err := s.Posts.GetRevision(t.Context(), postID, 2)
+	check(t, err)
+	if revision == nil || revision.Title != "Second" {
+		t.Fatalf("GetRevision returned %+v", revision)
+	}
+	if missing, err := s.Posts.GetRevision(t.Context(), postID, 3); err != nil || missing != nil {
+		t.Fatalf("GetRevision of a missing revision returned %+v, %v", missing, err)
+	}
+	if err := s.Posts.Update(t.Context(), 0, author, "Nothing", "Nothing"); err == nil {
+		t.Fatal("updating a missing post succeeded")
+	}
+}
+
+func testPostLikes(t *testing.T, s models.Stores) {
+	author, liker := newUser(t, s), newUser(t, s)
+	postID := newPost(t, s, newTopic(t, s, author), author)
+
+	//Setting a like is idempotent, the toggle flips it
+	if changed, err := s.Posts.SetLike(t.Context(), postID, liker, true); err != nil || !changed {
+		t.Fatalf("SetLike returned %v, %v", changed, err)
+	}
+	if changed, err := s.Posts.SetLike(t.Context(), postID, liker, true); err != nil || changed {
+		t.Fatalf("repeated SetLike returned %v, %v", changed, err)
+	}
+	state, err := s.Posts.LikeState(t.Context(), postID, liker)
+	check(t, err)
+	if state == nil || state.Likes != 1 || !state.Liked {
+		t.Fatalf("LikeState returned %+v", state)
+	}
+	check(t, s.Posts.LikePost(t.Context(), postID, liker))
+	state, err = s.Posts.LikeState(t.Context(), postID, liker)
+	check(t, err)
+	if state.Likes != 0 || state.Liked {
+		t.Fatalf("LikeState after toggling returned %+v", state)
+	}
+	check(t, s.Posts.LikePost(t.Context(), postID, liker))
+	post, err := s.Posts.GetByID(t.Context(), postID, liker)
+	check(t, err)
+	if post.Likes != 1 || !post.LikedByUser {
+		t.Fatalf("after liking: likes %d, liked_by_user %v", post.Likes, post.LikedByUser)
+	}
+	if state, err := s.Posts.LikeState(t.Context(), 0, liker); err != nil || state != nil {
+		t.Fatalf("LikeState of a missing post returned %+v, %v", state, err)
+	}
+
+	//Reactions count likes as well
+	if changed, err := s.Posts.SetReaction(t.Context(), postID, author, "love", true); err != nil || !changed {
+		t.Fatalf("SetReaction returned %v, %v", changed, err)
+	}
+	if changed, err := s.Posts.SetReaction(t.Context(), postID, author, "love", true); err != nil || changed {
+		t.Fatalf("repeated SetReaction returned %v, %v", changed, err)
+	}
+	reactions, err := s.Posts.Reactions(t.Context(), postID, author)
+	check(t, err)
+	if reactions.Counts[models.ReactionLike] != 1 || reactions.Counts["love"] != 1 || !slices.Equal(reactions.Mine, []string{"love"}) {
+		t.Fatalf("Reactions returned %+v", reactions)
+	}
+	if changed, err := s.Posts.SetReaction(t.Context(), postID, liker, models.ReactionLike, false); err != nil || !changed {
+		t.Fatalf("removing the like reaction returned %v, %v", changed, err)
+	}
+	post, err = s.Posts.GetByID(t.Context(), postID, author)
+	check(t, err)
+	if post.Likes != 0 || post.Reactions.Counts[models.ReactionLike] != 0 || post.Reactions.Counts["love"] != 1 {
+		t.Fatalf("after removing the like: likes %d, reactions %+v", post.Likes, post.Reactions)
+	}
+	check(t, s.Posts.LikePost(t.Context(), postID, liker))
+	if changed, err := s.Posts.SetReaction(t.Context(), postID, author, "love", false); err != nil || !changed {
+		t.Fatalf("removing a reaction returned %v, %v", changed, err)
+	}
+	reactions, err = s.Posts.Reactions(t.Context(), postID, liker)
+	check(t, err)
+	if reactions.Counts["love"] != 0 || !slices.Equal(reactions.Mine, []string{models.ReactionLike}) {
+		t.Fatalf("Reactions returned %+v", reactions)
+	}
+}
+
+func testPostPages(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	topicID := newTopic(t, s, userID)
+	var created []int64
+	for i := 0; i < 5; i++ {
+		created = append(created, newPost(t, s, topicID, userID))
+	}
+
+	newest := models.PostListOptions{Sort: models.SortNew}
+	var seen []int64
+	page, err := s.Posts.AllByTopicID(t.Context(), topicID, userID, newest, firstPage(2))
+	check(t, err)
+	seen = append(seen, postIDs(page.Items)...)
+	for page.NextCursor != "" {
+		page, err = s.Posts.AllByTopicID(t.Context(), topicID, userID, newest, pageAt(t, 2, page.NextCursor))
+		check(t, err)
+		seen = append(seen, postIDs(page.Items)...)
+	}
+	want := slices.Clone(created)
+	slices.Reverse(want)
+	expectIDs(t, "newest first", seen, want)
+
+	//The most liked post comes first, ties by ID
+	likers := []int64{newUser(t, s), newUser(t, s)}
+	for _, liker := range likers {
+		_, err := s.Posts.SetLike(t.Context(), created[1], liker, true)
+		check(t, err)
+	}
+	_, err = s.Posts.SetLike(t.Context(), created[3], likers[0], true)
+	check(t, err)
+	for _, sort := range []string{models.SortTop, models.SortHot, models.SortRising} {
+		opts := models.PostListOptions{Sort: sort}
+		page, err = s.Posts.AllByTopicID(t.Context(), topicID, userID, opts, firstPage(3))
+		check(t, err)
+		if sort == models.SortTop {
+			expectIDs(t, sort, postIDs(page.Items), []int64{created[1], created[3], created[4]})
+		} else if page.Items[0].ID != created[1] {
+			t.Fatalf("%s: first post is %d, want %d", sort, page.Items[0].ID, created[1])
+		}
+		rest, err := s.Posts.AllByTopicID(t.Context(), topicID, userID, opts, pageAt(t, 3, page.NextCursor))
+		check(t, err)
+		all := append(postIDs(page.Items), postIDs(rest.Items)...)
+		if len(all) != len(created) || rest.NextCursor != "" {
+			t.Fatalf("%s: paging returned %v", sort, all)
+		}
+	}
+
+	//Since leaves out older posts
+	since, err := s.Posts.AllByTopicID(t.Context(), topicID, userID, models.PostListOptions{Sort: models.SortNew, Since: time.Now().Add(time.Hour)}, firstPage(10))
+	check(t, err)
+	if len(since.Items) != 0 {
+		t.Fatalf("posts created before since were listed: %v", postIDs(since.Items))
+	}
+
+	everything, err := s.Posts.GetAll(t.Context(), userID, newest, firstPage(100))
+	check(t, err)
+	if !slices.Contains(postIDs(everything.Items), created[4]) {
+		t.Fatal("GetAll is missing the newest post")
+	}
+}
+
+func testFeed(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	followed, other := newTopic(t, s, userID), newTopic(t, s, userID)
+	inFollowed := newPost(t, s, followed, userID)
+	newPost(t, s, other, userID)
+	check(t, s.Topics.Subscribe(t.Context(), followed, userID))
+	feed, err := s.Posts.Feed(t.Context(), userID, models.PostListOptions{Sort: models.SortNew}, firstPage(10))
+	check(t, err)
+	expectIDs(t, "Feed", postIDs(feed.Items), []int64{inFollowed})
+}
+
+func testComments(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	postID := newPost(t, s, newTopic(t, s, userID), userID)
+	first := newComment(t, s, postID, userID, 0)
+	reply := newComment(t, s, postID, userID, first)
+	second := newComment(t, s, postID, userID, 0)
+
+	comment, err := s.Comments.GetByID(t.Context(), reply)
+	check(t, err)
+	if comment == nil || comment.PostID != postID || comment.UserID != userID || comment.ParentCommentID.Int64 != first || comment.Content != "A comment" ||
+		comment.ContentHTML == "" || comment.CreatedByUsername == "" || comment.Deleted {
+		t.Fatalf("GetByID returned %+v", comment)
+	}
+	if missing, err := s.Comments.GetByID(t.Context(), 0); err != nil || missing != nil {
+		t.Fatalf("GetByID of a missing comment returned %+v, %v", missing, err)
+	}
+	if _, err := s.Comments.Create(t.Context(), 0, userID, "Nowhere", sql.NullInt64{}); err == nil {
+		t.Fatal("creating a comment under a missing post succeeded")
+	}
+	post, err := s.Posts.GetByID(t.Context(), postID, userID)
+	check(t, err)
+	if post.CommentCount != 3 {
+		t.Fatalf("comment_count is %d, want 3", post.CommentCount)
+	}
+
+	//Oldest first
+	page, err := s.Comments.AllByPostID(t.Context(), postID, userID, firstPage(2))
+	check(t, err)
+	commentIDs := func(comments []models.Comment) []int64 {
+		return ids(comments, func(c models.Comment) int64 { return c.ID })
+	}
+	expectIDs(t, "first page", commentIDs(page.Items), []int64{first, reply})
+	page, err = s.Comments.AllByPostID(t.Context(), postID, userID, pageAt(t, 2, page.NextCursor))
+	check(t, err)
+	expectIDs(t, "second page", commentIDs(page.Items), []int64{second})
+
+	grandchild := newComment(t, s, postID, userID, reply)
+	ancestors, err := s.Comments.Ancestors(t.Context(), grandchild, userID, 5)
+	check(t, err)
+	expectIDs(t, "Ancestors", commentIDs(ancestors), []int64{first, reply})
+	ancestors, err = s.Comments.Ancestors(t.Context(), grandchild, userID, 1)
+	check(t, err)
+	expectIDs(t, "one level of ancestors", commentIDs(ancestors), []int64{reply})
+
+	//Deleted and redacted comments leave the comment count, a comment redacted after being deleted only once
+	commentCount := func(what string, want int) {
+		t.Helper()
+		post, err := s.Posts.GetByID(t.Context(), postID, userID)
+		check(t, err)
+		if post.CommentCount != want {
+			t.Fatalf("comment_count is %d after %s, want %d", post.CommentCount, what, want)
+		}
+	}
+	commentCount("the grandchild", 4)
+	check(t, s.Comments.Delete(t.Context(), grandchild, userID))
+	commentCount("Delete", 3)
+	check(t, s.Comments.Redact(t.Context(), grandchild, userID, "legal"))
+	commentCount("redacting a deleted comment", 3)
+	check(t, s.Comments.Redact(t.Context(), second, userID, "legal"))
+	commentCount("Redact", 2)
+}
+
+func testCommentRevisions(t *testing.T, s models.Stores) {
+	author, admin := newUser(t, s), newUser(t, s)
+	postID := newPost(t, s, newTopic(t, s, author), author)
+	commentID := newComment(t, s, postID, author, 0)
+
+	check(t, s.Comments.Update(t.Context(), commentID, author, "Edited"))
+	comment, err := s.Comments.GetByID(t.Context(), commentID)
+	check(t, err)
+	if comment.Content != "Edited" {
+		t.Fatalf("content is %q after Update", comment.Content)
+	}
+	check(t, s.Comments.Delete(t.Context(), commentID, author))
+	comment, err = s.Comments.GetByID(t.Context(), commentID)
+	check(t, err)
+	if !comment.Deleted {
+		t.Fatal("comment isn't deleted after Delete")
+	}
+	if err := s.Comments.Delete(t.Context(), commentID, author); !errors.Is(err, models.ErrCommentDeleted) {
+		t.Fatalf("deleting a deleted comment returned %v", err)
+	}
+	if err := s.Comments.Update(t.Context(), commentID, author, "Again"); !errors.Is(err, models.ErrCommentDeleted) {
+		t.Fatalf("editing a deleted comment returned %v", err)
+	}
+	if err := s.Comments.Update(t.Context(), 0, author, "Nothing"); err == nil {
+		t.Fatal("editing a missing comment succeeded")
+	}
+
+	revisions, err := s.Comments.Revisions(t.Context(), commentID)
+	check(t, err)
+	actions := ids(revisions, func(r models.CommentRevision) int64 { return int64(r.Revision) })
+	expectIDs(t, "revision numbers", actions, []int64{1, 2})
+	if r := revisions[0]; r.Action != models.CommentEdited || r.Content != "A comment" || r.EditorUsername == "" {
+		t.Fatalf("first revision is %+v", r)
+	}
+	if r := revisions[1]; r.Action != models.CommentDeleted || r.Content != "Edited" {
+		t.Fatalf("second revision is %+v", r)
+	}
+
+	//A queued webhook delivery carrying the comment, and one that doesn't
+	webhookID, err := s.Webhooks.Create(t.Context(), "https://example.com/redact", "secret", []string{"comment.created"}, sql.NullInt64{}, admin)
+	check(t, err)
+	check(t, s.Webhooks.Enqueue(t.Context(), webhookID, "post.created", []byte(`{"event":"post.created","data":{"content":"A post"}}`), sql.NullInt64{}))
+	check(t, s.Webhooks.Enqueue(t.Context(), webhookID, "comment.created",
+		[]byte(`{"event":"comment.created","data":{"id":1,"content":"A comment"}}`), sql.NullInt64{Int64: commentID, Valid: true}))
+
+	//Redacting purges the text everywhere and records who did it
+	check(t, s.Comments.Redact(t.Context(), commentID, admin, "legal"))
+	deliveries, err := s.Webhooks.Deliveries(t.Context(), webhookID, firstPage(10))
+	check(t, err)
+	if len(deliveries.Items) != 2 {
+		t.Fatalf("got %d deliveries after Redact, want 2", len(deliveries.Items))
+	}
+	if d := deliveries.Items[0]; strings.Contains(string(d.Payload), "A comment") || !strings.Contains(string(d.Payload), `"redacted":true`) ||
+		!strings.Contains(string(d.Payload), `"event":"comment.created"`) || d.Status != models.DeliveryPending {
+		t.Fatalf("delivery of the redacted comment is %+v with payload %s", d, d.Payload)
+	}
+	if d := deliveries.Items[1]; !strings.Contains(string(d.Payload), "A post") {
+		t.Fatalf("delivery of another event was scrubbed: %s", d.Payload)
+	}
+	comment, err = s.Comments.GetByID(t.Context(), commentID)
+	check(t, err)
+	if comment.Content != "" || comment.ContentHTML != "" || !comment.Deleted {
+		t.Fatalf("after Redact: %+v", comment)
+	}
+	revisions, err = s.Comments.Revisions(t.Context(), commentID)
+	check(t, err)
+	if len(revisions) != 3 {
+		t.Fatalf("got %d revisions after Redact, want 3", len(revisions))
+	}
+	for _, r := range revisions {
+		if r.Content != "" || !r.Redacted {
+			t.Fatalf("revision %d wasn't redacted: %+v", r.Revision, r)
+		}
+	}
+	if r := revisions[2]; r.Action != models.CommentRedacted || r.Reason != "legal" || r.EditorID != admin {
+		t.Fatalf("redaction revision is %+v", r)
+	}
+	if err := s.Comments.Redact(t.Context(), 0, admin, "legal"); err == nil {
+		t.Fatal("redacting a missing comment succeeded")
+	}
+}
+
+func testCommentLikes(t *testing.T, s models.Stores) {
+	author, liker := newUser(t, s), newUser(t, s)
+	postID := newPost(t, s, newTopic(t, s, author), author)
+	commentID := newComment(t, s, postID, author, 0)
+
+	if changed, err := s.Comments.SetLike(t.Context(), commentID, liker, true); err != nil || !changed {
+		t.Fatalf("SetLike returned %v, %v", changed, err)
+	}
+	if changed, err := s.Comments.SetLike(t.Context(), commentID, liker, true); err != nil || changed {
+		t.Fatalf("repeated SetLike returned %v, %v", changed, err)
+	}
+	check(t, s.Comments.LikeComment(t.Context(), commentID, liker))
+	state, err := s.Comments.LikeState(t.Context(), commentID, liker)
+	check(t, err)
+	if state == nil || state.Likes != 0 || state.Liked {
+		t.Fatalf("LikeState after toggling returned %+v", state)
+	}
+	check(t, s.Comments.LikeComment(t.Context(), commentID, liker))
+	if state, err := s.Comments.LikeState(t.Context(), 0, liker); err != nil || state != nil {
+		t.Fatalf("LikeState of a missing comment returned %+v, %v", state, err)
+	}
+
+	if changed, err := s.Comments.SetReaction(t.Context(), commentID, author, "laugh", true); err != nil || !changed {
+		t.Fatalf("SetReaction returned %v, %v", changed, err)
+	}
+	reactions, err := s.Comments.Reactions(t.Context(), commentID, liker)
+	check(t, err)
+	if reactions.Counts[models.ReactionLike] != 1 || reactions.Counts["laugh"] != 1 || !slices.Equal(reactions.Mine, []string{models.ReactionLike}) {
+		t.Fatalf("Reactions returned %+v", reactions)
+	}
+	page, err := s.Comments.AllByPostID(t.Context(), postID, liker, firstPage(10))
+	check(t, err)
+	if c := page.Items[0]; c.Likes != 1 || !c.LikedByUser || c.Reactions.Counts["laugh"] != 1 {
+		t.Fatalf("listed comment is %+v", c)
+	}
+}
+
+func testCommentTree(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	postID := newPost(t, s, newTopic(t, s, userID), userID)
+	//a has the replies a1, a2 and a3, a1 has the reply a1x and a3 the reply a3x; b has no replies, c has the reply cx
+	a := newComment(t, s, postID, userID, 0)
+	a1 := newComment(t, s, postID, userID, a)
+	a2 := newComment(t, s, postID, userID, a)
+	a3 := newComment(t, s, postID, userID, a)
+	a1x := newComment(t, s, postID, userID, a1)
+	a3x := newComment(t, s, postID, userID, a3)
+	b := newComment(t, s, postID, userID, 0)
+	c := newComment(t, s, postID, userID, 0)
+	cx := newComment(t, s, postID, userID, c)
+
+	nodeIDs := func(nodes []*models.CommentNode) []int64 {
+		return ids(nodes, func(n *models.CommentNode) int64 { return n.ID })
+	}
+	roots, next, err := s.Comments.Tree(t.Context(), postID, sql.NullInt64{}, userID, 2, 2, nil)
+	check(t, err)
+	expectIDs(t, "roots", nodeIDs(roots), []int64{a, b})
+	if next == nil || next.ID != b {
+		t.Fatalf("next cursor is %+v, want one after comment %d", next, b)
+	}
+	root := roots[0]
+	expectIDs(t, "replies", nodeIDs(root.Replies), []int64{a1, a2})
+	if root.Depth != 0 || root.ReplyCount != 3 || !root.HasMoreReplies || root.MoreRepliesCursor == "" {
+		t.Fatalf("root is %+v", root)
+	}
+	//a1x is below the max depth, so it isn't loaded but a1 says there is more
+	if r := root.Replies[0]; r.Depth != 1 || r.ReplyCount != 1 || len(r.Replies) != 0 || !r.HasMoreReplies || r.MoreRepliesCursor != "" {
+		t.Fatalf("first reply is %+v", r)
+	}
+	if roots[1].Replies == nil || roots[1].HasMoreReplies {
+		t.Fatalf("comment without replies is %+v", roots[1])
+	}
+
+	//Only the kept comments are walked down, so neither the replies of a3 nor those of the extra root c show up
+	var walk func(nodes []*models.CommentNode) []int64
+	walk = func(nodes []*models.CommentNode) []int64 {
+		all := []int64{}
+		for _, n := range nodes {
+			all = append(append(all, n.ID), walk(n.Replies)...)
+		}
+		return all
+	}
+	deep, _, err := s.Comments.Tree(t.Context(), postID, sql.NullInt64{}, userID, 3, 2, nil)
+	check(t, err)
+	expectIDs(t, "whole tree", walk(deep), []int64{a, a1, a1x, a2, b})
+
+	roots, next, err = s.Comments.Tree(t.Context(), postID, sql.NullInt64{}, userID, 2, 2, next)
+	check(t, err)
+	expectIDs(t, "roots after the cursor", nodeIDs(roots), []int64{c})
+	expectIDs(t, "replies of the last root", nodeIDs(roots[0].Replies), []int64{cx})
+	if next != nil {
+		t.Fatalf("last page has next cursor %+v", next)
+	}
+
+	//The rest of a's replies, starting after the cursor the tree gave
+	after, err := models.DecodeCursor(root.MoreRepliesCursor)
+	check(t, err)
+	replies, _, err := s.Comments.Tree(t.Context(), postID, sql.NullInt64{Int64: a, Valid: true}, userID, 3, 10, after)
+	check(t, err)
+	expectIDs(t, "remaining replies", nodeIDs(replies), []int64{a3})
+	replies, _, err = s.Comments.Tree(t.Context(), postID, sql.NullInt64{Int64: a, Valid: true}, userID, 3, 10, nil)
+	check(t, err)
+	expectIDs(t, "all replies", nodeIDs(replies), []int64{a1, a2, a3})
+	expectIDs(t, "nested reply", nodeIDs(replies[0].Replies), []int64{a1x})
+	expectIDs(t, "nested reply of the last reply", nodeIDs(replies[2].Replies), []int64{a3x})
+	if replies[0].Replies[0].Depth != 1 {
+		t.Fatalf("depth is relative to the parent, got %d", replies[0].Replies[0].Depth)
+	}
+}
+
+func testCascade(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	topicID := newTopic(t, s, userID)
+	postID := newPost(t, s, topicID, userID)
+	commentID := newComment(t, s, postID, userID, 0)
+	readerID := newUser(t, s)
+	check(t, s.Bookmarks.AddPost(t.Context(), postID, readerID))
+	check(t, s.Bookmarks.AddComment(t.Context(), commentID, readerID))
+	if err := s.Users.Delete(t.Context(), userID); err == nil {
+		t.Fatal("deleting a user that owns content succeeded")
+	}
+
+	//Deleting the topic takes its posts and their comments along
+	check(t, s.Topics.Delete(t.Context(), topicID))
+	if post, err := s.Posts.GetByID(t.Context(), postID, userID); err != nil || post != nil {
+		t.Fatalf("post of a deleted topic is %+v, %v", post, err)
+	}
+	if comment, err := s.Comments.GetByID(t.Context(), commentID); err != nil || comment != nil {
+		t.Fatalf("comment of a deleted topic is %+v, %v", comment, err)
+	}
+	if user, err := s.Users.GetByID(t.Context(), userID); err != nil || user == nil {
+		t.Fatalf("the author went away with their topic: %+v, %v", user, err)
+	}
+	if bookmarks, err := s.Bookmarks.All(t.Context(), readerID, models.BookmarkFilter{}, firstPage(10)); err != nil || len(bookmarks.Items) != 0 {
+		t.Fatalf("bookmarks of a deleted topic are %+v, %v", bookmarks.Items, err)
+	}
+}
+
+func newSession(t *testing.T, s models.Stores, userID int64) string {
+	t.Helper()
+	id := unique("session")
+	check(t, s.Sessions.Create(t.Context(), id, userID, "test agent", "192.0.2.1"))
+	return id
+}
+
+func testSessions(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	otherID := newUser(t, s)
+	first := newSession(t, s, userID)
+	second := newSession(t, s, userID)
+	if active, err := s.Sessions.IsActive(t.Context(), first, userID); err != nil || !active {
+		t.Fatalf("IsActive of a new session = %v, %v", active, err)
+	}
+	if active, err := s.Sessions.IsActive(t.Context(), first, otherID); err != nil || active {
+		t.Fatalf("IsActive for another user = %v, %v", active, err)
+	}
+	sessions, err := s.Sessions.AllByUserID(t.Context(), userID)
+	check(t, err)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	for _, session := range sessions {
+		if session.UserID != userID || session.UserAgent != "test agent" || session.IPAddress != "192.0.2.1" || session.CreatedAt.IsZero() {
+			t.Fatalf("session = %+v", session)
+		}
+	}
+
+	if revoked, err := s.Sessions.Revoke(t.Context(), first, otherID); err != nil || revoked {
+		t.Fatalf("revoking another user's session = %v, %v", revoked, err)
+	}
+	if revoked, err := s.Sessions.Revoke(t.Context(), first, userID); err != nil || !revoked {
+		t.Fatalf("Revoke = %v, %v", revoked, err)
+	}
+	if revoked, err := s.Sessions.Revoke(t.Context(), first, userID); err != nil || revoked {
+		t.Fatalf("revoking twice = %v, %v", revoked, err)
+	}
+	if active, err := s.Sessions.IsActive(t.Context(), first, userID); err != nil || active {
+		t.Fatalf("IsActive of a revoked session = %v, %v", active, err)
+	}
+	sessions, err = s.Sessions.AllByUserID(t.Context(), userID)
+	check(t, err)
+	if len(sessions) != 1 || sessions[0].ID != second {
+		t.Fatalf("sessions after revoking = %+v, want only %s", sessions, second)
+	}
+}
+
+func testRefreshTokens(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	sessionID := newSession(t, s, userID)
+	expires := time.Now().Add(time.Hour)
+	first, second := unique("token"), unique("token")
+	check(t, s.RefreshTokens.Create(t.Context(), sessionID, first, expires))
+	if id, user, err := s.RefreshTokens.GetSession(t.Context(), first); err != nil || id != sessionID || user != userID {
+		t.Fatalf("GetSession = %q, %d, %v", id, user, err)
+	}
+	if id, user, err := s.RefreshTokens.GetSession(t.Context(), unique("token")); err != nil || id != "" || user != 0 {
+		t.Fatalf("GetSession of an unknown token = %q, %d, %v", id, user, err)
+	}
+	if id, user, err := s.RefreshTokens.Rotate(t.Context(), first, second, expires); err != nil || id != sessionID || user != userID {
+		t.Fatalf("Rotate = %q, %d, %v", id, user, err)
+	}
+	if _, _, err := s.RefreshTokens.Rotate(t.Context(), unique("token"), unique("token"), expires); !errors.Is(err, models.ErrRefreshTokenInvalid) {
+		t.Fatalf("rotating an unknown token: %v", err)
+	}
+
+	//Presenting the rotated token again revokes the session, which takes the new token down with it
+	if _, _, err := s.RefreshTokens.Rotate(t.Context(), first, unique("token"), expires); !errors.Is(err, models.ErrRefreshTokenReused) {
+		t.Fatalf("rotating a used token: %v", err)
+	}
+	if active, err := s.Sessions.IsActive(t.Context(), sessionID, userID); err != nil || active {
+		t.Fatalf("session after a reused token is active: %v, %v", active, err)
+	}
+	if _, _, err := s.RefreshTokens.Rotate(t.Context(), second, unique("token"), expires); !errors.Is(err, models.ErrRefreshTokenInvalid) {
+		t.Fatalf("rotating the token of a revoked session: %v", err)
+	}
+
+	expired := unique("token")
+	check(t, s.RefreshTokens.Create(t.Context(), newSession(t, s, userID), expired, time.Now().Add(-time.Minute)))
+	if _, _, err := s.RefreshTokens.Rotate(t.Context(), expired, unique("token"), expires); !errors.Is(err, models.ErrRefreshTokenInvalid) {
+		t.Fatalf("rotating an expired token: %v", err)
+	}
+}
+
+func bookmarkIDs(bookmarks []models.Bookmark) []int64 {
+	return ids(bookmarks, func(b models.Bookmark) int64 { return b.ID })
+}
+
+func testBookmarks(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	topicID := newTopic(t, s, userID)
+	postID := newPost(t, s, topicID, userID)
+	commentID := newComment(t, s, postID, userID, 0)
+	otherTopicID := newTopic(t, s, userID)
+
+	check(t, s.Bookmarks.AddPost(t.Context(), postID, userID))
+	check(t, s.Bookmarks.AddPost(t.Context(), postID, userID)) //Bookmarking again does nothing
+	check(t, s.Bookmarks.AddComment(t.Context(), commentID, userID))
+	check(t, s.Bookmarks.AddComment(t.Context(), -1, userID)) //Neither does bookmarking a comment that doesn't exist
+
+	post, err := s.Posts.GetByID(t.Context(), postID, userID)
+	check(t, err)
+	if !post.BookmarkedByUser {
+		t.Fatal("bookmarked post is not marked as bookmarked")
+	}
+	comments, err := s.Comments.AllByPostID(t.Context(), postID, userID, firstPage(10))
+	check(t, err)
+	if len(comments.Items) != 1 || !comments.Items[0].BookmarkedByUser {
+		t.Fatalf("bookmarked comment is not marked as bookmarked: %+v", comments.Items)
+	}
+
+	all, err := s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{}, firstPage(10))
+	check(t, err)
+	if len(all.Items) != 2 {
+		t.Fatalf("got %d bookmarks, want 2", len(all.Items))
+	}
+	//Newest first, so the comment comes first
+	commentBookmark, postBookmark := all.Items[0], all.Items[1]
+	if commentBookmark.Type != models.BookmarkComment || commentBookmark.Comment == nil || commentBookmark.Comment.ID != commentID ||
+		!commentBookmark.Comment.BookmarkedByUser || commentBookmark.Post.ID != postID {
+		t.Fatalf("comment bookmark = %+v", commentBookmark)
+	}
+	if postBookmark.Type != models.BookmarkPost || postBookmark.Comment != nil || postBookmark.Post.ID != postID ||
+		!postBookmark.Post.BookmarkedByUser || postBookmark.Post.TopicTitle == "" {
+		t.Fatalf("post bookmark = %+v", postBookmark)
+	}
+
+	//Filters and pages
+	page, err := s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{Type: models.BookmarkPost}, firstPage(10))
+	check(t, err)
+	expectIDs(t, "post bookmarks", bookmarkIDs(page.Items), []int64{postBookmark.ID})
+	page, err = s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{Type: models.BookmarkComment, TopicID: topicID}, firstPage(10))
+	check(t, err)
+	expectIDs(t, "comment bookmarks of the topic", bookmarkIDs(page.Items), []int64{commentBookmark.ID})
+	page, err = s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{TopicID: otherTopicID}, firstPage(10))
+	check(t, err)
+	expectIDs(t, "bookmarks of another topic", bookmarkIDs(page.Items), []int64{})
+	page, err = s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{}, firstPage(1))
+	check(t, err)
+	expectIDs(t, "first page", bookmarkIDs(page.Items), []int64{commentBookmark.ID})
+	page, err = s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{}, pageAt(t, 1, page.NextCursor))
+	check(t, err)
+	expectIDs(t, "second page", bookmarkIDs(page.Items), []int64{postBookmark.ID})
+	if page.NextCursor != "" {
+		t.Fatal("last page has a next cursor")
+	}
+	if other, err := s.Bookmarks.All(t.Context(), newUser(t, s), models.BookmarkFilter{}, firstPage(10)); err != nil || len(other.Items) != 0 {
+		t.Fatalf("another user sees %+v, %v", other.Items, err)
+	}
+
+	check(t, s.Bookmarks.RemovePost(t.Context(), postID, userID))
+	page, err = s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{}, firstPage(10))
+	check(t, err)
+	expectIDs(t, "after removing the post", bookmarkIDs(page.Items), []int64{commentBookmark.ID})
+	check(t, s.Bookmarks.RemoveComment(t.Context(), commentID, userID))
+	page, err = s.Bookmarks.All(t.Context(), userID, models.BookmarkFilter{}, firstPage(10))
+	check(t, err)
+	expectIDs(t, "after removing the comment", bookmarkIDs(page.Items), []int64{})
+}
+
+func notificationIDs(items []notifications.Notification) []int64 {
+	return ids(items, func(n notifications.Notification) int64 { return n.ID })
+}
+
+func testNotifications(t *testing.T, s models.Stores) {
+	userID := newUser(t, s)
+	actorID := newUser(t, s)
+	actor, err := s.Users.GetByID(t.Context(), actorID)
+	check(t, err)
+	moderation := notifications.Notification{UserID: userID, Kind: notifications.KindModeration, ActorID: sql.NullInt64{Int64: actorID, Valid: true}, Message: "Removed"}
+	for range 3 {
+		check(t, s.Notifications.Create(t.Context(), moderation))
+	}
+	//Users aren't notified about their own actions
+	own := moderation
+	own.ActorID = sql.NullInt64{Int64: userID, Valid: true}
+	check(t, s.Notifications.Create(t.Context(), own))
+	if count, err := s.Notifications.UnreadCount(t.Context(), userID); err != nil || count != 3 {
+		t.Fatalf("UnreadCount = %d, %v, want 3", count, err)
+	}
+
+	all, err := s.Notifications.List(t.Context(), userID, false, 10, nil)
+	check(t, err)
+	if len(all.Items) != 3 {
+		t.Fatalf("got %d notifications, want 3", len(all.Items))
+	}
+	for _, n := range all.Items {
+		if n.Kind != notifications.KindModeration || n.ActorUsername != actor.Username || n.Message != "Removed" || n.Read || n.CreatedAt.IsZero() {
+			t.Fatalf("notification = %+v", n)
+		}
+	}
+	newest, oldest := all.Items[0].ID, all.Items[2].ID
+	if newest < oldest {
+		t.Fatalf("notifications are not newest first: %v", notificationIDs(all.Items))
+	}
+	page, err := s.Notifications.List(t.Context(), userID, false, 2, nil)
+	check(t, err)
+	expectIDs(t, "first page", notificationIDs(page.Items), notificationIDs(all.Items[:2]))
+	cursor, err := notifications.DecodeCursor(page.NextCursor)
+	check(t, err)
+	page, err = s.Notifications.List(t.Context(), userID, false, 2, cursor)
+	check(t, err)
+	expectIDs(t, "second page", notificationIDs(page.Items), []int64{oldest})
+	cursor, err = notifications.DecodeCursor(page.PrevCursor)
+	check(t, err)
+	page, err = s.Notifications.List(t.Context(), userID, false, 2, cursor)
+	check(t, err)
+	expectIDs(t, "previous page", notificationIDs(page.Items), notificationIDs(all.Items[:2]))
+
+	//Other users can't mark someone else's notifications
+	if marked, err := s.Notifications.MarkRead(t.Context(), actorID, []int64{newest}); err != nil || marked != 0 {
+		t.Fatalf("MarkRead by another user = %d, %v", marked, err)
+	}
+	if marked, err := s.Notifications.MarkRead(t.Context(), userID, []int64{newest}); err != nil || marked != 1 {
+		t.Fatalf("MarkRead = %d, %v, want 1", marked, err)
+	}
+	unread, err := s.Notifications.List(t.Context(), userID, true, 10, nil)
+	check(t, err)
+	expectIDs(t, "unread", notificationIDs(unread.Items), notificationIDs(all.Items[1:]))
+	if marked, err := s.Notifications.MarkRead(t.Context(), userID, nil); err != nil || marked != 2 {
+		t.Fatalf("MarkRead of all = %d, %v, want 2", marked, err)
+	}
+	if count, err := s.Notifications.UnreadCount(t.Context(), userID); err != nil || count != 0 {
+		t.Fatalf("UnreadCount after reading all = %d, %v", count, err)
+	}
+}
+
+func webhookIDs(webhooks []models.Webhook) []int64 {
+	return ids(webhooks, func(w models.Webhook) int64 { return w.ID })
+}
+
+// The mention notifications of a user, oldest first
+func mentionsOf(t *testing.T, s models.Stores, userID int64) []notifications.Notification {
+	t.Helper()
+	page, err := s.Notifications.List(t.Context(), userID, false, 50, nil)
+	check(t, err)
+	mentions := []notifications.Notification{}
+	for _, n := range page.Items {
+		if n.Kind == notifications.KindMention {
+			mentions = append(mentions, n)
+		}
+	}
+	slices.Reverse(mentions)
+	return mentions
+}
+
+func testMentions(t *testing.T, s models.Stores) {
+	authorID, bobID, carolID, daveID := newUser(t, s), newUser(t, s), newUser(t, s), newUser(t, s)
+	name := func(userID int64) string {
+		user, err := s.Users.GetByID(t.Context(), userID)
+		check(t, err)
+		return user.Username
+	}
+	author, bob, carol, dave := name(authorID), name(bobID), name(carolID), name(daveID)
+	topicID := newTopic(t, s, authorID)
+	expectMentions := func(what string, userID int64, want ...sql.NullInt64) {
+		t.Helper()
+		got := mentionsOf(t, s, userID)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d mentions, want %d: %+v", what, len(got), len(want), got)
+		}
+		for i, n := range got {
+			if n.ActorID.Int64 != authorID || n.ActorUsername != author || n.CommentID != want[i] || !n.PostID.Valid {
+				t.Fatalf("%s: mention %d is %+v, want comment %+v", what, i, n, want[i])
+			}
+		}
+	}
+	post := sql.NullInt64{}
+
+	//Punctuation after a name is dropped, repeated names, the author, unknown names, emails and code are not mentions
+	postID, err := s.Posts.Create(t.Context(), "Mentions", "Hi @"+bob+", @"+bob+" and (@"+author+") @"+unique("nobody")+
+		" mail@"+carol+" `@"+carol+"`\n```\n@"+dave+"\n```", topicID, authorID)
+	check(t, err)
+	expectMentions("bob after the post", bobID, post)
+	expectMentions("the author", authorID)
+	expectMentions("carol in code", carolID)
+	expectMentions("dave in a code block", daveID)
+
+	//Editing only notifies the users that weren't mentioned yet
+	check(t, s.Posts.Update(t.Context(), postID, authorID, "Mentions", "@"+bob+" @"+carol))
+	expectMentions("bob after the edit", bobID, post)
+	expectMentions("carol after the edit", carolID, post)
+	//Someone edited out and mentioned again is notified again
+	check(t, s.Posts.Update(t.Context(), postID, authorID, "Mentions", "@"+carol))
+	check(t, s.Posts.Update(t.Context(), postID, authorID, "Mentions", "@"+carol+" @"+bob))
+	expectMentions("bob mentioned again", bobID, post, post)
+	expectMentions("carol still mentioned", carolID, post)
+
+	//Comments keep their own mentions, apart from those of the post
+	commentID, err := s.Comments.Create(t.Context(), postID, authorID, "@"+bob+" @"+author, sql.NullInt64{})
+	check(t, err)
+	comment := sql.NullInt64{Int64: commentID, Valid: true}
+	expectMentions("bob after the comment", bobID, post, post, comment)
+	check(t, s.Comments.Update(t.Context(), commentID, authorID, "@"+bob+" @"+dave+"!"))
+	expectMentions("bob after the comment edit", bobID, post, post, comment)
+	expectMentions("dave after the comment edit", daveID, comment)
+	expectMentions("the author after the comments", authorID)
+}
+
+func testWebhooks(t *testing.T, s models.Stores) {
+	ownerID := newUser(t, s)
+	topicID := newTopic(t, s, ownerID)
+	otherTopicID := newTopic(t, s, newUser(t, s))
+	adminID := newUser(t, s)
+	topicWebhook, err := s.Webhooks.Create(t.Context(), "https://example.com/topic", "secret1", []string{"post.created", "post.liked"}, sql.NullInt64{Int64: topicID, Valid: true}, ownerID)
+	check(t, err)
+	forumWebhook, err := s.Webhooks.Create(t.Context(), "https://example.com/forum", "secret2", []string{"post.created"}, sql.NullInt64{}, adminID)
+	check(t, err)
+
+	webhook, err := s.Webhooks.GetByID(t.Context(), topicWebhook)
+	check(t, err)
+	if webhook == nil || webhook.URL != "https://example.com/topic" || webhook.Secret != "secret1" || !slices.Equal(webhook.Events, []string{"post.created", "post.liked"}) ||
+		webhook.TopicID.Int64 != topicID || webhook.CreatedBy != ownerID {
+		t.Fatalf("webhook = %+v", webhook)
+	}
+	owned, err := s.Webhooks.All(t.Context(), ownerID, firstPage(10))
+	check(t, err)
+	expectIDs(t, "webhooks of the owner's topics", webhookIDs(owned.Items), []int64{topicWebhook})
+	if owned.Items[0].Secret != "" {
+		t.Fatal("listing shows the secret")
+	}
+
+	//Only the webhooks made here, a shared database may hold others
+	matching := func(event string, topicID int64) []int64 {
+		webhooks, err := s.Webhooks.Matching(t.Context(), event, topicID)
+		check(t, err)
+		return slices.DeleteFunc(webhookIDs(webhooks), func(id int64) bool { return id != topicWebhook && id != forumWebhook })
+	}
+	expectIDs(t, "post.created in the topic", matching("post.created", topicID), []int64{topicWebhook, forumWebhook})
+	expectIDs(t, "post.liked in the topic", matching("post.liked", topicID), []int64{topicWebhook})
+	expectIDs(t, "post.created in another topic", matching("post.created", otherTopicID), []int64{forumWebhook})
+
+	check(t, s.Webhooks.Enqueue(t.Context(), topicWebhook, "post.created", []byte(`{"n":1}`), sql.NullInt64{}))
+	check(t, s.Webhooks.Enqueue(t.Context(), topicWebhook, "post.liked", []byte(`{"n":2}`), sql.NullInt64{}))
+	deliveries, err := s.Webhooks.Deliveries(t.Context(), topicWebhook, firstPage(1))
+	check(t, err)
+	if len(deliveries.Items) != 1 || deliveries.Items[0].Event != "post.liked" || deliveries.Items[0].Status != models.DeliveryPending ||
+		string(deliveries.Items[0].Payload) != `{"n":2}` {
+		t.Fatalf("first page of deliveries = %+v", deliveries.Items)
+	}
+	last := deliveries.Items[0].ID
+	deliveries, err = s.Webhooks.Deliveries(t.Context(), topicWebhook, pageAt(t, 1, deliveries.NextCursor))
+	check(t, err)
+	if len(deliveries.Items) != 1 || deliveries.Items[0].Event != "post.created" {
+		t.Fatalf("second page of deliveries = %+v", deliveries.Items)
+	}
+	if delivery, err := s.Webhooks.GetDelivery(t.Context(), topicWebhook, last); err != nil || delivery == nil || delivery.ID != last {
+		t.Fatalf("GetDelivery = %+v, %v", delivery, err)
+	}
+	if delivery, err := s.Webhooks.GetDelivery(t.Context(), forumWebhook, last); err != nil || delivery != nil {
+		t.Fatalf("delivery through another webhook = %+v, %v", delivery, err)
+	}
+	if attempts, err := s.Webhooks.Attempts(t.Context(), last); err != nil || len(attempts) != 0 {
+		t.Fatalf("attempts of an unsent delivery = %+v, %v", attempts, err)
+	}
+	if retried, err := s.Webhooks.Retry(t.Context(), last); err != nil || retried {
+		t.Fatalf("retrying a pending delivery = %v, %v", retried, err)
+	}
+
+	check(t, s.Webhooks.Delete(t.Context(), topicWebhook))
+	if webhook, err := s.Webhooks.GetByID(t.Context(), topicWebhook); err != nil || webhook != nil {
+		t.Fatalf("deleted webhook = %+v, %v", webhook, err)
+	}
+	if delivery, err := s.Webhooks.GetDelivery(t.Context(), topicWebhook, last); err != nil || delivery != nil {
+		t.Fatalf("delivery of a deleted webhook = %+v, %v", delivery, err)
+	}
+	check(t, s.Webhooks.Delete(t.Context(), forumWebhook))
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"backend/database"
+	"context"
 	"database/sql"
 	"time"
 )
@@ -17,42 +19,29 @@ type Topic struct {
 	//Additional fields
 	CreatedByUsername string `json:"username"`
 	PostCount         int64  `json:"post_count"`
+	SubscriberCount   int64  `json:"subscriber_count"`
+	Subscribed        bool   `json:"subscribed"`
 }
 
 type TopicDB struct {
 	DB *sql.DB
 }
 
-func (m *TopicDB) All() ([]Topic, error) {
-	rows, err := m.DB.Query(`
-		SELECT t.id, t.title, t.description, t.created_at, t.user_id, u.username, COUNT(p.id) as post_count
-		FROM topics t
-		JOIN users u ON t.user_id = u.id
-		LEFT JOIN posts p ON t.id = p.topic_id
-		GROUP BY t.id, t.title, t.description, t.created_at, t.user_id, u.username`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var topics []Topic
-	for rows.Next() {
-		var t Topic
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.CreatedAt, &t.UserID, &t.CreatedByUsername, &t.PostCount); err != nil {
-			return nil, err
-		}
-		topics = append(topics, t)
-	}
-
-	return topics, nil
+func (m *TopicDB) All(ctx context.Context, userID int64, page PageRequest) (Page[Topic], error) {
+	return m.list(ctx, "", nil, userID, page)
 }
-func (m *TopicDB) GetByID(topicID int64) (*Topic, error) {
-	row := m.DB.QueryRow(`
-		SELECT t.id, t.title, t.description, t.created_at, t.user_id, u.username
+
+// Returns a Topic by ID together with whether the user is subscribed to it
+func (m *TopicDB) GetByID(ctx context.Context, topicID, userID int64) (*Topic, error) {
+	row := m.DB.QueryRowContext(ctx, `
+		SELECT t.id, t.title, t.description, t.created_at, t.user_id, u.username,
+			(SELECT COUNT(*) FROM topic_subscriptions ts WHERE ts.topic_id = t.id) AS subscriber_count,
+			EXISTS (SELECT 1 FROM topic_subscriptions ts WHERE ts.topic_id = t.id AND ts.user_id = ?) AS subscribed
 		FROM topics t
 		JOIN users u ON t.user_id = u.id
-		WHERE t.id = ?`, topicID)
+		WHERE t.id = ?`, userID, topicID)
 	var t Topic
-	if err := row.Scan(&t.ID, &t.Title, &t.Description, &t.CreatedAt, &t.UserID, &t.CreatedByUsername); err != nil {
+	if err := row.Scan(&t.ID, &t.Title, &t.Description, &t.CreatedAt, &t.UserID, &t.CreatedByUsername, &t.SubscriberCount, &t.Subscribed); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -61,71 +50,122 @@ func (m *TopicDB) GetByID(topicID int64) (*Topic, error) {
 
 	return &t, nil
 }
-func (m *TopicDB) Create(title, description string, createdBy int64) (int64, error) {
-	result, err := m.DB.Exec("INSERT INTO topics (title, description, created_at, user_id) VALUES (?, ?, ?, ?)",
+func (m *TopicDB) Create(ctx context.Context, title, description string, createdBy int64) (int64, error) {
+	return database.InsertID(ctx, database.DialectOf(m.DB), m.DB, "INSERT INTO topics (title, description, created_at, user_id) VALUES (?, ?, ?, ?)",
 		title, description, time.Now().UTC(), createdBy)
-	if err != nil {
-		return 0, err
-	}
-	return result.LastInsertId()
 }
-func (m *TopicDB) Delete(topicID int64) error {
-	_, err := m.DB.Exec("DELETE FROM topics WHERE id = ?", topicID)
+func (m *TopicDB) Delete(ctx context.Context, topicID int64) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM topics WHERE id = ?", topicID)
 	return err
 }
-func (m *TopicDB) Update(topicID int64, title, description string) error {
-	_, err := m.DB.Exec("UPDATE topics SET title = ?, description = ? WHERE id = ?", title, description, topicID)
+func (m *TopicDB) Update(ctx context.Context, topicID int64, title, description string) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE topics SET title = ?, description = ? WHERE id = ?", title, description, topicID)
 	return err
 }
-func (m *TopicDB) GetByBatch(batch_size, offset int) ([]Topic, error) {
-	rows, err := m.DB.Query(`SELECT t.id, t.title, t.description, t.created_at, t.user_id, u.username
+func (m *TopicDB) SearchTopic(ctx context.Context, query string, userID int64, page PageRequest) (Page[Topic], error) {
+	where, args := topicSearch(database.DialectOf(m.DB), query)
+	return m.list(ctx, where, args, userID, page)
+}
+
+// Shared query for the topic listings, newest first and paginated with a keyset on (created_at, id)
+func (m *TopicDB) list(ctx context.Context, where string, whereArgs []any, userID int64, page PageRequest) (Page[Topic], error) {
+	key := keyset{Column: "t.created_at", IDColumn: "t.id"}
+	query := `SELECT t.id, t.title, t.description, t.created_at, t.user_id, u.username, COUNT(p.id) as post_count,
+			(SELECT COUNT(*) FROM topic_subscriptions ts WHERE ts.topic_id = t.id) AS subscriber_count,
+			EXISTS (SELECT 1 FROM topic_subscriptions ts WHERE ts.topic_id = t.id AND ts.user_id = ?) AS subscribed
 		FROM topics t
 		JOIN users u ON t.user_id = u.id
-		ORDER BY t.created_at DESC
-		LIMIT  ? OFFSET  ?`, batch_size, offset)
+		LEFT JOIN posts p ON t.id = p.topic_id
+		WHERE 1 = 1`
+	args := []any{userID}
+	if where != "" {
+		query += " AND " + where
+		args = append(args, whereArgs...)
+	}
+	if page.Cursor != nil {
+		cond, condArgs := key.condition(page.Cursor)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	orderBy, _ := key.orderBy(page.Cursor != nil && page.Cursor.Prev)
+	query += `
+		GROUP BY t.id, t.title, t.description, t.created_at, t.user_id, u.username
+		ORDER BY ` + orderBy + " LIMIT ?"
+	args = append(args, page.Limit+1)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return Page[Topic]{}, err
 	}
 	defer rows.Close()
-	var topics []Topic
+	topics := []Topic{}
 	for rows.Next() {
 		var t Topic
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.CreatedAt, &t.UserID, &t.CreatedByUsername); err != nil {
-			return nil, err
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.CreatedAt, &t.UserID, &t.CreatedByUsername, &t.PostCount, &t.SubscriberCount, &t.Subscribed); err != nil {
+			return Page[Topic]{}, err
 		}
 		topics = append(topics, t)
 	}
-	if topics == nil {
-		topics = []Topic{}
+	if err := rows.Err(); err != nil {
+		return Page[Topic]{}, err
 	}
-	return topics, nil
+	return newPage(topics, page, func(t Topic) Cursor {
+		return Cursor{CreatedAt: t.CreatedAt, ID: t.ID}
+	}), nil
 }
-func (m *TopicDB) SearchTopic(query string) ([]Topic, error) {
-	sql_qry := `SELECT t.id, t.title, t.description,  t.created_at,t.user_id, u.username
-	FROM topics t
-	JOIN users u ON t.user_id = u.id
-	WHERE t.title LIKE ? OR t.description LIKE ?
-	ORDER BY t.created_at DESC
-	`
 
-	searchTerm := "%" + query + "%"
+// Checks if the user is a moderator of the topic
+func (m *TopicDB) IsModerator(ctx context.Context, topicID, userID int64) (bool, error) {
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM topic_moderators WHERE topic_id = ? AND user_id = ?)", topicID, userID).Scan(&exists)
+	return exists, err
+}
+
+// Makes the user a moderator of the topic, adding an existing moderator again does nothing
+func (m *TopicDB) AddModerator(ctx context.Context, topicID, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, insertIgnore(database.DialectOf(m.DB), "INTO topic_moderators (topic_id, user_id, created_at) VALUES (?, ?, ?)"), topicID, userID, time.Now().UTC())
+	return err
+}
+
+// Removes the user from the moderators of the topic, returns false if the user was not a moderator
+func (m *TopicDB) RemoveModerator(ctx context.Context, topicID, userID int64) (bool, error) {
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM topic_moderators WHERE topic_id = ? AND user_id = ?", topicID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
 
-	rows, err := m.DB.Query(sql_qry, searchTerm, searchTerm)
+// Returns the moderators of a topic
+func (m *TopicDB) Moderators(ctx context.Context, topicID int64) ([]User, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT u.id, u.username, u.created_at, u.role
+	FROM topic_moderators tm
+	JOIN users u ON tm.user_id = u.id
+	WHERE tm.topic_id = ?
+	ORDER BY tm.created_at`, topicID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-
-	var topics []Topic
+	users := []User{}
 	for rows.Next() {
-		var t Topic
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.CreatedAt, &t.UserID, &t.CreatedByUsername); err != nil {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.CreatedAt, &u.Role); err != nil {
 			return nil, err
 		}
-		topics = append(topics, t)
+		users = append(users, u)
 	}
-	if topics == nil {
-		topics = []Topic{}
-	}
-	return topics, nil
+	return users, nil
+}
+
+// Subscribes the user to the topic, subscribing again does nothing
+func (m *TopicDB) Subscribe(ctx context.Context, topicID, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, insertIgnore(database.DialectOf(m.DB), "INTO topic_subscriptions (topic_id, user_id, created_at) VALUES (?, ?, ?)"), topicID, userID, time.Now().UTC())
+	return err
+}
+
+// Unsubscribes the user from the topic, unsubscribing when not subscribed does nothing
+func (m *TopicDB) Unsubscribe(ctx context.Context, topicID, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM topic_subscriptions WHERE topic_id = ? AND user_id = ?", topicID, userID)
+	return err
 }
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"backend/database"
+	"backend/notifications"
+	"context"
+	"database/sql"
+)
+
+// A transaction that runs callbacks after it commits, e.g. to announce notifications it created.
+// Rolled back transactions drop their callbacks.
+type hookedTx struct {
+	*sql.Tx
+	dialect     database.Dialect
+	listener    notifications.Listener
+	afterCommit []func()
+}
+
+// listener is told about the notifications created in the transaction, can be nil
+func begin(ctx context.Context, db *sql.DB, listener notifications.Listener) (*hookedTx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedTx{Tx: tx, dialect: database.DialectOf(db), listener: listener}, nil
+}
+
+func (t *hookedTx) Dialect() database.Dialect {
+	return t.dialect
+}
+
+func (t *hookedTx) AfterCommit(f func()) {
+	t.afterCommit = append(t.afterCommit, f)
+}
+
+// Tells the listener about a notification once the transaction commits, see notifications.Create
+func (t *hookedTx) Announce(n notifications.Notification) {
+	if t.listener != nil {
+		t.AfterCommit(func() { t.listener(n) })
+	}
+}
+
+func (t *hookedTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	for _, f := range t.afterCommit {
+		f()
+	}
+	return nil
+}
@@ -1,23 +1,43 @@
 package models
 
 import (
+	"backend/database"
+	"context"
 	"database/sql"
+	"strings"
 	"time"
 )
 
+// Global roles, moderators can moderate every topic while admins can additionally manage roles.
+// Users can also be made moderators of single topics, see TopicDB.AddModerator.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
 type User struct {
 	ID int64 `json:"id"`
 
 	Username  string    `json:"username"`
 	CreatedAt time.Time `json:"created_at"`
+	Role      string    `json:"role"`
+
+	//Bcrypt hash of the user's password, never sent to the frontend.
+	//Accounts created before passwords were introduced have a NULL hash and must set one before logging in.
+	PasswordHash sql.NullString `json:"-"`
 }
 
 type UserDB struct {
 	DB *sql.DB
 }
 
-func (m *UserDB) All() ([]User, error) {
-	rows, err := m.DB.Query("SELECT id, username, created_at FROM users")
+func (m *UserDB) All(ctx context.Context) ([]User, error) {
+	rows, err := m.DB.QueryContext(ctx, "SELECT id, username, created_at, role FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -27,7 +47,7 @@ func (m *UserDB) All() ([]User, error) {
 	for rows.Next() {
 		var u User
 
-		if err := rows.Scan(&u.ID, &u.Username, &u.CreatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.CreatedAt, &u.Role); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -35,21 +55,20 @@ func (m *UserDB) All() ([]User, error) {
 
 	return users, nil
 }
-func (m *UserDB) Create(username string) (int64, error) {
-	result, err := m.DB.Exec("INSERT INTO users (username, created_at) VALUES (?, ?)", username, time.Now().UTC())
-	if err != nil {
-		return 0, err
-	}
-	return result.LastInsertId()
+
+// Creates a user, an empty passwordHash creates an account without a password like the ones from before passwords existed
+func (m *UserDB) Create(ctx context.Context, username, passwordHash string) (int64, error) {
+	hash := sql.NullString{String: passwordHash, Valid: passwordHash != ""}
+	return database.InsertID(ctx, database.DialectOf(m.DB), m.DB, "INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)", username, hash, time.Now().UTC())
 }
-func (m *UserDB) Delete(userID int64) error {
-	_, err := m.DB.Exec("DELETE FROM users WHERE id = ?", userID)
+func (m *UserDB) Delete(ctx context.Context, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID)
 	return err
 }
-func (m *UserDB) GetByID(userID int64) (*User, error) {
-	row := m.DB.QueryRow("SELECT * FROM users WHERE id = ?", userID)
+func (m *UserDB) GetByID(ctx context.Context, userID int64) (*User, error) {
+	row := m.DB.QueryRowContext(ctx, "SELECT id, username, created_at, role, password_hash FROM users WHERE id = ?", userID)
 	var u User
-	if err := row.Scan(&u.ID, &u.Username, &u.CreatedAt); err != nil {
+	if err := row.Scan(&u.ID, &u.Username, &u.CreatedAt, &u.Role, &u.PasswordHash); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -57,10 +76,10 @@ func (m *UserDB) GetByID(userID int64) (*User, error) {
 	}
 	return &u, nil
 }
-func (m *UserDB) GetByUsername(username string) (*User, error) {
-	row := m.DB.QueryRow("SELECT * FROM users WHERE username = ?", username)
+func (m *UserDB) GetByUsername(ctx context.Context, username string) (*User, error) {
+	row := m.DB.QueryRowContext(ctx, "SELECT id, username, created_at, role, password_hash FROM users WHERE username = ?", username)
 	var u User
-	if err := row.Scan(&u.ID, &u.Username, &u.CreatedAt); err != nil {
+	if err := row.Scan(&u.ID, &u.Username, &u.CreatedAt, &u.Role, &u.PasswordHash); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -68,3 +87,101 @@ func (m *UserDB) GetByUsername(username string) (*User, error) {
 	}
 	return &u, nil
 }
+
+// Stores the hash of a one-time password setup token for an account without a password, replacing any earlier token.
+// Returns false if the user does not exist or already has a password.
+func (m *UserDB) CreatePasswordSetupToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) (bool, error) {
+	tx, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return false, err
+	}
+	var passwordHash sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT password_hash FROM users WHERE id = ?"+forUpdate(tx.dialect), userID).Scan(&passwordHash)
+	if err != nil || passwordHash.Valid {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM password_setup_tokens WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO password_setup_tokens (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		userID, tokenHash, time.Now().UTC(), expiresAt.UTC()); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Checks that tokenHash is the unexpired password setup token of the user, without using it up
+func (m *UserDB) CheckPasswordSetupToken(ctx context.Context, userID int64, tokenHash string) (bool, error) {
+	var valid bool
+	err := m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM password_setup_tokens WHERE user_id = ? AND token_hash = ? AND expires_at > ?)",
+		userID, tokenHash, time.Now().UTC()).Scan(&valid)
+	return valid, err
+}
+
+// Sets the first password of an account in exchange for its password setup token, which is used up.
+// Returns false if the token is wrong or expired or the account already has a password, nothing changes then.
+func (m *UserDB) SetInitialPassword(ctx context.Context, userID int64, tokenHash, passwordHash string) (bool, error) {
+	tx, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return false, err
+	}
+	//Deleting the token first means only one of two concurrent requests with the same token gets past this
+	result, err := tx.ExecContext(ctx, "DELETE FROM password_setup_tokens WHERE user_id = ? AND token_hash = ? AND expires_at > ?", userID, tokenHash, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	result, err = tx.ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE id = ? AND password_hash IS NULL", passwordHash, userID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Changes the global role of a user, returns false if the user does not exist
+func (m *UserDB) SetRole(ctx context.Context, userID int64, role string) (bool, error) {
+	var exists bool
+	if err := m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+	_, err := m.DB.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, userID)
+	return err == nil, err
+}
+
+// Returns up to limit users whose username starts with prefix, shortest names first so exact matches come up top
+func (m *UserDB) Autocomplete(ctx context.Context, prefix string, limit int) ([]User, error) {
+	//Escape the LIKE wildcards, usernames can contain them
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+	rows, err := m.DB.QueryContext(ctx, "SELECT id, username, created_at, role FROM users WHERE username "+likeEscaped(database.DialectOf(m.DB))+" ORDER BY "+charLength(database.DialectOf(m.DB), "username")+", username LIMIT ?", pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.CreatedAt, &u.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
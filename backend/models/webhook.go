@@ -0,0 +1,372 @@
+package models
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Delivery states, pending deliveries are retried until they succeed or run out of attempts and go dead
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// An outbound webhook. Webhooks without a topic get the events of the whole forum and can only be made by admins,
+// topic owners can add webhooks for their own topic.
+type Webhook struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	//Only returned when the webhook is created, receivers use it to check the signature
+	Secret    string        `json:"secret,omitempty"`
+	TopicID   sql.NullInt64 `json:"topic_id"`
+	CreatedBy int64         `json:"created_by"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func (w *Webhook) Wants(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// One event queued for one webhook, the payload is sent as is on every attempt
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode sql.NullInt64   `json:"last_status_code"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    sql.NullTime    `json:"delivered_at"`
+	//The comment the payload carries, whose redaction scrubs the payload as well
+	CommentID sql.NullInt64 `json:"-"`
+	//Filled in by ClaimDue for the worker
+	URL         string    `json:"-"`
+	Secret      string    `json:"-"`
+	LockedUntil time.Time `json:"-"`
+}
+
+// The delivery log, one row per HTTP request made for a delivery
+type WebhookAttempt struct {
+	Attempt    int           `json:"attempt"`
+	StatusCode sql.NullInt64 `json:"status_code"`
+	Error      string        `json:"error,omitempty"`
+	DurationMS int64         `json:"duration_ms"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+type WebhookDB struct {
+	DB *sql.DB
+}
+
+func (m *WebhookDB) Create(ctx context.Context, url, secret string, events []string, topicID sql.NullInt64, createdBy int64) (int64, error) {
+	return database.InsertID(ctx, database.DialectOf(m.DB), m.DB, "INSERT INTO webhooks (url, secret, events, topic_id, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		url, secret, strings.Join(events, ","), topicID, createdBy, time.Now().UTC())
+}
+
+func (m *WebhookDB) Delete(ctx context.Context, webhookID int64) error {
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", webhookID)
+	return err
+}
+
+const webhookColumns = "w.id, w.url, w.events, w.secret, w.topic_id, w.created_by, w.created_at"
+
+func scanWebhook(row interface{ Scan(...any) error }) (Webhook, error) {
+	var w Webhook
+	var events string
+	err := row.Scan(&w.ID, &w.URL, &events, &w.Secret, &w.TopicID, &w.CreatedBy, &w.CreatedAt)
+	w.Events = strings.Split(events, ",")
+	return w, err
+}
+
+// Returns a webhook including its secret, nil if it doesn't exist
+func (m *WebhookDB) GetByID(ctx context.Context, webhookID int64) (*Webhook, error) {
+	w, err := scanWebhook(m.DB.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks w WHERE w.id = ?", webhookID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Lists webhooks newest first, without their secrets. With ownerID only the webhooks of topics owned by that user are listed.
+func (m *WebhookDB) All(ctx context.Context, ownerID int64, page PageRequest) (Page[Webhook], error) {
+	key := keyset{Column: "w.created_at", IDColumn: "w.id"}
+	query := "SELECT " + webhookColumns + " FROM webhooks w LEFT JOIN topics t ON w.topic_id = t.id WHERE 1 = 1"
+	args := []any{}
+	if ownerID > 0 {
+		query += " AND t.user_id = ?"
+		args = append(args, ownerID)
+	}
+	if page.Cursor != nil {
+		cond, condArgs := key.condition(page.Cursor)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	orderBy, _ := key.orderBy(page.Cursor != nil && page.Cursor.Prev)
+	query += " ORDER BY " + orderBy + " LIMIT ?"
+	args = append(args, page.Limit+1)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page[Webhook]{}, err
+	}
+	defer rows.Close()
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return Page[Webhook]{}, err
+		}
+		w.Secret = ""
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[Webhook]{}, err
+	}
+	return newPage(webhooks, page, func(w Webhook) Cursor {
+		return Cursor{CreatedAt: w.CreatedAt, ID: w.ID}
+	}), nil
+}
+
+// Returns the webhooks that want an event from a topic, the forum wide ones included
+func (m *WebhookDB) Matching(ctx context.Context, event string, topicID int64) ([]Webhook, error) {
+	rows, err := m.DB.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks w WHERE w.topic_id IS NULL OR w.topic_id = ? ORDER BY w.id", topicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var webhooks []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		if w.Wants(event) {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, rows.Err()
+}
+
+// Queues an event for a webhook, the worker picks it up right away
+func (m *WebhookDB) Enqueue(ctx context.Context, webhookID int64, event string, payload []byte, commentID sql.NullInt64) error {
+	now := time.Now().UTC()
+	_, err := m.DB.ExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id, event, payload, comment_id, status, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, 0, ?, ?)",
+		webhookID, event, string(payload), commentID, DeliveryPending, now, now)
+	return err
+}
+
+// The payload of a delivery whose comment was redacted, the data the receiver got is replaced by the comment's ID
+func redactedCommentPayload(payload []byte, commentID int64) []byte {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &envelope); err != nil || envelope == nil {
+		envelope = map[string]json.RawMessage{}
+	}
+	envelope["data"], _ = json.Marshal(map[string]any{"id": commentID, "deleted": true, "redacted": true})
+	body, _ := json.Marshal(envelope)
+	return body
+}
+
+const deliveryColumns = "d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at"
+
+func scanDelivery(row interface{ Scan(...any) error }, extra ...any) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	var lastError sql.NullString
+	dest := append([]any{&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastStatusCode, &lastError, &d.CreatedAt, &d.DeliveredAt}, extra...)
+	err := row.Scan(dest...)
+	d.Payload = json.RawMessage(payload)
+	d.LastError = lastError.String
+	return d, err
+}
+
+// Claims up to limit pending deliveries that are due, for lease. A claimed delivery is skipped by other workers
+// until the lease runs out, so a crashed worker's deliveries are picked up again later.
+func (m *WebhookDB) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT d.id FROM webhook_deliveries d
+	WHERE d.status = ? AND d.next_attempt_at <= ? AND (d.locked_until IS NULL OR d.locked_until < ?)
+	ORDER BY d.next_attempt_at LIMIT ?`, DeliveryPending, now, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	//Whole seconds so the value reads back exactly the same from every database, RecordAttempt compares it
+	lockedUntil := now.Add(lease).Truncate(time.Second)
+	var claimed []WebhookDelivery
+	for _, id := range ids {
+		//Only one worker can win the update, the others see 0 rows affected and move on
+		result, err := m.DB.ExecContext(ctx, `UPDATE webhook_deliveries SET locked_until = ?
+		WHERE id = ? AND status = ? AND (locked_until IS NULL OR locked_until < ?)`, lockedUntil, id, DeliveryPending, now)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		var url, secret string
+		d, err := scanDelivery(m.DB.QueryRowContext(ctx, "SELECT "+deliveryColumns+", w.url, w.secret FROM webhook_deliveries d JOIN webhooks w ON d.webhook_id = w.id WHERE d.id = ?", id), &url, &secret)
+		if err == sql.ErrNoRows {
+			continue // The webhook was deleted in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.URL, d.Secret, d.LockedUntil = url, secret, lockedUntil
+		claimed = append(claimed, d)
+	}
+	return claimed, nil
+}
+
+// Logs an attempt and moves the delivery to its next state, releasing the lease.
+// Nothing is recorded if the lease ran out and another worker claimed the delivery in the meantime, its attempt counts instead.
+func (m *WebhookDB) RecordAttempt(ctx context.Context, d *WebhookDelivery, statusCode sql.NullInt64, errMsg string, duration time.Duration, status string, nextAttemptAt time.Time) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	attempt := d.Attempts + 1
+	var lastError sql.NullString
+	if errMsg != "" {
+		lastError = sql.NullString{String: truncate(errMsg, 512), Valid: true}
+	}
+	//Numbered from the log rather than the attempts column, which starts over when a dead delivery is retried
+	_, err = tx.ExecContext(ctx, `INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms, created_at)
+	SELECT ?, COALESCE(MAX(attempt), 0) + 1, ?, ?, ?, ? FROM webhook_delivery_attempts WHERE delivery_id = ?`,
+		d.ID, statusCode, lastError, duration.Milliseconds(), now, d.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var deliveredAt sql.NullTime
+	if status == DeliveryDelivered {
+		deliveredAt = sql.NullTime{Time: now, Valid: true}
+	}
+	result, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?,
+		delivered_at = ?, locked_until = NULL WHERE id = ? AND locked_until = ?`, status, attempt, nextAttemptAt, statusCode, lastError, deliveredAt, d.ID, d.LockedUntil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return nil
+	}
+	return tx.Commit()
+}
+
+// Lists the deliveries of a webhook, newest first
+func (m *WebhookDB) Deliveries(ctx context.Context, webhookID int64, page PageRequest) (Page[WebhookDelivery], error) {
+	key := keyset{Column: "d.created_at", IDColumn: "d.id"}
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries d WHERE d.webhook_id = ?"
+	args := []any{webhookID}
+	if page.Cursor != nil {
+		cond, condArgs := key.condition(page.Cursor)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	orderBy, _ := key.orderBy(page.Cursor != nil && page.Cursor.Prev)
+	query += " ORDER BY " + orderBy + " LIMIT ?"
+	args = append(args, page.Limit+1)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page[WebhookDelivery]{}, err
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return Page[WebhookDelivery]{}, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[WebhookDelivery]{}, err
+	}
+	return newPage(deliveries, page, func(d WebhookDelivery) Cursor {
+		return Cursor{CreatedAt: d.CreatedAt, ID: d.ID}
+	}), nil
+}
+
+// Returns a delivery of a webhook, nil if it doesn't exist
+func (m *WebhookDB) GetDelivery(ctx context.Context, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	d, err := scanDelivery(m.DB.QueryRowContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries d WHERE d.webhook_id = ? AND d.id = ?", webhookID, deliveryID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Returns the logged attempts of a delivery, oldest first
+func (m *WebhookDB) Attempts(ctx context.Context, deliveryID int64) ([]WebhookAttempt, error) {
+	rows, err := m.DB.QueryContext(ctx, "SELECT attempt, status_code, error, duration_ms, created_at FROM webhook_delivery_attempts WHERE delivery_id = ? ORDER BY attempt", deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attempts := []WebhookAttempt{}
+	for rows.Next() {
+		var a WebhookAttempt
+		var errMsg sql.NullString
+		if err := rows.Scan(&a.Attempt, &a.StatusCode, &errMsg, &a.DurationMS, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Error = errMsg.String
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// Puts a dead delivery back in the queue with a fresh set of attempts, returns false if it wasn't dead
+func (m *WebhookDB) Retry(ctx context.Context, deliveryID int64) (bool, error) {
+	result, err := m.DB.ExecContext(ctx, "UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?",
+		DeliveryPending, time.Now().UTC(), deliveryID, DeliveryDead)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max], "")
+}
@@ -0,0 +1,109 @@
+// Package notifications stores the in-app notifications of users.
+// Notifications are written inside the same transaction as the change that caused them,
+// so the models pass their transaction in as the Execer.
+package notifications
+
+import (
+	"backend/database"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// What a notification is about
+const (
+	KindPostReply    = "post_reply"    //Someone commented on your post
+	KindCommentReply = "comment_reply" //Someone replied to your comment
+	KindPostLike     = "post_like"
+	KindCommentLike  = "comment_like"
+	KindMention      = "mention"
+	KindModeration   = "moderation" //A moderator removed or changed your content, Message says what happened
+)
+
+type Notification struct {
+	ID            int64         `json:"id"`
+	UserID        int64         `json:"user_id"`
+	Kind          string        `json:"kind"`
+	ActorID       sql.NullInt64 `json:"actor_id"`
+	ActorUsername string        `json:"actor_username,omitempty"`
+	PostID        sql.NullInt64 `json:"post_id"`
+	CommentID     sql.NullInt64 `json:"comment_id"`
+	Message       string        `json:"message,omitempty"`
+	Read          bool          `json:"read"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// Either a *sql.DB or a *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Told about every new notification, used to push them to the user's live stream
+type Listener func(Notification)
+
+// Implemented by transactions that tell their Listener about the notifications created in them once they commit
+type announcer interface {
+	Announce(n Notification)
+}
+
+// Implemented by transactions that know the dialect of their database
+type dialecter interface {
+	Dialect() database.Dialect
+}
+
+func dialectOf(db Execer) database.Dialect {
+	switch db := db.(type) {
+	case *sql.DB:
+		return database.DialectOf(db)
+	case dialecter:
+		return db.Dialect()
+	default:
+		return database.MySQL
+	}
+}
+
+// Stores a notification for n.UserID. Users are never notified about their own actions.
+// Notifications created in a transaction are announced once it commits.
+func Create(ctx context.Context, db Execer, n Notification) error {
+	n, created, err := insert(ctx, db, n)
+	if err != nil || !created {
+		return err
+	}
+	if tx, ok := db.(announcer); ok {
+		tx.Announce(n)
+	}
+	return nil
+}
+
+// Returns the stored notification, created is false if it was about the user's own action
+func insert(ctx context.Context, db Execer, n Notification) (stored Notification, created bool, err error) {
+	if n.ActorID.Valid && n.ActorID.Int64 == n.UserID {
+		return n, false, nil
+	}
+	var message sql.NullString
+	if n.Message != "" {
+		message = sql.NullString{String: n.Message, Valid: true}
+	}
+	n.CreatedAt = time.Now().UTC()
+	n.ID, err = database.InsertID(ctx, dialectOf(db), db, "INSERT INTO notifications (user_id, kind, actor_id, post_id, comment_id, message, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		n.UserID, n.Kind, n.ActorID, n.PostID, n.CommentID, message, n.CreatedAt)
+	if err != nil {
+		return n, false, err
+	}
+	return n, true, nil
+}
+
+// Removes the unread like notifications from actorID about a post (commentID not valid) or a comment,
+// so that liking and unliking something over and over doesn't pile up notifications
+func RemoveLike(ctx context.Context, db Execer, actorID, postID int64, commentID sql.NullInt64) error {
+	var err error
+	if commentID.Valid {
+		_, err = db.ExecContext(ctx, "DELETE FROM notifications WHERE kind = ? AND actor_id = ? AND comment_id = ? AND read_at IS NULL",
+			KindCommentLike, actorID, commentID)
+	} else {
+		_, err = db.ExecContext(ctx, "DELETE FROM notifications WHERE kind = ? AND actor_id = ? AND post_id = ? AND read_at IS NULL",
+			KindPostLike, actorID, postID)
+	}
+	return err
+}
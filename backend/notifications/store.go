@@ -0,0 +1,162 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// NotificationDB reads and updates the notifications of a user
+type NotificationDB struct {
+	DB *sql.DB
+	//Told about the notifications created through the store, can be nil
+	Listener Listener
+}
+
+// Stores a notification outside of any transaction, see Create
+func (m *NotificationDB) Create(ctx context.Context, n Notification) error {
+	n, created, err := insert(ctx, m.DB, n)
+	if err != nil || !created {
+		return err
+	}
+	if m.Listener != nil {
+		m.Listener(n)
+	}
+	return nil
+}
+
+// Cursor of the notification list, works like models.Cursor and is just as opaque to clients.
+// Notifications are listed newest first by id.
+type Cursor struct {
+	ID   int64 `json:"i"`
+	Prev bool  `json:"p,omitempty"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decodes a cursor sent by the client, an empty string means the first page and returns nil
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Page of notifications, the same envelope as models.Page
+type Page struct {
+	Items      []Notification `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	PrevCursor string         `json:"prev_cursor,omitempty"`
+}
+
+// Returns a page of the notifications of a user, newest first. With unreadOnly only unread ones are returned.
+func (m *NotificationDB) List(ctx context.Context, userID int64, unreadOnly bool, limit int, cursor *Cursor) (Page, error) {
+	query := `SELECT n.id, n.user_id, n.kind, n.actor_id, COALESCE(u.username, ''), n.post_id, n.comment_id, COALESCE(n.message, ''),
+		n.read_at IS NOT NULL, n.created_at
+	FROM notifications n
+	LEFT JOIN users u ON n.actor_id = u.id
+	WHERE n.user_id = ?`
+	args := []any{userID}
+	if unreadOnly {
+		query += " AND n.read_at IS NULL"
+	}
+	prev := cursor != nil && cursor.Prev
+	if cursor != nil {
+		if prev {
+			query += " AND n.id > ?"
+		} else {
+			query += " AND n.id < ?"
+		}
+		args = append(args, cursor.ID)
+	}
+	//A previous page is read upwards from the cursor and flipped afterwards
+	if prev {
+		query += " ORDER BY n.id ASC LIMIT ?"
+	} else {
+		query += " ORDER BY n.id DESC LIMIT ?"
+	}
+	args = append(args, limit+1)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+	items := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.ActorID, &n.ActorUsername, &n.PostID, &n.CommentID, &n.Message, &n.Read, &n.CreatedAt); err != nil {
+			return Page{}, err
+		}
+		items = append(items, n)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, err
+	}
+	return NewPage(items, limit, cursor), nil
+}
+
+// Builds a page out of up to limit+1 notifications read from the cursor onwards in its direction,
+// newest first for the next page and oldest first for the previous one
+func NewPage(items []Notification, limit int, cursor *Cursor) Page {
+	prev := cursor != nil && cursor.Prev
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+	if prev {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	page := Page{Items: items}
+	if len(items) == 0 {
+		return page
+	}
+	if prev || hasMore {
+		page.NextCursor = Cursor{ID: items[len(items)-1].ID}.Encode()
+	}
+	if (prev && hasMore) || (!prev && cursor != nil) {
+		page.PrevCursor = Cursor{ID: items[0].ID, Prev: true}.Encode()
+	}
+	return page
+}
+
+// Marks notifications of a user as read, all of them if ids is empty. Returns how many were unread.
+func (m *NotificationDB) MarkRead(ctx context.Context, userID int64, ids []int64) (int64, error) {
+	query := "UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL"
+	args := []any{time.Now().UTC(), userID}
+	if len(ids) > 0 {
+		query += " AND id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (m *NotificationDB) UnreadCount(ctx context.Context, userID int64) (int, error) {
+	var count int
+	err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL", userID).Scan(&count)
+	return count, err
+}
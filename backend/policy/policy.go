@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"backend/models"
+	"context"
+)
+
+// Actor is the user making a request, as read from the token in the request context
+type Actor struct {
+	UserID int64
+	Role   string
+}
+
+func (a Actor) IsAdmin() bool {
+	return a.Role == models.RoleAdmin
+}
+
+// Global moderators can moderate every topic, admins are moderators as well
+func (a Actor) IsModerator() bool {
+	return a.Role == models.RoleModerator || a.Role == models.RoleAdmin
+}
+
+// Policy decides what an actor is allowed to do to a piece of content.
+// Handlers should always go through these checks instead of comparing user IDs themselves.
+// Authors can edit and delete their own content, moderators can delete (but not rewrite) other people's content.
+type Policy struct {
+	models.Stores
+}
+
+// Checks if the actor moderates the topic, either globally or as one of the topic's own moderators
+func (p *Policy) moderatesTopic(ctx context.Context, a Actor, topicID int64) (bool, error) {
+	if a.IsModerator() {
+		return true, nil
+	}
+	return p.Topics.IsModerator(ctx, topicID, a.UserID)
+}
+
+func (p *Policy) CanUpdateTopic(ctx context.Context, a Actor, topic *models.Topic) (bool, error) {
+	if topic.UserID == a.UserID || a.IsAdmin() {
+		return true, nil
+	}
+	return p.moderatesTopic(ctx, a, topic.ID)
+}
+
+// Deleting a topic deletes every post in it, so only the creator and admins can do it
+func (p *Policy) CanDeleteTopic(ctx context.Context, a Actor, topic *models.Topic) (bool, error) {
+	return topic.UserID == a.UserID || a.IsAdmin(), nil
+}
+
+func (p *Policy) CanUpdatePost(ctx context.Context, a Actor, post *models.Post) (bool, error) {
+	return post.UserID == a.UserID, nil
+}
+
+func (p *Policy) CanDeletePost(ctx context.Context, a Actor, post *models.Post) (bool, error) {
+	if post.UserID == a.UserID {
+		return true, nil
+	}
+	return p.moderatesTopic(ctx, a, post.TopicID)
+}
+
+func (p *Policy) CanUpdateComment(ctx context.Context, a Actor, comment *models.Comment) (bool, error) {
+	return comment.UserID == a.UserID, nil
+}
+
+func (p *Policy) CanDeleteComment(ctx context.Context, a Actor, comment *models.Comment) (bool, error) {
+	if comment.UserID == a.UserID {
+		return true, nil
+	}
+	return p.moderatesComment(ctx, a, comment)
+}
+
+// The edit history keeps the text of edited and deleted comments, so only the author and the moderators can read it
+func (p *Policy) CanViewCommentHistory(ctx context.Context, a Actor, comment *models.Comment) (bool, error) {
+	if comment.UserID == a.UserID {
+		return true, nil
+	}
+	return p.moderatesComment(ctx, a, comment)
+}
+
+// Redacting purges text for good, which is reserved for admins handling legal takedowns
+func (p *Policy) CanRedactComment(a Actor) bool {
+	return a.IsAdmin()
+}
+
+func (p *Policy) moderatesComment(ctx context.Context, a Actor, comment *models.Comment) (bool, error) {
+	if a.IsModerator() {
+		return true, nil
+	}
+	//Topic moderators can only delete comments in their own topics, so look up the topic of the comment's post
+	post, err := p.Posts.GetByID(ctx, comment.PostID, a.UserID)
+	if err != nil || post == nil {
+		return false, err
+	}
+	return p.moderatesTopic(ctx, a, post.TopicID)
+}
+
+// Only admins can grant and revoke roles
+func (p *Policy) CanManageRoles(a Actor) bool {
+	return a.IsAdmin()
+}
+
+// Forum wide webhooks see every topic so they are admin only, a topic's own webhooks can also be managed by its creator
+func (p *Policy) CanManageWebhook(ctx context.Context, a Actor, webhook *models.Webhook) (bool, error) {
+	if a.IsAdmin() {
+		return true, nil
+	}
+	if !webhook.TopicID.Valid {
+		return false, nil
+	}
+	topic, err := p.Topics.GetByID(ctx, webhook.TopicID.Int64, 0)
+	if err != nil || topic == nil {
+		return false, err
+	}
+	return topic.UserID == a.UserID, nil
+}
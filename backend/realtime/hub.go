@@ -0,0 +1,179 @@
+// Package realtime is the in-process pub/sub hub behind the /api/stream endpoints.
+//
+// Events are published to channels (a post, a topic or a user's notifications) and every subscriber
+// gets the events of the channels it subscribed to. The hub keeps the last historySize events so a
+// client that reconnects with the ID of the last event it saw gets everything it missed.
+// Subscribers that can't keep up are dropped instead of slowing down the publishers, they reconnect and resume.
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	//Number of past events kept for resuming
+	historySize = 1024
+	//Events buffered per subscriber before it counts as too slow and is dropped
+	subscriberBuffer = 64
+)
+
+func PostChannel(postID int64) string {
+	return fmt.Sprintf("post:%d", postID)
+}
+
+func TopicChannel(topicID int64) string {
+	return fmt.Sprintf("topic:%d", topicID)
+}
+
+// The notifications of a user, only that user may subscribe to it
+func UserChannel(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+type Event struct {
+	//Unique per process, sent as the SSE id so clients can resume with Last-Event-ID
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Channels []string        `json:"channels"`
+	Data     json.RawMessage `json:"data"`
+	seq      uint64
+}
+
+type Hub struct {
+	mu sync.Mutex
+	//Random per process, event IDs from before a restart can't be resumed from
+	epoch   string
+	seq     uint64
+	history []Event
+	subs    map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &Hub{epoch: hex.EncodeToString(b), subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the events of its channels until it is closed or dropped by the hub
+type Subscription struct {
+	hub      *Hub
+	channels map[string]bool
+	events   chan Event
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Closed when the subscription ends, either by Close or because the subscriber fell too far behind
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Must be called with the hub locked
+func (h *Hub) remove(s *Subscription) {
+	delete(h.subs, s)
+	s.once.Do(func() { close(s.done) })
+}
+
+// Sends an event to the subscribers of any of the channels. Safe to call on a nil hub, which does nothing.
+func (h *Hub) Publish(eventType string, data any, channels ...string) {
+	if h == nil {
+		return
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	ev := Event{ID: h.epoch + "-" + strconv.FormatUint(h.seq, 10), Type: eventType, Channels: channels, Data: b, seq: h.seq}
+	if len(h.history) == historySize {
+		h.history = append(h.history[:0], h.history[1:]...)
+	}
+	h.history = append(h.history, ev)
+	for s := range h.subs {
+		if !s.wants(ev) {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			//The subscriber's buffer is full, drop it rather than block every publisher on one slow client
+			h.remove(s)
+		}
+	}
+}
+
+func (s *Subscription) wants(ev Event) bool {
+	for _, c := range ev.Channels {
+		if s.channels[c] {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribes to channels. With lastEventID the events published after that one are returned as well,
+// in order, and are followed by the live events on the subscription without gaps or duplicates.
+// resumed is false if the events after lastEventID are no longer known (too old or from before a restart),
+// in which case the client has to reload what it is showing.
+func (h *Hub) Subscribe(channels []string, lastEventID string) (sub *Subscription, replay []Event, resumed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub = &Subscription{hub: h, channels: map[string]bool{}, events: make(chan Event, subscriberBuffer), done: make(chan struct{})}
+	for _, c := range channels {
+		sub.channels[c] = true
+	}
+	h.subs[sub] = struct{}{}
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	epoch, seqStr, ok := strings.Cut(lastEventID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil || epoch != h.epoch || seq > h.seq {
+		return sub, nil, false
+	}
+	//Everything after seq must still be in the history, otherwise some events are lost
+	if seq < h.seq && (len(h.history) == 0 || h.history[0].seq > seq+1) {
+		return sub, nil, false
+	}
+	for _, ev := range h.history {
+		if ev.seq > seq && sub.wants(ev) {
+			replay = append(replay, ev)
+		}
+	}
+	return sub, replay, true
+}
+
+// Event types published by the handlers
+const (
+	EventPostCreated    = "post.created"
+	EventPostUpdated    = "post.updated"
+	EventPostDeleted    = "post.deleted"
+	EventPostLiked      = "post.liked"
+	EventPostReacted    = "post.reacted"
+	EventCommentCreated = "comment.created"
+	EventCommentUpdated = "comment.updated"
+	EventCommentDeleted = "comment.deleted"
+	EventCommentLiked   = "comment.liked"
+	EventCommentReacted = "comment.reacted"
+	EventNotification   = "notification.created"
+)
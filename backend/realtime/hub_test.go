@@ -0,0 +1,186 @@
+package realtime
+
+import (
+	"slices"
+	"testing"
+)
+
+// The events waiting on the subscription, without blocking
+func pending(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case ev := <-sub.Events():
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func eventData(events []Event) []string {
+	var data []string
+	for _, ev := range events {
+		data = append(data, string(ev.Data))
+	}
+	return data
+}
+
+func expectData(t *testing.T, what string, events []Event, want ...string) {
+	t.Helper()
+	if got := eventData(events); !slices.Equal(got, want) {
+		t.Fatalf("%s: got %q, want %q", what, got, want)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	hub := NewHub()
+	sub, replay, resumed := hub.Subscribe([]string{PostChannel(1), UserChannel(7)}, "")
+	defer sub.Close()
+	if replay != nil || !resumed {
+		t.Fatalf("fresh subscription got replay %v, resumed %v", replay, resumed)
+	}
+	hub.Publish(EventPostCreated, 1, PostChannel(2))
+	hub.Publish(EventCommentCreated, 2, PostChannel(1), TopicChannel(1))
+	hub.Publish(EventNotification, 3, UserChannel(8))
+	hub.Publish(EventNotification, 4, UserChannel(7))
+	hub.Publish(EventPostUpdated, 5)
+	events := pending(sub)
+	expectData(t, "events", events, "2", "4")
+	if events[0].Type != EventCommentCreated || events[1].Type != EventNotification {
+		t.Fatalf("event types are %q and %q", events[0].Type, events[1].Type)
+	}
+	if events[0].ID == events[1].ID {
+		t.Fatalf("two events share the ID %q", events[0].ID)
+	}
+
+	//Closing ends the subscription and stops the events
+	sub.Close()
+	<-sub.Done()
+	hub.Publish(EventCommentCreated, 6, PostChannel(1))
+	expectData(t, "events after Close", pending(sub))
+	sub.Close()
+
+	var nilHub *Hub
+	nilHub.Publish(EventPostCreated, 7, PostChannel(1))
+}
+
+func TestResume(t *testing.T) {
+	hub := NewHub()
+	channel := PostChannel(1)
+	hub.Publish(EventCommentCreated, 1, channel)
+	first, _, _ := hub.Subscribe([]string{channel}, "")
+	hub.Publish(EventCommentCreated, 2, channel)
+	hub.Publish(EventCommentCreated, 3, PostChannel(2))
+	hub.Publish(EventCommentCreated, 4, channel)
+	seen := pending(first)
+	first.Close()
+
+	//Resuming after the first event replays only what the channels missed since, in order
+	sub, replay, resumed := hub.Subscribe([]string{channel}, seen[0].ID)
+	if !resumed {
+		t.Fatal("resuming from a known event was not resumed")
+	}
+	expectData(t, "replay", replay, "4")
+	//Live events follow the replay without gaps or duplicates
+	hub.Publish(EventCommentCreated, 5, channel)
+	expectData(t, "live events", pending(sub), "5")
+	sub.Close()
+
+	//Resuming from the last event the first subscription saw only replays the one published since
+	latest, replay, resumed := hub.Subscribe([]string{channel}, seen[1].ID)
+	latest.Close()
+	if !resumed {
+		t.Fatal("resuming from the last seen event was not resumed")
+	}
+	expectData(t, "replay after the last seen event", replay, "5")
+	last, replay, resumed := hub.Subscribe([]string{channel}, hub.epoch+"-5")
+	last.Close()
+	if !resumed || replay != nil {
+		t.Fatalf("resuming from the latest event got replay %q, resumed %v", eventData(replay), resumed)
+	}
+
+	for _, id := range []string{"", "nonsense", "deadbeef-1", hub.epoch + "-99", hub.epoch + "-x"} {
+		sub, replay, resumed := hub.Subscribe([]string{channel}, id)
+		sub.Close()
+		if id == "" {
+			if !resumed || replay != nil {
+				t.Fatalf("subscribing without an ID got replay %q, resumed %v", eventData(replay), resumed)
+			}
+			continue
+		}
+		if resumed || replay != nil {
+			t.Fatalf("resuming from %q got replay %q, resumed %v", id, eventData(replay), resumed)
+		}
+	}
+}
+
+func TestResumeAfterHistory(t *testing.T) {
+	hub := NewHub()
+	channel := PostChannel(1)
+	sub, _, _ := hub.Subscribe([]string{channel}, "")
+	hub.Publish(EventCommentCreated, 0, channel)
+	first := pending(sub)[0]
+	sub.Close()
+
+	//Once the events after the last seen one have fallen out of the history the client has to reload
+	for i := 1; i <= historySize+1; i++ {
+		hub.Publish(EventCommentCreated, i, channel)
+	}
+	sub, replay, resumed := hub.Subscribe([]string{channel}, first.ID)
+	sub.Close()
+	if resumed || replay != nil {
+		t.Fatalf("resuming from an event out of the history got %d replayed events, resumed %v", len(replay), resumed)
+	}
+	//The event right before the oldest one kept can still be resumed from
+	second := hub.epoch + "-2"
+	sub, replay, resumed = hub.Subscribe([]string{channel}, second)
+	sub.Close()
+	if !resumed || len(replay) != historySize {
+		t.Fatalf("resuming from %s got %d replayed events, resumed %v", second, len(replay), resumed)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	slow, _, _ := hub.Subscribe([]string{PostChannel(1)}, "")
+	fast, _, _ := hub.Subscribe([]string{PostChannel(1)}, "")
+	defer fast.Close()
+	other, _, _ := hub.Subscribe([]string{PostChannel(2)}, "")
+	defer other.Close()
+
+	//The slow subscriber never reads, a full buffer drops it instead of blocking the publisher
+	for i := 0; i < subscriberBuffer; i++ {
+		hub.Publish(EventCommentCreated, i, PostChannel(1))
+		pending(fast)
+	}
+	select {
+	case <-slow.Done():
+		t.Fatal("subscriber dropped before its buffer was full")
+	default:
+	}
+	hub.Publish(EventCommentCreated, subscriberBuffer, PostChannel(1))
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("subscriber with a full buffer was not dropped")
+	}
+	if got := len(pending(slow)); got != subscriberBuffer {
+		t.Fatalf("dropped subscriber has %d buffered events, want %d", got, subscriberBuffer)
+	}
+	hub.Publish(EventCommentCreated, subscriberBuffer+1, PostChannel(1))
+	if got := len(pending(slow)); got != 0 {
+		t.Fatalf("dropped subscriber got %d more events", got)
+	}
+	slow.Close()
+
+	//The others keep their subscriptions
+	expectData(t, "events of the fast subscriber", pending(fast), "64", "65")
+	select {
+	case <-fast.Done():
+		t.Fatal("subscriber that keeps up was dropped")
+	case <-other.Done():
+		t.Fatal("subscriber of another channel was dropped")
+	default:
+	}
+}
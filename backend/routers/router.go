@@ -3,68 +3,156 @@ package routers
 import (
 	"backend/handlers"
 	"backend/middleware"
-	"database/sql"
+	"backend/models"
+	"backend/notifications"
+	"backend/realtime"
+	"backend/webhooks"
+	"maps"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-func SetupRouter(db *sql.DB, jwtkey []byte) http.Handler {
+// Pushes new notifications to the live stream of their user, the listener the stores given to SetupRouter are made with:
+//
+//	hub := realtime.NewHub()
+//	router := SetupRouter(models.NewStores(db, NotificationListener(hub)), hub, ...)
+func NotificationListener(hub *realtime.Hub) notifications.Listener {
+	return func(n notifications.Notification) {
+		hub.Publish(realtime.EventNotification, n, realtime.UserChannel(n.UserID))
+	}
+}
+
+// stores are what the handlers and the auth middleware keep everything in, usually models.NewStores(db, NotificationListener(hub)).
+// hub pushes new posts, comments and likes to the live streams.
+// allowedOrigins are the frontend origins, the same list the CORS middleware uses.
+// timeouts are the query deadlines of the routes, see middleware.ParseQueryTimeouts.
+// proxies are the reverse proxies whose X-Forwarded-For is believed, see middleware.ParseTrustedProxies.
+// dispatcher gets the webhook events, the caller runs its delivery worker. It can be nil to turn webhooks off.
+func SetupRouter(stores models.Stores, hub *realtime.Hub, jwtkey []byte, allowedOrigins []string, timeouts middleware.QueryTimeouts, proxies middleware.TrustedProxies, dispatcher *webhooks.Dispatcher) http.Handler {
 	r := mux.NewRouter()
 
-	topicsHandler := &handlers.TopicHandler{DB: db}
-	postHandler := &handlers.PostHandler{DB: db}
-	commentHandler := &handlers.CommentHandler{DB: db}
-	userHandler := &handlers.UserHandler{DB: db, JWTKey: jwtkey}
-	searchHandler := &handlers.SearchHandler{DB: db}
-	authMiddleware := &middleware.AuthMiddleware{JWTKey: jwtkey}
+	//The streams stay open for as long as the client listens, so they never get a deadline unless one is configured for them
+	streams := maps.Clone(timeouts.Routes)
+	if streams == nil {
+		streams = map[string]time.Duration{}
+	}
+	for _, path := range []string{"/api/stream", "/api/stream/ws"} {
+		if _, ok := streams[path]; !ok {
+			streams[path] = 0
+		}
+	}
+	timeouts.Routes = streams
+	r.Use(timeouts.Middleware)
+
+	topicsHandler := &handlers.TopicHandler{Stores: stores, Dispatcher: dispatcher}
+	postHandler := &handlers.PostHandler{Stores: stores, Hub: hub, Dispatcher: dispatcher}
+	commentHandler := &handlers.CommentHandler{Stores: stores, Hub: hub, Dispatcher: dispatcher}
+	userHandler := &handlers.UserHandler{Stores: stores, JWTKey: jwtkey, Proxies: proxies}
+	searchHandler := &handlers.SearchHandler{Stores: stores}
+	adminHandler := &handlers.AdminHandler{Stores: stores}
+	renderHandler := &handlers.RenderHandler{}
+	reactionHandler := &handlers.ReactionHandler{}
+	notificationHandler := &handlers.NotificationHandler{Stores: stores}
+	webhookHandler := &handlers.WebhookHandler{Stores: stores}
+	streamHandler := &handlers.StreamHandler{Stores: stores, Hub: hub, AllowedOrigins: allowedOrigins}
+	authMiddleware := &middleware.AuthMiddleware{JWTKey: jwtkey, Sessions: stores.Sessions}
 
 	//Public routes
 
 	//User routes
-	r.HandleFunc("/api/users/login", userHandler.Login).Methods("POST")     // User login
-	r.HandleFunc("/api/users/register", userHandler.Create).Methods("POST") // Create new user
-	r.HandleFunc("/api/users/logout", userHandler.Logout).Methods("POST")   // User logout
+	r.HandleFunc("/api/users/login", userHandler.Login).Methods("POST")                  // User login
+	r.HandleFunc("/api/users/register", userHandler.Create).Methods("POST")              // Create new user
+	r.HandleFunc("/api/users/password/setup", userHandler.SetupPassword).Methods("POST") // Set the first password of a legacy account
+	r.HandleFunc("/api/users/refresh", userHandler.Refresh).Methods("POST")              // Exchange the refresh token for a new access token
+
+	//Admin routes, registered before the other /api subrouters so they are matched first
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	admin.Use(authMiddleware.ValidateToken, middleware.RequireRole(models.RoleAdmin))
+	admin.HandleFunc("/users/{user_id}/role", adminHandler.SetUserRole).Methods("PUT")                               // Grant or revoke a global role
+	admin.HandleFunc("/users/{user_id}/password-setup", adminHandler.CreatePasswordSetupToken).Methods("POST")       // Issue a setup token for an account without a password
+	admin.HandleFunc("/topics/{topic_id}/moderators", adminHandler.AddTopicModerator).Methods("POST")                // Make a user a topic moderator
+	admin.HandleFunc("/topics/{topic_id}/moderators/{user_id}", adminHandler.RemoveTopicModerator).Methods("DELETE") // Remove a topic moderator
+	admin.HandleFunc("/comments/{comment_id}/redact", adminHandler.RedactComment).Methods("POST")                    // Purge the text of a comment and its history
 
 	// Public routes that can optionally read user context
 	optionalAuth := r.PathPrefix("/api").Subrouter()
 	optionalAuth.Use(authMiddleware.OptionalAuthMiddleware)
 
+	optionalAuth.HandleFunc("/users/logout", userHandler.Logout).Methods("POST") // User logout, revokes the session if there is one
+
 	//Topic routes
-	optionalAuth.HandleFunc("/topics", topicsHandler.GetAllTopics).Methods("GET")   // Get all topics
-	optionalAuth.HandleFunc("/topics/{topic_id}", topicsHandler.Get).Methods("GET") // Get topic by ID
+	optionalAuth.HandleFunc("/topics", topicsHandler.GetAllTopics).Methods("GET")                        // Get all topics
+	optionalAuth.HandleFunc("/topics/{topic_id}", topicsHandler.Get).Methods("GET")                      // Get topic by ID
+	optionalAuth.HandleFunc("/topics/{topic_id}/moderators", topicsHandler.GetModerators).Methods("GET") // Get the moderators of a topic
 	//Comment routes
 	optionalAuth.HandleFunc("/posts/{post_id}/comments", commentHandler.GetAllPostComments).Methods("GET") // Get all comments for a post
 	optionalAuth.HandleFunc("/comments/{comment_id}", commentHandler.GetCommentByID).Methods("GET")        // Get comment by ID
+	optionalAuth.HandleFunc("/comments/{comment_id}/replies", commentHandler.GetReplies).Methods("GET")    // Get the replies of a comment as a tree
 	// Post routes
-	optionalAuth.HandleFunc("/topics/{topic_id}/posts", postHandler.GetAllTopicPosts).Methods("GET") // Get all posts for a topic
-	optionalAuth.HandleFunc("/posts/{post_id}", postHandler.GetPostByID).Methods("GET")              // Get Post by ID
+	optionalAuth.HandleFunc("/topics/{topic_id}/posts", postHandler.GetAllTopicPosts).Methods("GET")             // Get all posts for a topic
+	optionalAuth.HandleFunc("/posts/{post_id}", postHandler.GetPostByID).Methods("GET")                          // Get Post by ID
+	optionalAuth.HandleFunc("/posts/{post_id}/revisions", postHandler.GetRevisions).Methods("GET")               // Get the edit history of a post
+	optionalAuth.HandleFunc("/posts/{post_id}/revisions/{rev}/diff", postHandler.GetRevisionDiff).Methods("GET") // Diff a revision against the next one
 	optionalAuth.HandleFunc("/posts", postHandler.GetAllPosts).Methods("GET")
 	optionalAuth.HandleFunc("/search", searchHandler.SearchPostAndTopics).Methods("GET") // Search posts and topics
+	optionalAuth.HandleFunc("/reactions", reactionHandler.Kinds).Methods("GET")          // The reaction kinds users can pick from
+	//Live updates, anyone can follow posts and topics but notifications need a login
+	optionalAuth.HandleFunc("/stream", streamHandler.Stream).Methods("GET")       // Server-Sent Events stream
+	optionalAuth.HandleFunc("/stream/ws", streamHandler.WebSocket).Methods("GET") // The same stream over a WebSocket
 	//Protected routes
 	protected := r.PathPrefix("/api").Subrouter()
 	protected.Use(authMiddleware.ValidateToken)
 
 	// User routes
-	protected.HandleFunc("/users", userHandler.Delete).Methods("DELETE") // Delete user
-	protected.HandleFunc("/users/me", userHandler.GetMe).Methods("GET")  // Get current user info
+	protected.HandleFunc("/users", userHandler.Delete).Methods("DELETE")                                 // Delete user
+	protected.HandleFunc("/users/me", userHandler.GetMe).Methods("GET")                                  // Get current user info
+	protected.HandleFunc("/users/autocomplete", userHandler.Autocomplete).Methods("GET")                 // Suggest usernames for @mentions
+	protected.HandleFunc("/users/me/sessions", userHandler.GetSessions).Methods("GET")                   // List the current user's active sessions
+	protected.HandleFunc("/users/me/bookmarks", userHandler.GetBookmarks).Methods("GET")                 // List the current user's saved posts and comments
+	protected.HandleFunc("/users/me/sessions/{session_id}", userHandler.RevokeSession).Methods("DELETE") // Revoke one of the current user's sessions
 
 	//Topic routes
-	protected.HandleFunc("/topics", topicsHandler.CreateTopic).Methods("POST")              // Create new topic
-	protected.HandleFunc("/topics/{topic_id}", topicsHandler.DeleteTopic).Methods("DELETE") // Delete topic by ID
-	protected.HandleFunc("/topics/{topic_id}", topicsHandler.UpdateTopic).Methods("PUT")    // Update topic by ID
+	protected.HandleFunc("/topics", topicsHandler.CreateTopic).Methods("POST")                                    // Create new topic
+	protected.HandleFunc("/topics/{topic_id}", topicsHandler.DeleteTopic).Methods("DELETE")                       // Delete topic by ID
+	protected.HandleFunc("/topics/{topic_id}", topicsHandler.UpdateTopic).Methods("PUT")                          // Update topic by ID
+	protected.HandleFunc("/topics/{topic_id}/subscription", topicsHandler.Subscription).Methods("POST", "DELETE") // Follow or unfollow a topic
 
 	//Comment routes
-	protected.HandleFunc("/comments", commentHandler.Create).Methods("POST")                        // Create new comment
-	protected.HandleFunc("/comments/{comment_id}", commentHandler.Delete).Methods("DELETE")         // Delete comment by ID
-	protected.HandleFunc("/comments/{comment_id}", commentHandler.Update).Methods("PUT")            // Update comment by ID
-	protected.HandleFunc("/comments/{comment_id}/like", commentHandler.LikeComment).Methods("POST") // Like a comment
+	protected.HandleFunc("/comments", commentHandler.Create).Methods("POST")                                       // Create new comment
+	protected.HandleFunc("/comments/{comment_id}", commentHandler.Delete).Methods("DELETE")                        // Delete comment by ID
+	protected.HandleFunc("/comments/{comment_id}", commentHandler.Update).Methods("PUT")                           // Update comment by ID
+	protected.HandleFunc("/comments/{comment_id}/like", commentHandler.LikeComment).Methods("POST")                // Toggle the like on a comment, kept for older clients
+	protected.HandleFunc("/comments/{comment_id}/like", commentHandler.SetLike).Methods("PUT", "DELETE")           // Like or unlike a comment, safe to retry
+	protected.HandleFunc("/comments/{comment_id}/history", commentHandler.GetHistory).Methods("GET")               // Edit history for the author and moderators
+	protected.HandleFunc("/comments/{comment_id}/bookmark", commentHandler.Bookmark).Methods("POST", "DELETE")     // Save a comment for later or unsave it
+	protected.HandleFunc("/comments/{comment_id}/reactions/{kind}", commentHandler.React).Methods("PUT", "DELETE") // Add or remove a reaction
 
 	// Post routes
-	protected.HandleFunc("/posts/{post_id}", postHandler.Delete).Methods("DELETE") // Delete post by ID
-	protected.HandleFunc("/posts", postHandler.Create).Methods("POST")             //Create a new post
-	protected.HandleFunc("/posts/{post_id}", postHandler.Update).Methods("PUT")    // Update a post by ID
-	protected.HandleFunc("/posts/{post_id}/like", postHandler.LikePost).Methods("POST")
+	protected.HandleFunc("/posts/{post_id}", postHandler.Delete).Methods("DELETE")                        // Delete post by ID
+	protected.HandleFunc("/posts", postHandler.Create).Methods("POST")                                    //Create a new post
+	protected.HandleFunc("/posts/{post_id}", postHandler.Update).Methods("PUT")                           // Update a post by ID
+	protected.HandleFunc("/posts/{post_id}/like", postHandler.LikePost).Methods("POST")                   // Toggle the like on a post, kept for older clients
+	protected.HandleFunc("/posts/{post_id}/like", postHandler.SetLike).Methods("PUT", "DELETE")           // Like or unlike a post, safe to retry
+	protected.HandleFunc("/posts/{post_id}/bookmark", postHandler.Bookmark).Methods("POST", "DELETE")     // Save a post for later or unsave it
+	protected.HandleFunc("/posts/{post_id}/reactions/{kind}", postHandler.React).Methods("PUT", "DELETE") // Add or remove a reaction
+	protected.HandleFunc("/feed", postHandler.GetFeed).Methods("GET")                                     // Posts from the topics the user follows
+
+	protected.HandleFunc("/render/preview", renderHandler.Preview).Methods("POST") // Render a Markdown draft exactly as it will be published
+
+	// Notification routes
+	protected.HandleFunc("/notifications", notificationHandler.GetAll).Methods("GET")         // List the current user's notifications
+	protected.HandleFunc("/notifications/read", notificationHandler.MarkRead).Methods("POST") // Mark notifications as read
+
+	// Webhook routes, for admins and topic owners
+	protected.HandleFunc("/webhooks", webhookHandler.Create).Methods("POST")                                                    // Add a webhook, the response has its signing secret
+	protected.HandleFunc("/webhooks", webhookHandler.GetAll).Methods("GET")                                                     // List the webhooks the user can manage
+	protected.HandleFunc("/webhooks/{webhook_id}", webhookHandler.Get).Methods("GET")                                           // Get a webhook
+	protected.HandleFunc("/webhooks/{webhook_id}", webhookHandler.Delete).Methods("DELETE")                                     // Delete a webhook
+	protected.HandleFunc("/webhooks/{webhook_id}/deliveries", webhookHandler.GetDeliveries).Methods("GET")                      // Delivery log of a webhook
+	protected.HandleFunc("/webhooks/{webhook_id}/deliveries/{delivery_id}", webhookHandler.GetDelivery).Methods("GET")          // A delivery with all its attempts
+	protected.HandleFunc("/webhooks/{webhook_id}/deliveries/{delivery_id}/retry", webhookHandler.RetryDelivery).Methods("POST") // Queue a dead delivery again
 
 	return r
 }
@@ -0,0 +1,331 @@
+// Package webhooks delivers forum events to outside services over HTTP.
+//
+// Events are queued in the database, one delivery per matching webhook, and a worker sends them in the background.
+// Every request is a JSON POST signed with the webhook's secret:
+//
+//	X-Webhook-Event:     post.created
+//	X-Webhook-Delivery:  42
+//	X-Webhook-Timestamp: 1700000000
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// Receivers should check the signature with Verify and reject old timestamps to stop replays.
+// A delivery that doesn't get a 2xx response is retried with exponential backoff and is marked dead
+// after maxAttempts, dead deliveries can be retried by hand.
+package webhooks
+
+import (
+	"backend/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Events a webhook can subscribe to
+const (
+	EventPostCreated    = "post.created"
+	EventCommentCreated = "comment.created"
+	EventTopicUpdated   = "topic.updated"
+	EventPostLiked      = "post.liked"
+)
+
+var Events = []string{EventPostCreated, EventCommentCreated, EventTopicUpdated, EventPostLiked}
+
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	//Attempts before a delivery goes dead, with the backoff below the last one is made about 15 hours after the first
+	maxAttempts = 12
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	batchSize   = 20
+	//Timeout of a single request to a receiver
+	requestTimeout = 10 * time.Second
+	//How long a worker has to finish a claimed batch before another worker may take it.
+	//It covers every request of the batch timing out, a shorter lease lets a slow batch be claimed again and sent twice.
+	claimLease = batchSize*requestTimeout + time.Minute
+)
+
+// Generates the secret of a new webhook
+func NewSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Returns the signature header value for a request body sent at timestamp (unix seconds)
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks a signature and that the timestamp is within tolerance of now, for receivers
+func Verify(secret, signature string, timestamp int64, body []byte, now time.Time, tolerance time.Duration) bool {
+	sent := time.Unix(timestamp, 0)
+	if now.Sub(sent) > tolerance || sent.Sub(now) > tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+// The body of every webhook request
+type Payload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Returns the delay before the next try after a failed attempt (1 for the first one)
+func backoff(attempt int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+type Dispatcher struct {
+	DB *sql.DB
+	//Client used for deliveries, nil uses one that refuses to connect to private and loopback addresses
+	Client *http.Client
+}
+
+var errPrivateAddress = errors.New("webhook URL resolves to a private address")
+
+// The special purpose ranges of the IANA registries (RFC 6890 and its updates) webhooks may not reach:
+// private, shared, loopback, link local, documentation, benchmarking, multicast and reserved addresses,
+// and the IPv6 ranges that translate to IPv4 ones. IPv4 mapped IPv6 addresses are checked as IPv4.
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fec0::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+func deniedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// A client that only talks to public addresses and doesn't follow redirects, so webhooks can't be used to reach the internal network
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		//Checked on the resolved address at connect time, checking the URL alone is beaten by DNS rebinding
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || deniedAddress(addr) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   requestTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Queues an event for every webhook that wants it. topicID is the topic the event happened in,
+// commentID the comment data carries (0 if none) so the deliveries are scrubbed if the comment is redacted.
+// Safe to call on a nil dispatcher, which does nothing.
+func (d *Dispatcher) Enqueue(ctx context.Context, event string, topicID, commentID int64, data any) error {
+	if d == nil {
+		return nil
+	}
+	WebhookDB := models.WebhookDB{DB: d.DB}
+	webhooks, err := WebhookDB.Matching(ctx, event, topicID)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	body, err := json.Marshal(Payload{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	for _, w := range webhooks {
+		if err := WebhookDB.Enqueue(ctx, w.ID, event, body, sql.NullInt64{Int64: commentID, Valid: commentID != 0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sends one batch of due deliveries and returns how many were attempted
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	WebhookDB := models.WebhookDB{DB: d.DB}
+	deliveries, err := WebhookDB.ClaimDue(ctx, time.Now().UTC(), claimLease, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i := range deliveries {
+		//Never start a request that could outlive the lease, the rest of the batch is claimed again later
+		if time.Now().Add(requestTimeout).After(deliveries[i].LockedUntil) {
+			return i, nil
+		}
+		if err := d.deliver(ctx, &deliveries[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// Delivers the queue every interval until ctx is cancelled. Full batches are followed by the next one right away.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := d.DeliverDue(ctx)
+		if err != nil {
+			log.Printf("Error delivering webhooks: %v", err)
+		}
+		if n == batchSize && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return defaultClient
+}
+
+var defaultClient = NewClient(false)
+
+// Makes one attempt at a delivery and records the outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	start := time.Now()
+	statusCode, errMsg := d.send(ctx, delivery)
+	duration := time.Since(start)
+	if ctx.Err() != nil {
+		return ctx.Err() // Shutting down, the lease runs out and the delivery is picked up again
+	}
+	status := models.DeliveryDelivered
+	next := delivery.NextAttemptAt
+	if errMsg != "" {
+		status = models.DeliveryPending
+		next = time.Now().UTC().Add(backoff(delivery.Attempts + 1))
+		if delivery.Attempts+1 >= maxAttempts {
+			status = models.DeliveryDead
+		}
+	}
+	var code sql.NullInt64
+	if statusCode != 0 {
+		code = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	}
+	WebhookDB := models.WebhookDB{DB: d.DB}
+	return WebhookDB.RecordAttempt(ctx, delivery, code, errMsg, duration, status, next)
+}
+
+// Sends the request, errMsg is empty if the receiver answered with a 2xx
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) (statusCode int, errMsg string) {
+	//Enforced here as well in case the client has a longer timeout of its own
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "forum-webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, body))
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	//A bit of the response is kept for the delivery log, the rest is drained so the connection can be reused
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := fmt.Sprintf("receiver responded with %s", resp.Status)
+		if s := strings.TrimSpace(string(snippet)); s != "" {
+			msg += ": " + s
+		}
+		return resp.StatusCode, msg
+	}
+	return resp.StatusCode, ""
+}
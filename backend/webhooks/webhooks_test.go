@@ -0,0 +1,304 @@
+package webhooks
+
+import (
+	"backend/database"
+	"backend/migrations"
+	"backend/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A request the test receiver got
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// Sets up a migrated in-memory database with one forum-wide webhook pointing at a receiver that answers with status.
+// Returns the dispatcher, the webhook and the channel the receiver reports its requests on.
+func setup(t *testing.T, status int) (*Dispatcher, *models.Webhook, chan received) {
+	t.Helper()
+	db := database.InitSQLite(":memory:")
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	requests := make(chan received, maxAttempts)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	stores := models.NewStores(db, nil)
+	userID, err := stores.Users.Create(ctx, "webhookowner", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	WebhookDB := models.WebhookDB{DB: db}
+	webhookID, err := WebhookDB.Create(ctx, server.URL, NewSecret(), []string{EventPostCreated}, sql.NullInt64{}, userID)
+	if err != nil {
+		t.Fatalf("creating webhook: %v", err)
+	}
+	webhook, err := WebhookDB.GetByID(ctx, webhookID)
+	if err != nil || webhook == nil {
+		t.Fatalf("loading webhook: %v", err)
+	}
+	return &Dispatcher{DB: db, Client: NewClient(true)}, webhook, requests
+}
+
+// Returns the only delivery of the webhook
+func onlyDelivery(t *testing.T, d *Dispatcher, webhookID int64) models.WebhookDelivery {
+	t.Helper()
+	WebhookDB := models.WebhookDB{DB: d.DB}
+	page, err := WebhookDB.Deliveries(context.Background(), webhookID, models.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatalf("listing deliveries: %v", err)
+	}
+	if len(page.Items) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(page.Items))
+	}
+	return page.Items[0]
+}
+
+func TestDeliverySigned(t *testing.T) {
+	d, webhook, requests := setup(t, http.StatusOK)
+	ctx := context.Background()
+	if err := d.Enqueue(ctx, EventPostCreated, 7, 0, map[string]int{"id": 3}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	//Events the webhook didn't subscribe to are not queued
+	if err := d.Enqueue(ctx, EventPostLiked, 7, 0, map[string]int{"id": 3}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	n, err := d.DeliverDue(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("DeliverDue = %d, %v, want 1 delivery", n, err)
+	}
+	req := <-requests
+
+	if got := req.header.Get(EventHeader); got != EventPostCreated {
+		t.Errorf("%s = %q, want %q", EventHeader, got, EventPostCreated)
+	}
+	timestamp, err := strconv.ParseInt(req.header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", TimestampHeader, err)
+	}
+	signature := req.header.Get(SignatureHeader)
+	if !Verify(webhook.Secret, signature, timestamp, req.body, time.Now(), 5*time.Minute) {
+		t.Errorf("signature %q doesn't verify", signature)
+	}
+	if Verify(NewSecret(), signature, timestamp, req.body, time.Now(), 5*time.Minute) {
+		t.Error("signature verifies with another secret")
+	}
+	if Verify(webhook.Secret, signature, timestamp, append(req.body, ' '), time.Now(), 5*time.Minute) {
+		t.Error("signature verifies with a changed body")
+	}
+	if Verify(webhook.Secret, signature, timestamp, req.body, time.Now().Add(10*time.Minute), 5*time.Minute) {
+		t.Error("signature verifies after the tolerance")
+	}
+	var payload Payload
+	if err := json.Unmarshal(req.body, &payload); err != nil || payload.Event != EventPostCreated {
+		t.Errorf("payload = %s, %v", req.body, err)
+	}
+
+	delivery := onlyDelivery(t, d, webhook.ID)
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 1 || !delivery.DeliveredAt.Valid {
+		t.Errorf("delivery = %+v, want delivered after 1 attempt", delivery)
+	}
+	if got := req.header.Get(DeliveryHeader); got != strconv.FormatInt(delivery.ID, 10) {
+		t.Errorf("%s = %q, want %d", DeliveryHeader, got, delivery.ID)
+	}
+	//Delivered deliveries are not sent again
+	if n, err := d.DeliverDue(ctx); err != nil || n != 0 {
+		t.Errorf("DeliverDue after delivery = %d, %v, want nothing", n, err)
+	}
+}
+
+func TestDeliveryRetriedUntilDead(t *testing.T) {
+	d, webhook, requests := setup(t, http.StatusInternalServerError)
+	ctx := context.Background()
+	if err := d.Enqueue(ctx, EventPostCreated, 7, 0, "hello"); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		before := time.Now().UTC()
+		n, err := d.DeliverDue(ctx)
+		if err != nil || n != 1 {
+			t.Fatalf("attempt %d: DeliverDue = %d, %v, want 1 delivery", attempt, n, err)
+		}
+		<-requests
+		delivery := onlyDelivery(t, d, webhook.ID)
+		if delivery.Attempts != attempt || !delivery.LastStatusCode.Valid || delivery.LastStatusCode.Int64 != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: delivery = %+v", attempt, delivery)
+		}
+		if attempt == maxAttempts {
+			if delivery.Status != models.DeliveryDead {
+				t.Fatalf("status after %d attempts = %q, want %q", attempt, delivery.Status, models.DeliveryDead)
+			}
+			break
+		}
+		if delivery.Status != models.DeliveryPending {
+			t.Fatalf("attempt %d: status = %q, want %q", attempt, delivery.Status, models.DeliveryPending)
+		}
+		//The next try waits for the backoff, a second either way for the precision of the database
+		wait := backoff(attempt)
+		if delivery.NextAttemptAt.Before(before.Add(wait-time.Second)) || delivery.NextAttemptAt.After(time.Now().Add(wait+time.Second)) {
+			t.Fatalf("attempt %d: next attempt at %v, want about %v after %v", attempt, delivery.NextAttemptAt, wait, before)
+		}
+		if n, err := d.DeliverDue(ctx); err != nil || n != 0 {
+			t.Fatalf("attempt %d: DeliverDue during the backoff = %d, %v, want nothing", attempt, n, err)
+		}
+		//Skip the wait
+		if _, err := d.DB.Exec("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?", before.Add(-time.Second), delivery.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//Dead deliveries are left alone until they are retried by hand
+	if n, err := d.DeliverDue(ctx); err != nil || n != 0 {
+		t.Fatalf("DeliverDue of a dead delivery = %d, %v, want nothing", n, err)
+	}
+	WebhookDB := models.WebhookDB{DB: d.DB}
+	delivery := onlyDelivery(t, d, webhook.ID)
+	attempts, err := WebhookDB.Attempts(ctx, delivery.ID)
+	if err != nil || len(attempts) != maxAttempts {
+		t.Fatalf("got %d logged attempts, %v, want %d", len(attempts), err, maxAttempts)
+	}
+	if ok, err := WebhookDB.Retry(ctx, delivery.ID); err != nil || !ok {
+		t.Fatalf("Retry = %v, %v", ok, err)
+	}
+	if n, err := d.DeliverDue(ctx); err != nil || n != 1 {
+		t.Fatalf("DeliverDue after Retry = %d, %v, want 1 delivery", n, err)
+	}
+	<-requests
+	if delivery := onlyDelivery(t, d, webhook.ID); delivery.Status != models.DeliveryPending || delivery.Attempts != 1 {
+		t.Errorf("delivery after Retry = %+v, want pending after 1 attempt", delivery)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, maxBackoff},
+		{maxAttempts, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestDeniedAddress(t *testing.T) {
+	tests := []struct {
+		addr   string
+		denied bool
+	}{
+		{"93.184.215.14", false},
+		{"100.63.255.255", false},
+		{"2606:4700::1111", false},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"10.1.2.3", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"172.16.0.1", true},
+		{"192.0.0.8", true},
+		{"192.168.1.1", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"203.0.113.7", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::", true},
+		{"::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:100.64.0.1", true},
+		{"64:ff9b::a00:1", true},
+		{"2001:db8::1", true},
+		{"2002:a00:1::1", true},
+		{"fd00::1", true},
+		{"fe80::1%eth0", true},
+		{"ff02::1", true},
+	}
+	for _, tt := range tests {
+		if got := deniedAddress(netip.MustParseAddr(tt.addr)); got != tt.denied {
+			t.Errorf("deniedAddress(%s) = %v, want %v", tt.addr, got, tt.denied)
+		}
+	}
+}
+
+// The shared address space of carrier-grade NAT is refused when dialing, before anything is sent
+func TestClientRefusesSharedAddress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://100.64.0.1/hook", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewClient(false).Do(req)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("dialing 100.64.0.1 returned %v, want %v", err, errPrivateAddress)
+	}
+}
+
+// A worker whose lease ran out must not overwrite the outcome of the worker that claimed the delivery after it
+func TestRecordAttemptAfterLeaseLost(t *testing.T) {
+	d, webhook, _ := setup(t, http.StatusOK)
+	ctx := context.Background()
+	if err := d.Enqueue(ctx, EventPostCreated, 7, 0, "hello"); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	WebhookDB := models.WebhookDB{DB: d.DB}
+	now := time.Now().UTC()
+	first, err := WebhookDB.ClaimDue(ctx, now, time.Minute, batchSize)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("ClaimDue = %d, %v, want 1 delivery", len(first), err)
+	}
+	if again, err := WebhookDB.ClaimDue(ctx, now, time.Minute, batchSize); err != nil || len(again) != 0 {
+		t.Fatalf("ClaimDue during the lease = %d, %v, want nothing", len(again), err)
+	}
+	second, err := WebhookDB.ClaimDue(ctx, now.Add(2*time.Minute), time.Minute, batchSize)
+	if err != nil || len(second) != 1 {
+		t.Fatalf("ClaimDue after the lease = %d, %v, want 1 delivery", len(second), err)
+	}
+
+	failed := sql.NullInt64{Int64: http.StatusBadGateway, Valid: true}
+	if err := WebhookDB.RecordAttempt(ctx, &first[0], failed, "too late", time.Second, models.DeliveryPending, now.Add(time.Hour)); err != nil {
+		t.Fatalf("RecordAttempt with a lost lease: %v", err)
+	}
+	if delivery := onlyDelivery(t, d, webhook.ID); delivery.Attempts != 0 || delivery.Status != models.DeliveryPending {
+		t.Fatalf("delivery after a lost lease = %+v, want untouched", delivery)
+	}
+	ok := sql.NullInt64{Int64: http.StatusOK, Valid: true}
+	if err := WebhookDB.RecordAttempt(ctx, &second[0], ok, "", time.Second, models.DeliveryDelivered, now); err != nil {
+		t.Fatalf("RecordAttempt: %v", err)
+	}
+	if delivery := onlyDelivery(t, d, webhook.ID); delivery.Attempts != 1 || delivery.Status != models.DeliveryDelivered {
+		t.Errorf("delivery = %+v, want delivered after 1 attempt", delivery)
+	}
+	attempts, err := WebhookDB.Attempts(ctx, first[0].ID)
+	if err != nil || len(attempts) != 1 {
+		t.Errorf("got %d logged attempts, %v, want 1", len(attempts), err)
+	}
+}
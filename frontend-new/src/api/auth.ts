@@ -2,12 +2,17 @@ import client from './client';
 import type { User } from '../types/models';
 
 
-export const login = async (username: string): Promise<User> => {
-    const response = await client.post<User>('users/login', {username});
+export const login = async (username: string, password: string): Promise<User> => {
+    const response = await client.post<User>('users/login', {username, password});
     return response.data;
 }
-export const register = async (username: string): Promise<User> => {
-    const response = await client.post<User>('users/register', {username});
+export const register = async (username: string, password: string): Promise<User> => {
+    const response = await client.post<User>('users/register', {username, password});
+    return response.data;
+}
+// Sets the first password of an account created before passwords were required, token is the setup token an admin issued
+export const setupPassword = async (username: string, token: string, password: string): Promise<User> => {
+    const response = await client.post<User>('users/password/setup', {username, token, password});
     return response.data;
 }
 export const fetchCurrentUser = async (): Promise<User> => {
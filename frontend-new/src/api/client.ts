@@ -1,4 +1,4 @@
-import axios from "axios";
+import axios, { AxiosError, type InternalAxiosRequestConfig } from "axios";
 
 const baseURL = import.meta.env.VITE_API_URL || "http://localhost:8080";
 
@@ -7,4 +7,44 @@ const instance = axios.create({
 	withCredentials: true,
 });
 
-export default instance;
\ No newline at end of file
+// Access tokens are short lived, so when a request is rejected we try to refresh the session once
+// and replay the request. Concurrent failures share the same refresh call.
+let refreshing: Promise<void> | null = null;
+
+// Requests that should never trigger a refresh, either because they are the refresh itself or they log in from scratch
+const skipRefresh = [
+	"users/refresh",
+	"users/login",
+	"users/register",
+	"users/logout",
+	"users/password/setup",
+];
+
+instance.interceptors.response.use(undefined, async (error: AxiosError) => {
+	const request = error.config as
+		| (InternalAxiosRequestConfig & { _retried?: boolean })
+		| undefined;
+	if (
+		error.response?.status !== 401 ||
+		!request ||
+		request._retried ||
+		skipRefresh.includes(request.url ?? "")
+	) {
+		return Promise.reject(error);
+	}
+	request._retried = true;
+	try {
+		refreshing ??= instance
+			.post("users/refresh")
+			.then(() => undefined)
+			.finally(() => {
+				refreshing = null;
+			});
+		await refreshing;
+	} catch {
+		return Promise.reject(error);
+	}
+	return instance(request);
+});
+
+export default instance;
@@ -1,15 +1,9 @@
 import client from './client';
-import type { Post, Topic, Comment, SearchResult } from '../types/models';
+import type { Post, Topic, Comment, SearchResult, Page } from '../types/models';
 
-
-export const fetchAllTopics = async (size?: number, offset?: number): Promise<Topic[]> => {
-    let url = "topics"
-    if(size !== undefined && offset !== undefined){
-        url += `?size=${size}&offset=${offset}`
-
-    }
-    
-    const response = await client.get<Topic[]>(url);
+// Lists are paginated, pass the next_cursor of a page to get the page after it
+export const fetchAllTopics = async (limit?: number, cursor?: string): Promise<Page<Topic>> => {
+    const response = await client.get<Page<Topic>>('topics', { params: { limit, cursor } });
     return response.data;
 }
 
@@ -17,15 +11,15 @@ export const fetchTopicById = async (id: number): Promise<Topic> => {
     const response = await client.get<Topic>(`topics/${id}`);
     return response.data;
 }
-export const fetchPostsByTopicId = async (topicId: number): Promise<Post[]> => {
-    const response = await client.get<Post[]>(`topics/${topicId}/posts`);
+export const fetchPostsByTopicId = async (topicId: number, limit?: number, cursor?: string): Promise<Page<Post>> => {
+    const response = await client.get<Page<Post>>(`topics/${topicId}/posts`, { params: { limit, cursor } });
     return response.data;
 }
-export const createPost = async (postData: { topic_id: number; content: string; title: string; user_id: number }): Promise<Post> => {
+export const createPost = async (postData: { topic_id: number; content: string; title: string }): Promise<Post> => {
     const response = await client.post<Post>('posts', postData);
     return response.data;
 }
-export const createTopic = async (topicData: { title: string; description: string; created_by: number }): Promise<Topic> => {
+export const createTopic = async (topicData: { title: string; description: string }): Promise<Topic> => {
     const response = await client.post<Topic>('topics', topicData);
     return response.data;
 }
@@ -33,11 +27,12 @@ export const fetchPostById = async (postId: number): Promise<Post> => {
     const response = await client.get<Post>(`posts/${postId}`);
     return response.data;
 }
-export const fetchCommentsByPostId = async (postId: number): Promise<Comment[]> => {
-    const response = await client.get<Comment[]>(`posts/${postId}/comments`);
+// Comments come oldest first, so a reply is never on an earlier page than the comment it answers
+export const fetchCommentsByPostId = async (postId: number, limit?: number, cursor?: string): Promise<Page<Comment>> => {
+    const response = await client.get<Page<Comment>>(`posts/${postId}/comments`, { params: { limit, cursor } });
     return response.data;
 }
-export const createComment = async (commentData: { post_id: number; content: string; user_id: number; parent_id?: number | null }): Promise<Comment> => {
+export const createComment = async (commentData: { post_id: number; content: string; parent_id?: number | null }): Promise<Comment> => {
     const response = await client.post<Comment>('comments', commentData);
     return response.data;
 }
@@ -55,8 +50,8 @@ export const deletePost = async (postID: number): Promise<void> =>{
     await client.delete(`posts/${postID}`)
 }
 export const searchGlobal = async (query: string): Promise<SearchResult> =>{
-    const response  = await client.get<SearchResult>(`search?q=${query}`)
-    return response.data
+    const response  = await client.get<{posts: Page<Post>, topics: Page<Topic>}>('search', { params: { q: query } })
+    return { posts: response.data.posts.items, topics: response.data.topics.items }
 }
 export const deleteComment = async(commentID: number): Promise<void> =>{
     await client.delete(`comments/${commentID}`)
@@ -67,8 +62,8 @@ export const updatePost = async(postID: number, data: {title: string, content: s
 export const updateComment = async(commentID: number, content: string): Promise<void> =>{
     await client.put(`comments/${commentID}`, {content: content})
 }
-export const fetchAllPosts = async(limit?: number, offset?:number): Promise<Post[]> =>{
-    const response = await client.get<Post[]>(`posts?size=${limit}&offset=${offset}`);
+export const fetchAllPosts = async(limit?: number, cursor?: string): Promise<Page<Post>> =>{
+    const response = await client.get<Page<Post>>('posts', { params: { limit, cursor } });
     return response.data
 }
 export const updateTopic = async(topicID: number, data: {title: string, description: string}): Promise<void>=>{
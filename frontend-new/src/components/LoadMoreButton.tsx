@@ -0,0 +1,29 @@
+import { Box, Button, CircularProgress } from "@mui/material";
+
+interface LoadMoreButtonProps {
+	hasMore: boolean; // False once the last page has been loaded, the button is hidden then
+	loading: boolean;
+	onClick: () => void;
+}
+
+// Loads the next page of a list, the pages pass the next_cursor they got from the API
+const LoadMoreButton = ({ hasMore, loading, onClick }: LoadMoreButtonProps) => {
+	if (!hasMore) {
+		return null;
+	}
+	return (
+		<Box sx={{ display: "flex", justifyContent: "center", my: 2 }}>
+			<Button
+				variant="outlined"
+				onClick={onClick}
+				disabled={loading}
+				startIcon={loading ? <CircularProgress size={16} /> : null}
+				sx={{ borderRadius: 4 }}
+			>
+				Load more
+			</Button>
+		</Box>
+	);
+};
+
+export default LoadMoreButton;
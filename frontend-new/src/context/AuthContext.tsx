@@ -4,6 +4,7 @@ import {
 	login as apiLogin,
 	fetchCurrentUser,
 	logout as apiLogout,
+	setupPassword as apiSetupPassword,
 } from "../api/auth";
 import type { User } from "../types/models";
 
@@ -12,6 +13,7 @@ const defaultContextValue: AuthContextType = {
 	isAuthenticated: false,
 	isLoading: true,
 	login: async () => {},
+	setupPassword: async () => {},
 	logout: () => {},
 };
 
@@ -38,8 +40,13 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
 
 		initializeAuth();
 	}, []);
-	const login = async (username: string) => {
-		await apiLogin(username);
+	const login = async (username: string, password: string) => {
+		await apiLogin(username, password);
+		const userData = await fetchCurrentUser();
+		setUser(userData);
+	};
+	const setupPassword = async (username: string, token: string, password: string) => {
+		await apiSetupPassword(username, token, password);
 		const userData = await fetchCurrentUser();
 		setUser(userData);
 	};
@@ -55,7 +62,14 @@ export const AuthProvider: React.FC<{ children: React.ReactNode }> = ({
 
 	return (
 		<AuthContext.Provider
-			value={{ user, isAuthenticated, isLoading, login, logout }}
+			value={{
+				user,
+				isAuthenticated,
+				isLoading,
+				login,
+				setupPassword,
+				logout,
+			}}
 		>
 			{children}
 		</AuthContext.Provider>
@@ -28,7 +28,6 @@ const CreatePostPage = () => {
 			setError("You must be logged in to create a post.");
 			return;
 		}
-		const userId = user.id;
 		try {
 			setLoading(true);
 			setError(null);
@@ -37,7 +36,6 @@ const CreatePostPage = () => {
 				topic_id: parseInt(topicId, 10),
 				title: title,
 				content: content,
-				user_id: userId,
 			});
 
 			navigate(`/topics/${topicId}/posts/${resp.id}`);
@@ -25,14 +25,12 @@ const CreateTopicsPage = () => {
 			setError("You must be logged in to create a topic.");
 			return;
 		}
-		const userId = user.id;
 		try {
 			setLoading(true);
 			setError(null);
 			const resp =await createTopic({
 				title: title,
 				description: desc,
-				created_by: userId,
 			});
 			navigate(`/topics/${resp.id}`);
 		} catch (err) {
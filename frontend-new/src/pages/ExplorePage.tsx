@@ -11,17 +11,22 @@ import CardContent from "@mui/material/CardContent";
 import CardActions from "@mui/material/CardActions";
 import Button from "@mui/material/Button";
 import { CircularProgress } from "@mui/material";
+import LoadMoreButton from "../components/LoadMoreButton";
 
 const ExploreTopicsPage = () => {
 	const [topics, setTopics] = useState<Topic[]>([]);
 	const [isLoading, setIsLoading] = useState(true);
+	const [nextCursor, setNextCursor] = useState<string | undefined>();
+	const [isLoadingMore, setIsLoadingMore] = useState(false);
+
+	const LIMIT = 24;
 
 	useEffect(() => {
 		const loadTopics = async () => {
 			try {
-				const data = await fetchAllTopics();
-				data.sort((a, b) => a.title.localeCompare(b.title));
-				setTopics(data);
+				const page = await fetchAllTopics(LIMIT);
+				setTopics(page.items);
+				setNextCursor(page.next_cursor);
 			} catch (error) {
 				console.error("Failed to fetch topics", error);
 			} finally {
@@ -30,6 +35,20 @@ const ExploreTopicsPage = () => {
 		};
 		loadTopics();
 	}, []);
+
+	const loadMoreTopics = async () => {
+		if (!nextCursor) return;
+		setIsLoadingMore(true);
+		try {
+			const page = await fetchAllTopics(LIMIT, nextCursor);
+			setTopics((prevTopics) => [...prevTopics, ...page.items]);
+			setNextCursor(page.next_cursor);
+		} catch (error) {
+			console.error("Failed to fetch more topics", error);
+		} finally {
+			setIsLoadingMore(false);
+		}
+	};
 	if (isLoading) {
 		return (
 			<Container
@@ -105,6 +124,11 @@ const ExploreTopicsPage = () => {
 					</Grid>
 				))}
 			</Grid>
+			<LoadMoreButton
+				hasMore={!!nextCursor}
+				loading={isLoadingMore}
+				onClick={loadMoreTopics}
+			/>
 		</Container>
 	);
 };
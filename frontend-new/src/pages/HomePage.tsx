@@ -1,7 +1,7 @@
 import { useEffect, useState } from "react";
 import { useNavigate, useSearchParams } from "react-router-dom";
 import { fetchAllPosts, fetchAllTopics, searchGlobal } from "../api/forum";
-import type { SearchResult, Topic } from "../types/models";
+import type { SearchResult } from "../types/models";
 import {
 	Container,
 	Grid,
@@ -61,9 +61,10 @@ const HomePage = () => {
 						data.posts = [];
 					}
 				} else {
-					const postData = await fetchAllPosts(LIMIT, 0);
-					const topicData: Topic[] = await fetchAllTopics();
-					setData({ posts: postData, topics: topicData });
+					const postData = await fetchAllPosts(LIMIT);
+					// The top communities are picked from the first page of topics instead of loading all of them
+					const topicData = await fetchAllTopics(100);
+					setData({ posts: postData.items, topics: topicData.items });
 				}
 			} catch (error) {
 				console.error("Failed to load data", error);
@@ -14,11 +14,16 @@ import {
 import { AxiosError } from "axios";
 
 const LoginPage: React.FC = () => {
-	const { login } = useAuth();
+	const { login, setupPassword } = useAuth();
 
 	const navigate = useNavigate();
 
 	const [username, setUsername] = useState("");
+	const [password, setPassword] = useState("");
+	// Accounts created before passwords were required sign in once with the setup token an admin gave them,
+	// then choose a password. The token is kept here while the new password is typed in.
+	const [setupToken, setSetupToken] = useState("");
+	const needsSetup = setupToken !== "";
 	const [error, setError] = useState("");
 	const [loading, setLoading] = useState(false);
 
@@ -28,11 +33,21 @@ const LoginPage: React.FC = () => {
 		setLoading(true);
 
 		try {
-			await login(username);
+			if (needsSetup) {
+				await setupPassword(username, setupToken, password);
+			} else {
+				await login(username, password);
+			}
 
 			navigate("/");
 		} catch (err) {
 			console.error(err);
+			if (err instanceof AxiosError && err.response?.status === 428) {
+				setSetupToken(password);
+				setPassword("");
+				setError("");
+				return;
+			}
 			setError(
 				err instanceof AxiosError ? err.response?.data : "Login failed",
 			);
@@ -78,6 +93,13 @@ const LoginPage: React.FC = () => {
 						Welcome Back
 					</Typography>
 
+					{needsSetup && (
+						<Alert severity="info" sx={{ mb: 2 }}>
+							Your account does not have a password yet. Choose
+							the password you want to sign in with from now on.
+						</Alert>
+					)}
+
 					{error && (
 						<Alert severity="error" sx={{ mb: 2 }}>
 							{error}
@@ -97,6 +119,21 @@ const LoginPage: React.FC = () => {
 							onChange={(e) => setUsername(e.target.value)}
 							disabled={loading}
 						/>
+						<TextField
+							margin="normal"
+							required
+							fullWidth
+							id="password"
+							label={needsSetup ? "New Password" : "Password"}
+							name="password"
+							type="password"
+							autoComplete={
+								needsSetup ? "new-password" : "current-password"
+							}
+							value={password}
+							onChange={(e) => setPassword(e.target.value)}
+							disabled={loading}
+						/>
 
 						<Button
 							type="submit"
@@ -117,7 +154,11 @@ const LoginPage: React.FC = () => {
 							}}
 							disabled={loading}
 						>
-							{loading ? "Signing in..." : "Sign In"}
+							{loading
+								? "Signing in..."
+								: needsSetup
+									? "Set Password"
+									: "Sign In"}
 						</Button>
 					</Box>
 
@@ -23,6 +23,7 @@ import DeleteIcon from "@mui/icons-material/Delete";
 import CommentBox from "../components/CommentBox";
 import EditIcon from "@mui/icons-material/Edit";
 import EditModal from "../components/EditModal";
+import LoadMoreButton from "../components/LoadMoreButton";
 import { Link } from "@mui/material";
 import { buildCommentTree } from "../utils/commentTree";
 import type { CommentNode } from "../utils/commentTree";
@@ -38,6 +39,10 @@ const PostPage = () => {
 	const [isEditModalOpen, setIsEditModalOpen] = useState(false);
 	const { isAuthenticated, user } = useAuth();
 	const [rootComments, setRootComments] = useState<CommentNode[]>([]);
+	const [nextCursor, setNextCursor] = useState<string | undefined>();
+	const [isLoadingMore, setIsLoadingMore] = useState(false);
+
+	const LIMIT = 50;
 	const handleSubmit = async (
 		text: string,
 		parentId: number | null = null,
@@ -55,7 +60,6 @@ const PostPage = () => {
 			await createComment({
 				post_id: postIdNum,
 				content: text,
-				user_id: user!.id,
 				parent_id: parentId,
 			});
 			navigate(0);
@@ -159,7 +163,8 @@ const PostPage = () => {
 				title: newTitle,
 				content: newContent,
 			});
-			setPost({ ...post, title: newTitle, content: newContent });
+			// Refetch so the rendered content matches what was saved
+			setPost(await fetchPostById(postIDNum));
 			setIsEditModalOpen(false);
 		} catch (error) {
 			console.error("Error updating post", error);
@@ -190,11 +195,14 @@ const PostPage = () => {
 					return;
 				}
 				const postData = await fetchPostById(postIdNum);
-				const commentsData = await fetchCommentsByPostId(postIdNum);
-				const tree = commentsData ? buildCommentTree(commentsData) : [];
-				setRootComments(tree);
+				const commentsPage = await fetchCommentsByPostId(
+					postIdNum,
+					LIMIT,
+				);
+				setRootComments(buildCommentTree(commentsPage.items));
 				setPost(postData);
-				setComments(commentsData);
+				setComments(commentsPage.items);
+				setNextCursor(commentsPage.next_cursor);
 			} catch (error) {
 				console.error("Failed to fetch topic or posts:", error);
 				setError("Failed to load topic. It may not exist.");
@@ -204,6 +212,27 @@ const PostPage = () => {
 		};
 		loadPostAndComments();
 	}, [postId, topicId]);
+
+	const loadMoreComments = async () => {
+		if (!post || !nextCursor) return;
+		setIsLoadingMore(true);
+		try {
+			const commentsPage = await fetchCommentsByPostId(
+				post.id,
+				LIMIT,
+				nextCursor,
+			);
+			// Replies on the new page can belong to comments from earlier pages, so the tree is rebuilt from every comment
+			const allComments = [...comments, ...commentsPage.items];
+			setComments(allComments);
+			setRootComments(buildCommentTree(allComments));
+			setNextCursor(commentsPage.next_cursor);
+		} catch (error) {
+			console.error("Failed to fetch more comments:", error);
+		} finally {
+			setIsLoadingMore(false);
+		}
+	};
 	if (loading) {
 		return (
 			<Box display="flex" justifyContent="center" mt={4}>
@@ -267,9 +296,13 @@ const PostPage = () => {
 					{timeAgo(post.created_at)} (on {formatDate(post.created_at)}
 					)
 				</Typography>
-				<Typography variant="subtitle1" color="black" gutterBottom>
-					{post.content}
-				</Typography>
+				<Typography
+					variant="subtitle1"
+					color="black"
+					gutterBottom
+					component="div"
+					dangerouslySetInnerHTML={{ __html: post.content_html }}
+				/>
 				<Box
 					sx={{
 						display: "flex",
@@ -364,6 +397,11 @@ const PostPage = () => {
 						/>
 					))
 				)}
+				<LoadMoreButton
+					hasMore={!!nextCursor}
+					loading={isLoadingMore}
+					onClick={loadMoreComments}
+				/>
 			</Box>
 			{post && (
 				<EditModal
@@ -19,6 +19,7 @@ const LoginPage: React.FC = () => {
 	const navigate = useNavigate();
 
 	const [username, setUsername] = useState("");
+	const [password, setPassword] = useState("");
 	const [error, setError] = useState("");
 	const [loading, setLoading] = useState(false);
 
@@ -32,8 +33,8 @@ const LoginPage: React.FC = () => {
 		setLoading(true);
 
 		try {
-			await register(username);
-			await login(username);
+			await register(username, password);
+			await login(username, password);
 
 			navigate("/");
 		} catch (err) {
@@ -103,6 +104,19 @@ const LoginPage: React.FC = () => {
 							onChange={(e) => setUsername(e.target.value)}
 							disabled={loading}
 						/>
+						<TextField
+							margin="normal"
+							required
+							fullWidth
+							id="password"
+							label="Password"
+							name="password"
+							type="password"
+							autoComplete="new-password"
+							value={password}
+							onChange={(e) => setPassword(e.target.value)}
+							disabled={loading}
+						/>
 
 						<Button
 							type="submit"
@@ -6,6 +6,7 @@ import { useAuth } from "../context/AuthContext";
 import { timeAgo, formatDate } from "../utils/date";
 import Box from "@mui/material/Box";
 import EditModal from "../components/EditModal";
+import LoadMoreButton from "../components/LoadMoreButton";
 import {
 	Container,
 	CircularProgress,
@@ -26,8 +27,12 @@ const TopicPage = () => {
 	const [posts, setPosts] = useState<Post[]>([]);
 	const [error, setError] = useState<string | null>(null);
 	const [isEditModalOpen, setIsEditModalOpen] = useState(false);
+	const [nextCursor, setNextCursor] = useState<string | undefined>();
+	const [isLoadingMore, setIsLoadingMore] = useState(false);
 	const { isAuthenticated, user } = useAuth();
 
+	const LIMIT = 20;
+
 	const handleUpdateTopic = async (title: string, description: string) => {
 		if (!topic) return;
 		try {
@@ -52,8 +57,9 @@ const TopicPage = () => {
 					return;
 				}
 				const topicData = await fetchTopicById(topicIdNum);
-				const postsData = await fetchPostsByTopicId(topicIdNum);
-				setPosts(postsData);
+				const postsPage = await fetchPostsByTopicId(topicIdNum, LIMIT);
+				setPosts(postsPage.items);
+				setNextCursor(postsPage.next_cursor);
 				setTopic(topicData);
 			} catch (error) {
 				console.error("Failed to fetch topic or posts:", error);
@@ -65,6 +71,24 @@ const TopicPage = () => {
 
 		loadTopicAndPosts();
 	}, [topicId]);
+
+	const loadMorePosts = async () => {
+		if (!topic || !nextCursor) return;
+		setIsLoadingMore(true);
+		try {
+			const postsPage = await fetchPostsByTopicId(
+				topic.id,
+				LIMIT,
+				nextCursor,
+			);
+			setPosts((prevPosts) => [...prevPosts, ...postsPage.items]);
+			setNextCursor(postsPage.next_cursor);
+		} catch (error) {
+			console.error("Failed to fetch more posts:", error);
+		} finally {
+			setIsLoadingMore(false);
+		}
+	};
 	if (loading) {
 		return (
 			<Box display="flex" justifyContent="center" mt={4}>
@@ -213,6 +237,11 @@ const TopicPage = () => {
 						</Grid>
 					))
 				)}
+				<LoadMoreButton
+					hasMore={!!nextCursor}
+					loading={isLoadingMore}
+					onClick={loadMorePosts}
+				/>
 			</Box>
 			{topic && (
 				<EditModal
@@ -4,7 +4,8 @@ interface AuthContextType {
     user: User | null;
     isAuthenticated: boolean;
     isLoading: boolean;
-    login: (username: string) => Promise<void>;
+    login: (username: string, password: string) => Promise<void>;
+    setupPassword: (username: string, token: string, password: string) => Promise<void>;
     logout: () => void;
 }
 
@@ -2,6 +2,9 @@ interface User {
     id: number;
     username: string;
     created_at: string;
+    role: "user" | "moderator" | "admin";
+    // Only set on the current user, see GET /api/users/me
+    unread_notifications?: number;
 }
 
 interface Topic {
@@ -12,13 +15,25 @@ interface Topic {
     user_id: number;
     username: string;
     post_count: number;
+    subscriber_count: number;
+    subscribed: boolean;
+}
+
+// Count of every reaction kind that has any and the kinds the current user picked, see GET /api/reactions
+interface Reactions {
+    counts: Record<string, number>;
+    mine: string[];
 }
 
 interface Post {
     id: number;
     title: string;
     content: string;
+    content_html: string;
     likes: number;
+    comment_count: number;
+    edit_count: number;
+    edited: boolean;
     created_at: string;
     updated_at: string;
     topic_id: number;
@@ -26,11 +41,14 @@ interface Post {
     topic_title: string;
     username: string;
     liked_by_user: boolean;
+    bookmarked_by_user: boolean;
+    reactions: Reactions;
 }
 
 interface Comment {
     id: number;
     content: string;
+    content_html: string;
     likes: number;
     created_at: string;
     updated_at: string;
@@ -39,11 +57,19 @@ interface Comment {
     deleted: boolean;
     parent_comment_id: {Int64: number, Valid: boolean};
     liked_by_user: boolean;
+    bookmarked_by_user: boolean;
+    reactions: Reactions;
     username: string;
 }
 interface SearchResult {
     posts: Post[];
     topics: Topic[];
 }
+// Envelope returned by every list endpoint, pass next_cursor back as ?cursor= to get the next page
+interface Page<T> {
+    items: T[];
+    next_cursor?: string;
+    prev_cursor?: string;
+}
 
-export type { User, Topic, Post, Comment, SearchResult };
\ No newline at end of file
+export type { User, Topic, Reactions, Post, Comment, SearchResult, Page };
\ No newline at end of file